@@ -158,6 +158,10 @@ class UsqueVpnService : VpnService() {
                 override fun onError(message: String?) {
                     Log.e(TAG, "MASQUE tunnel error: $message")
                 }
+
+                override fun onReconnecting(attempt: Long, reason: String?) {
+                    Log.w(TAG, "MASQUE tunnel reconnecting (attempt $attempt): $reason")
+                }
             }
 
             // Start the Go tunnel with our TUN file descriptor
@@ -9,12 +9,18 @@ package usqueandroid
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +43,9 @@ type VpnStateCallback interface {
 	OnConnected()
 	// OnDisconnected is called when the VPN disconnects
 	OnDisconnected(reason string)
+	// OnReconnecting is called when the connection to Cloudflare failed or was lost
+	// and another attempt will be made. attempt counts the consecutive failures.
+	OnReconnecting(attempt int, reason string)
 	// OnError is called when an error occurs
 	OnError(message string)
 }
@@ -44,8 +53,13 @@ type VpnStateCallback interface {
 // tunnelState holds the state of the running tunnel
 type tunnelState struct {
 	mu        sync.Mutex
-	running   bool
+	running   bool // true until the tunnel goroutine has exited, even while stopping
+	stopping  bool // set by StopTunnel until the tunnel goroutine has exited
 	cancel    context.CancelFunc
+	done      chan struct{} // closed once the tunnel goroutine has exited
+	tunnel    *api.Tunnel
+	device    *api.CaptureAdapter
+	capture   *api.PacketCapture // attached to every tunnel started until StopCapture
 	inputChan chan []byte
 	callback  VpnStateCallback
 }
@@ -56,10 +70,43 @@ var state = &tunnelState{}
 var (
 	customSNI      = "www.visa.cn" // Default SNI for censorship circumvention
 	customEndpoint = ""            // Custom endpoint with port, e.g. "162.159.198.2:443" or "[2606:4700:103::]:1701"
+	customProfile  = ""            // Profile of the config file, empty for its default profile
+	passphrase     = ""            // Passphrase of encrypted configs, empty to store them in plaintext
+	customAPIProxy = ""            // Proxy URL for registration API requests, e.g. "socks5://127.0.0.1:1080"
+)
+
+// The config last loaded, so that every call doesn't read and decrypt it again
+var (
+	configCacheMu  sync.Mutex
+	configCache    *config.Config
+	configCacheKey configKey
+)
+
+// configKey identifies a loaded config, a changed file doesn't match anymore
+type configKey struct {
+	path       string
+	profile    string
+	passphrase string
+	modTime    time.Time
+	size       int64
+}
+
+// Reconnect options
+const (
+	defaultReconnectDelay    = time.Second
+	defaultReconnectMaxDelay = time.Minute
+)
+
+var (
+	reconnectDelay       = defaultReconnectDelay
+	reconnectMaxDelay    = defaultReconnectMaxDelay
+	reconnectMaxAttempts = 0 // 0 = never give up
 )
 
 // Register creates a new Cloudflare WARP account and saves the configuration.
 // This should be called once before starting the VPN.
+// The account is saved as the profile selected with SetProfile. An existing profile is never
+// replaced, if it can't be decrypted with the passphrase set with SetPassphrase an error is returned.
 //
 // Parameters:
 //   - configPath: Absolute path where the config.json will be saved
@@ -68,12 +115,24 @@ var (
 // Returns:
 //   - error string if registration fails, empty string on success
 func Register(configPath string, deviceName string) string {
-	// Already registered?
-	if err := config.LoadConfig(configPath); err == nil {
+	// Already registered? Checked without decrypting, so that a locked profile isn't overwritten
+	exists, err := profileExists(configPath)
+	if err != nil {
+		return fmt.Sprintf("Failed to load config: %v", err)
+	}
+	if exists {
+		if _, err := loadConfig(configPath); err != nil {
+			return fmt.Sprintf("Already registered, but the config can't be loaded: %v", err)
+		}
 		return "" // Config already exists and is valid
 	}
 
-	accountData, err := api.Register(internal.DefaultModel, internal.DefaultLocale, "", true)
+	client, err := newAPIClient()
+	if err != nil {
+		return err.Error()
+	}
+
+	accountData, err := client.Register(internal.DefaultModel, internal.DefaultLocale, "", true)
 	if err != nil {
 		return fmt.Sprintf("Registration failed: %v", err)
 	}
@@ -83,7 +142,7 @@ func Register(configPath string, deviceName string) string {
 		return fmt.Sprintf("Failed to generate key pair: %v", err)
 	}
 
-	updatedAccountData, apiErr, err := api.EnrollKey(accountData, pubKey, deviceName)
+	updatedAccountData, apiErr, err := client.EnrollKey(accountData, pubKey, deviceName)
 	if err != nil {
 		if apiErr != nil {
 			return fmt.Sprintf("Failed to enroll key: %v (API: %s)", err, apiErr.ErrorsAsString("; "))
@@ -91,44 +150,216 @@ func Register(configPath string, deviceName string) string {
 		return fmt.Sprintf("Failed to enroll key: %v", err)
 	}
 
-	config.AppConfig = config.Config{
-		PrivateKey:     base64.StdEncoding.EncodeToString(privKey),
-		EndpointV4:     updatedAccountData.Config.Peers[0].Endpoint.V4[:len(updatedAccountData.Config.Peers[0].Endpoint.V4)-2],
-		EndpointV6:     updatedAccountData.Config.Peers[0].Endpoint.V6[1 : len(updatedAccountData.Config.Peers[0].Endpoint.V6)-3],
-		EndpointPubKey: updatedAccountData.Config.Peers[0].PublicKey,
-		License:        updatedAccountData.Account.License,
-		ID:             updatedAccountData.ID,
-		AccessToken:    accountData.Token,
-		IPv4:           updatedAccountData.Config.Interface.Addresses.V4,
-		IPv6:           updatedAccountData.Config.Interface.Addresses.V6,
+	cfg := config.Config{
+		PrivateKey:  base64.StdEncoding.EncodeToString(privKey),
+		License:     updatedAccountData.Account.License,
+		ID:          updatedAccountData.ID,
+		AccessToken: accountData.Token,
+		IPv4:        updatedAccountData.Config.Interface.Addresses.V4,
+		IPv6:        updatedAccountData.Config.Interface.Addresses.V6,
+	}
+
+	skipped, err := cfg.SetPeers(updatedAccountData.Config.Peers)
+	if err != nil {
+		return fmt.Sprintf("Failed to parse endpoints: %v", err)
+	}
+	for _, err := range skipped {
+		log.Printf("Warning: skipping %v", err)
 	}
 
-	if err := config.AppConfig.SaveConfig(configPath); err != nil {
+	if err := saveConfig(configPath, &cfg); err != nil {
 		return fmt.Sprintf("Failed to save config: %v", err)
 	}
 
 	return ""
 }
 
-// IsRegistered checks if a valid configuration exists
+// ExportBundle returns the selected profile as a connection bundle, e.g. to move it to another device
+// or to usque on a computer ("usque import bundle.json"). The bundle holds the private key in plaintext.
+//
+// Returns the JSON of the bundle or {"error":"..."} on failure.
+func ExportBundle(configPath string) string {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, fmt.Sprintf("Failed to load config: %v", err))
+	}
+	bundle, err := cfg.Bundle()
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(bundle)
+}
+
+// ImportBundle saves a connection bundle written by "usque export" or ExportBundle as the selected profile.
+// Like Register, an existing profile is kept unless overwrite is set, even if it is locked.
+//
+// Parameters:
+//   - configPath: Absolute path of the config.json
+//   - bundle: JSON of the connection bundle
+//   - overwrite: Replace the selected profile if it already exists, e.g. after the user confirmed it
+//
+// Returns:
+//   - error string if the bundle is invalid, the profile exists or can't be saved, empty string on success
+func ImportBundle(configPath string, bundle string, overwrite bool) string {
+	cfg, err := config.ParseBundle([]byte(bundle))
+	if err != nil {
+		return fmt.Sprintf("Invalid bundle: %v", err)
+	}
+	// Checked without decrypting, so that a locked profile isn't overwritten either
+	exists, err := profileExists(configPath)
+	if err != nil {
+		return fmt.Sprintf("Failed to load config: %v", err)
+	}
+	if exists && !overwrite {
+		return "Already registered, import with overwrite to replace the profile"
+	}
+	if err := saveConfig(configPath, cfg); err != nil {
+		return fmt.Sprintf("Failed to save config: %v", err)
+	}
+	return ""
+}
+
+// IsRegistered checks if the selected profile exists, even if it is locked (see IsLocked)
 func IsRegistered(configPath string) bool {
-	return config.LoadConfig(configPath) == nil
+	exists, err := profileExists(configPath)
+	return err == nil && exists
+}
+
+// IsLocked checks if the selected profile is encrypted and can't be decrypted
+// with the passphrase set with SetPassphrase, e.g. because none is set yet
+func IsLocked(configPath string) bool {
+	store, err := config.LoadStore(configPath)
+	if err != nil || !store.IsEncrypted(customProfile) {
+		return false
+	}
+	_, err = loadConfig(configPath)
+	return err != nil
+}
+
+// profileExists checks if the selected profile exists without decrypting it.
+// A missing config file is no error.
+func profileExists(configPath string) (bool, error) {
+	store, err := config.LoadOrNewStore(configPath)
+	if err != nil {
+		return false, err
+	}
+	_, err = store.Get(customProfile)
+	return err == nil, nil
+}
+
+// SetProfile selects the profile of the config file used by all other functions.
+// Pass empty string to use the default profile of the config file.
+func SetProfile(name string) {
+	customProfile = name
+	invalidateConfig()
+	log.Printf("Profile set to: %s", name)
+}
+
+// GetProfile returns the selected profile, empty for the default profile
+func GetProfile() string {
+	return customProfile
+}
+
+// SetPassphrase sets the passphrase of encrypted configs. While it is set, Register saves
+// the private key, access token and license encrypted. Pass empty string to store them in plaintext.
+func SetPassphrase(pass string) {
+	passphrase = pass
+	invalidateConfig()
+}
+
+// configPassphrase returns the passphrase set with SetPassphrase, nil if none is set
+func configPassphrase() config.Passphrase {
+	if passphrase == "" {
+		return nil
+	}
+	pass := []byte(passphrase)
+	return func() ([]byte, error) {
+		return pass, nil
+	}
+}
+
+// loadConfig loads the selected profile of the config file, decrypting it with the passphrase if needed.
+// The config is cached until the profile, passphrase or file changes.
+func loadConfig(configPath string) (*config.Config, error) {
+	key := configKey{path: configPath, profile: customProfile, passphrase: passphrase}
+	if info, err := os.Stat(configPath); err == nil {
+		key.modTime = info.ModTime()
+		key.size = info.Size()
+	}
+
+	configCacheMu.Lock()
+	defer configCacheMu.Unlock()
+	if configCache == nil || configCacheKey != key {
+		cfg, err := config.LoadConfig(configPath, customProfile, configPassphrase())
+		if err != nil {
+			return nil, err
+		}
+		configCache = cfg
+		configCacheKey = key
+	}
+
+	// callers may modify their copy
+	cfg := *configCache
+	return &cfg, nil
+}
+
+// saveConfig saves cfg as the selected profile of the config file, encrypted if a passphrase is set.
+func saveConfig(configPath string, cfg *config.Config) error {
+	defer invalidateConfig()
+	return cfg.SaveConfig(configPath, customProfile, configPassphrase())
+}
+
+// invalidateConfig drops the cached config, so that the next loadConfig reads the file again
+func invalidateConfig() {
+	configCacheMu.Lock()
+	configCache = nil
+	configCacheMu.Unlock()
+}
+
+// profilesResponse is the JSON returned by ListProfiles
+type profilesResponse struct {
+	Default  string   `json:"default,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// ListProfiles returns the profiles of a config file.
+//
+// Returns JSON: {"default":"default","profiles":["default","work"]}
+// or {"error":"..."} on failure.
+func ListProfiles(configPath string) string {
+	var resp profilesResponse
+	store, err := config.LoadStore(configPath)
+	if err != nil {
+		resp.Error = fmt.Sprintf("Failed to load profiles: %v", err)
+	} else {
+		resp.Default = store.Default
+		resp.Profiles = store.Names()
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(out)
 }
 
 // GetAssignedIPv4 returns the assigned IPv4 address from config
 func GetAssignedIPv4(configPath string) string {
-	if err := config.LoadConfig(configPath); err != nil {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
 		return ""
 	}
-	return config.AppConfig.IPv4
+	return cfg.IPv4
 }
 
 // GetAssignedIPv6 returns the assigned IPv6 address from config
 func GetAssignedIPv6(configPath string) string {
-	if err := config.LoadConfig(configPath); err != nil {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
 		return ""
 	}
-	return config.AppConfig.IPv6
+	return cfg.IPv6
 }
 
 // AndroidTunDevice wraps the Android TUN file descriptor for packet IO
@@ -199,6 +430,9 @@ func StartTunnel(configPath string, tunFd int, mtu int, packetFlow PacketFlow, c
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
+	if state.stopping {
+		return "Tunnel is still stopping, try again later"
+	}
 	if state.running {
 		return "Tunnel is already running"
 	}
@@ -206,16 +440,17 @@ func StartTunnel(configPath string, tunFd int, mtu int, packetFlow PacketFlow, c
 	log.Printf("StartTunnel called: configPath=%s, tunFd=%d, mtu=%d", configPath, tunFd, mtu)
 
 	// Load config
-	if err := config.LoadConfig(configPath); err != nil {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
 		return fmt.Sprintf("Failed to load config: %v", err)
 	}
 
 	// Get keys
-	privKey, err := config.AppConfig.GetEcPrivateKey()
+	privKey, err := cfg.GetEcPrivateKey()
 	if err != nil {
 		return fmt.Sprintf("Failed to get private key: %v", err)
 	}
-	peerPubKey, err := config.AppConfig.GetEcEndpointPublicKey()
+	peerPubKey, err := cfg.GetEcEndpointPublicKey()
 	if err != nil {
 		return fmt.Sprintf("Failed to get peer public key: %v", err)
 	}
@@ -232,7 +467,7 @@ func StartTunnel(configPath string, tunFd int, mtu int, packetFlow PacketFlow, c
 		sni = internal.ConnectSNI
 	}
 	log.Printf("Using SNI: %s", sni)
-	tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni)
+	tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, nil)
 	if err != nil {
 		return fmt.Sprintf("Failed to prepare TLS: %v", err)
 	}
@@ -243,61 +478,107 @@ func StartTunnel(configPath string, tunFd int, mtu int, packetFlow PacketFlow, c
 		return fmt.Sprintf("Failed to create TUN device: %v", err)
 	}
 
-	// Endpoint - use custom endpoint if set, otherwise use config default
-	var endpoint *net.UDPAddr
+	// Endpoints - use custom endpoints if set, otherwise race the config defaults (IPv4 first)
+	var endpoints []*net.UDPAddr
 	if customEndpoint != "" {
-		// Parse custom endpoint (supports host:port format)
-		host, port, err := parseEndpoint(customEndpoint)
-		if err != nil {
-			return fmt.Sprintf("Invalid custom endpoint '%s': %v", customEndpoint, err)
-		}
-		endpoint = &net.UDPAddr{
-			IP:   net.ParseIP(host),
-			Port: port,
+		// Parse custom endpoints (supports host:port format)
+		for _, custom := range strings.Split(customEndpoint, ",") {
+			custom = strings.TrimSpace(custom)
+			host, port, err := parseEndpoint(custom)
+			if err != nil {
+				return fmt.Sprintf("Invalid custom endpoint '%s': %v", custom, err)
+			}
+			endpoints = append(endpoints, &net.UDPAddr{
+				IP:   net.ParseIP(host),
+				Port: port,
+			})
+			log.Printf("Using custom endpoint: %s:%d", host, port)
 		}
-		log.Printf("Using custom endpoint: %s:%d", host, port)
 	} else {
-		// Use default from config (IPv4)
-		endpoint = &net.UDPAddr{
-			IP:   net.ParseIP(config.AppConfig.EndpointV4),
-			Port: 443,
+		// the endpoint from the config first, then the other peers of the registration,
+		// each on 443 and the ports stored with it
+		addPeer := func(v4, v6 string, peer *config.Peer) {
+			ports := []int{443}
+			if peer != nil {
+				for _, port := range peer.Ports {
+					if !slices.Contains(ports, port) {
+						ports = append(ports, port)
+					}
+				}
+			}
+			for _, port := range ports {
+				for _, host := range []string{v4, v6} {
+					if ip := net.ParseIP(host); ip != nil {
+						endpoints = append(endpoints, &net.UDPAddr{IP: ip, Port: port})
+						log.Printf("Using default endpoint: %s", net.JoinHostPort(host, strconv.Itoa(port)))
+					}
+				}
+			}
+		}
+		addPeer(cfg.EndpointV4, cfg.EndpointV6, cfg.EndpointPeer())
+		for _, peer := range cfg.AlternatePeers() {
+			addPeer(peer.EndpointV4, peer.EndpointV6, &peer)
+		}
+		if len(endpoints) == 0 {
+			return "No valid endpoint in config"
 		}
-		log.Printf("Using default endpoint: %s:443", config.AppConfig.EndpointV4)
 	}
 
+	reconnectPolicy := api.NewBackoffPolicy(reconnectDelay, reconnectMaxDelay, reconnectMaxAttempts)
+
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 	state.cancel = cancel
+	state.done = done
 	state.running = true
 	state.callback = callback
 
+	tunnel := api.NewTunnel(api.TunnelConfig{
+		TLSConfig:         tlsConfig,
+		KeepalivePeriod:   30 * time.Second,
+		InitialPacketSize: 1242,
+		Endpoints:         api.InterleaveEndpoints(endpoints),
+		MTU:               mtu,
+		ReconnectPolicy:   reconnectPolicy,
+	})
+	state.tunnel = tunnel
+	device := api.NewCaptureAdapter(tunDevice, state.capture)
+	state.device = device
+	if callback != nil {
+		tunnel.Subscribe(func(event api.TunnelEvent) {
+			switch event.State {
+			case api.TunnelConnected:
+				callback.OnConnected()
+			case api.TunnelDisconnected:
+				callback.OnReconnecting(event.Attempt, event.Err.Error())
+			}
+		})
+	}
+
 	// Start tunnel maintenance in background
 	go func() {
+		defer close(done)
 		log.Println("Starting MASQUE tunnel...")
 
-		// Notify connected after a brief delay for connection establishment
-		go func() {
-			time.Sleep(3 * time.Second)
-			state.mu.Lock()
-			running := state.running
-			state.mu.Unlock()
-			if running && callback != nil {
-				callback.OnConnected()
-			}
-		}()
-
-		api.MaintainTunnel(ctx, tlsConfig, 30*time.Second, 1242, endpoint, tunDevice, mtu, time.Second)
+		err := tunnel.Run(ctx, device)
 
 		// Tunnel exited
-		log.Println("MASQUE tunnel exited")
+		log.Printf("MASQUE tunnel exited: %v", err)
 		tunDevice.Close()
 
 		state.mu.Lock()
 		state.running = false
+		state.stopping = false
 		state.mu.Unlock()
 
 		if callback != nil {
-			callback.OnDisconnected("Tunnel closed")
+			if errors.Is(err, context.Canceled) {
+				callback.OnDisconnected("Tunnel closed")
+			} else {
+				callback.OnError(err.Error())
+				callback.OnDisconnected(err.Error())
+			}
 		}
 	}()
 
@@ -325,31 +606,169 @@ func InputPacket(data []byte) {
 	}
 }
 
-// StopTunnel stops the running tunnel
+// StopTunnel stops the running tunnel and waits (up to a few seconds)
+// until the MASQUE connection has been torn down. If that takes longer,
+// StartTunnel fails until the tunnel has stopped.
 func StopTunnel() {
 	state.mu.Lock()
-	defer state.mu.Unlock()
-
 	if !state.running {
+		state.mu.Unlock()
 		return
 	}
 
-	log.Println("Stopping tunnel...")
+	if !state.stopping {
+		log.Println("Stopping tunnel...")
+		state.stopping = true
+		if state.cancel != nil {
+			state.cancel()
+		}
+	}
+	done := state.done
+	state.mu.Unlock()
 
-	if state.cancel != nil {
-		state.cancel()
+	if done != nil {
+		select {
+		case <-done:
+			log.Println("Tunnel stopped")
+		case <-time.After(5 * time.Second):
+			log.Println("Timed out waiting for tunnel to stop")
+		}
 	}
+}
 
-	state.running = false
+// Reconnect drops the current MASQUE session and connects again right away,
+// e.g. after the device switched networks. Does nothing if the tunnel isn't running.
+func Reconnect() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.running && state.tunnel != nil {
+		state.tunnel.Reconnect()
+	}
 }
 
-// IsRunning returns true if the tunnel is currently running
+// IsRunning returns true if the tunnel is currently running, including while it is still stopping
 func IsRunning() bool {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 	return state.running
 }
 
+// StartCapture writes the IP packets of both directions into a pcapng file, e.g. in the
+// app's files directory, until StopCapture is called. It can be called while the tunnel is
+// running and keeps capturing across tunnel restarts. A capture already running is replaced.
+//
+// Parameters:
+//   - path: The pcapng file to write to, overwritten if it exists
+//   - maxSizeMb: Rotate the file after this many MiB (0 = no limit); rotated files are named like name.1.pcapng
+//   - maxFiles: Number of files to keep when rotating, including the current one
+//   - filter: tcpdump-like filter expression, e.g. "tcp port 443", or empty string to capture everything
+//
+// Returns an empty string on success or an error message.
+func StartCapture(path string, maxSizeMb int64, maxFiles int, filter string) string {
+	capture, err := api.NewPacketCapture(api.CaptureConfig{
+		Path:     path,
+		MaxSize:  maxSizeMb << 20,
+		MaxFiles: maxFiles,
+		Filter:   filter,
+	})
+	if err != nil {
+		return fmt.Sprintf("Failed to start capture: %v", err)
+	}
+
+	state.mu.Lock()
+	previous := state.capture
+	state.capture = capture
+	if state.device != nil {
+		state.device.SetCapture(capture)
+	}
+	state.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	log.Printf("Capturing packets to %s", path)
+	return ""
+}
+
+// StopCapture stops the capture started by StartCapture and closes the file.
+// Returns the number of captured packets, 0 if no capture was running.
+func StopCapture() int64 {
+	state.mu.Lock()
+	capture := state.capture
+	state.capture = nil
+	if state.device != nil {
+		state.device.SetCapture(nil)
+	}
+	state.mu.Unlock()
+
+	if capture == nil {
+		return 0
+	}
+	if err := capture.Close(); err != nil {
+		log.Printf("Failed to close capture file: %v", err)
+	}
+	log.Printf("Captured %d packets", capture.Packets())
+	return int64(capture.Packets())
+}
+
+// TunnelStats is a snapshot of the traffic and health counters of the tunnel.
+// Durations are in milliseconds.
+type TunnelStats struct {
+	Connected       bool
+	Endpoint        string
+	PacketsSent     int64
+	BytesSent       int64
+	PacketsReceived int64
+	BytesReceived   int64
+	DroppedPackets  int64
+	WriteErrors     int64
+	ICMPReplies     int64
+	Reconnects      int64
+	FailedAttempts  int64
+	UptimeMs        int64
+	HandshakeMs     int64
+	SmoothedRttMs   int64
+	MinRttMs        int64
+	QuicPacketsSent int64
+	QuicPacketsLost int64
+}
+
+// GetStats returns the statistics of the current (or last) tunnel,
+// or nil if no tunnel has been started yet.
+func GetStats() *TunnelStats {
+	state.mu.Lock()
+	tunnel := state.tunnel
+	state.mu.Unlock()
+
+	if tunnel == nil {
+		return nil
+	}
+
+	stats := tunnel.Stats()
+	result := &TunnelStats{
+		Connected:       stats.Connected,
+		Endpoint:        stats.Endpoint,
+		PacketsSent:     int64(stats.PacketsSent),
+		BytesSent:       int64(stats.BytesSent),
+		PacketsReceived: int64(stats.PacketsReceived),
+		BytesReceived:   int64(stats.BytesReceived),
+		DroppedPackets:  int64(stats.DroppedPackets),
+		WriteErrors:     int64(stats.WriteErrors),
+		ICMPReplies:     int64(stats.ICMPReplies),
+		Reconnects:      int64(stats.Reconnects),
+		FailedAttempts:  int64(stats.FailedAttempts),
+		UptimeMs:        stats.SessionUptime.Milliseconds(),
+		HandshakeMs:     stats.LastHandshake.Milliseconds(),
+	}
+	if stats.QUIC != nil {
+		result.SmoothedRttMs = stats.QUIC.SmoothedRTT.Milliseconds()
+		result.MinRttMs = stats.QUIC.MinRTT.Milliseconds()
+		result.QuicPacketsSent = int64(stats.QUIC.PacketsSent)
+		result.QuicPacketsLost = int64(stats.QUIC.PacketsLost)
+	}
+	return result
+}
+
 // GetVersion returns the library version
 func GetVersion() string {
 	return "1.0.3-android"
@@ -414,6 +833,134 @@ func parseEndpoint(endpoint string) (string, int, error) {
 	return endpoint, 443, nil
 }
 
+// ============================================
+// Endpoint Scanning
+// ============================================
+
+// scanResult is a single endpoint in the JSON returned by ScanEndpoints.
+type scanResult struct {
+	Endpoint   string  `json:"endpoint"`
+	OK         bool    `json:"ok"`
+	Reachable  bool    `json:"reachable"`
+	LatencyMs  float64 `json:"latency_ms"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// scanResponse is the JSON returned by ScanEndpoints.
+type scanResponse struct {
+	Results []scanResult `json:"results,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// ScanEndpoints probes endpoints with a real MASQUE handshake using the current SNI
+// and ranks them by latency, working endpoints first. Blocks until the scan is done,
+// so call it from a background thread.
+//
+// targets is a comma-separated list of addresses or CIDR prefixes, optionally with a port
+// (e.g. "162.159.198.0/24,[2606:4700:103::1]:4500"); pass empty string to scan the endpoints
+// from config.json. ports is a comma-separated list of ports for targets without one;
+// pass empty string for the ports Cloudflare is known to listen on.
+//
+// Returns JSON: {"results":[{"endpoint":"162.159.198.1:443","ok":true,"reachable":true,"latency_ms":42.1,"status_code":200},...]}
+// or {"error":"..."} on failure. A working endpoint can be passed to SetEndpoint as is.
+func ScanEndpoints(configPath string, targets string, ports string, timeoutMs int64) string {
+	return marshalScanResponse(scanEndpoints(configPath, targets, ports, timeoutMs))
+}
+
+func scanEndpoints(configPath string, targets string, ports string, timeoutMs int64) scanResponse {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Failed to load config: %v", err)}
+	}
+
+	privKey, err := cfg.GetEcPrivateKey()
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Failed to get private key: %v", err)}
+	}
+	peerPubKey, err := cfg.GetEcEndpointPublicKey()
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Failed to get peer public key: %v", err)}
+	}
+	cert, err := internal.GenerateCert(privKey, &privKey.PublicKey)
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Failed to generate cert: %v", err)}
+	}
+
+	sni := customSNI
+	if sni == "" {
+		sni = internal.ConnectSNI
+	}
+	tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, nil)
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Failed to prepare TLS: %v", err)}
+	}
+
+	scanPorts := internal.DefaultScanPorts
+	if ports != "" {
+		scanPorts = nil
+		for _, p := range strings.Split(ports, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || port < 1 || port > 65535 {
+				return scanResponse{Error: fmt.Sprintf("Invalid port '%s'", p)}
+			}
+			scanPorts = append(scanPorts, port)
+		}
+	}
+
+	var targetList []string
+	if targets != "" {
+		targetList = strings.Split(targets, ",")
+	} else {
+		targetList = []string{cfg.EndpointV4, cfg.EndpointV6}
+	}
+	endpoints, err := internal.ExpandScanTargets(targetList, scanPorts, 4096)
+	if err != nil {
+		return scanResponse{Error: fmt.Sprintf("Invalid targets: %v", err)}
+	}
+
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	log.Printf("Scanning %d endpoints", len(endpoints))
+	results := api.ScanEndpoints(
+		context.Background(),
+		tlsConfig,
+		internal.DefaultQuicConfig(30*time.Second, 1242),
+		internal.ConnectURI,
+		endpoints,
+		16,
+		timeout,
+		nil,
+	)
+
+	response := scanResponse{Results: make([]scanResult, 0, len(results))}
+	for _, result := range results {
+		entry := scanResult{
+			Endpoint:   result.Endpoint.String(),
+			OK:         result.OK,
+			Reachable:  result.Reachable,
+			LatencyMs:  float64(result.Latency.Microseconds()) / 1000,
+			StatusCode: result.StatusCode,
+		}
+		if result.Err != nil {
+			entry.Error = result.Err.Error()
+		}
+		response.Results = append(response.Results, entry)
+	}
+	return response
+}
+
+func marshalScanResponse(response scanResponse) string {
+	out, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(out)
+}
+
 // ============================================
 // Connection Configuration Functions
 // ============================================
@@ -438,7 +985,8 @@ func GetSNI() string {
 //   - "[2606:4700:103::]" (IPv6, default port 443)
 //   - "[2606:4700:103::]:1701" (IPv6 with custom port)
 //
-// Pass empty string to use the default endpoint from config.json.
+// Several endpoints separated by commas are raced (Happy Eyeballs) and the tunnel
+// fails over between them. Pass empty string to race the IPv4 and IPv6 endpoints from config.json.
 func SetEndpoint(endpoint string) {
 	customEndpoint = endpoint
 	log.Printf("Custom endpoint set to: %s", endpoint)
@@ -451,16 +999,65 @@ func GetEndpoint() string {
 
 // GetDefaultEndpoint returns the default endpoint from config (IPv4:443)
 func GetDefaultEndpoint(configPath string) string {
-	if err := config.LoadConfig(configPath); err == nil {
-		return config.AppConfig.EndpointV4 + ":443"
+	if cfg, err := loadConfig(configPath); err == nil {
+		return cfg.EndpointV4 + ":443"
+	}
+	return ""
+}
+
+// SetReconnectPolicy configures how the tunnel reconnects after a failure.
+// The delay starts at initialDelayMs and doubles (with jitter) after every
+// consecutive failure up to maxDelayMs. After maxAttempts consecutive failures
+// the tunnel gives up and OnError/OnDisconnected are called; pass 0 to retry forever.
+// Authentication failures always stop the tunnel immediately.
+// Takes effect the next time the tunnel is started.
+func SetReconnectPolicy(initialDelayMs int64, maxDelayMs int64, maxAttempts int) string {
+	if initialDelayMs <= 0 || maxDelayMs < initialDelayMs || maxAttempts < 0 {
+		return "Invalid reconnect policy"
 	}
+	reconnectDelay = time.Duration(initialDelayMs) * time.Millisecond
+	reconnectMaxDelay = time.Duration(maxDelayMs) * time.Millisecond
+	reconnectMaxAttempts = maxAttempts
+	log.Printf("Reconnect policy set to: delay=%s maxDelay=%s maxAttempts=%d", reconnectDelay, reconnectMaxDelay, reconnectMaxAttempts)
 	return ""
 }
 
+// SetAPIProxy routes the registration API requests through an HTTP or SOCKS5 proxy,
+// e.g. "socks5://127.0.0.1:1080", where the API is blocked. Pass empty string to connect directly.
+func SetAPIProxy(proxy string) string {
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" && u.Scheme != "socks5h") {
+			return "Invalid API proxy, expected http://, https:// or socks5://host:port"
+		}
+	}
+	customAPIProxy = proxy
+	log.Printf("API proxy set to: %s", proxy)
+	return ""
+}
+
+// newAPIClient returns the client for the registration API using the proxy set with SetAPIProxy.
+func newAPIClient() (*api.Client, error) {
+	var clientConfig api.ClientConfig
+	if customAPIProxy != "" {
+		proxyURL, err := url.Parse(customAPIProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API proxy: %v", err)
+		}
+		clientConfig.Proxy = http.ProxyURL(proxyURL)
+	}
+	return api.NewClient(clientConfig), nil
+}
+
 // ResetConnectionOptions resets all connection options to defaults
 func ResetConnectionOptions() {
 	customSNI = "www.visa.cn"
 	customEndpoint = ""
+	customProfile = ""
+	customAPIProxy = ""
+	reconnectDelay = defaultReconnectDelay
+	reconnectMaxDelay = defaultReconnectMaxDelay
+	reconnectMaxAttempts = 0
 	log.Println("Connection options reset to defaults")
 }
 
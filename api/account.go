@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Diniboy1123/usque/models"
+)
+
+// GetRegistration fetches the current state of the registered device, including its account and config.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device to query, only ID and Token are used.
+//
+// Returns:
+//   - models.AccountData: The device as known by the API.
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) GetRegistration(accountData models.AccountData) (models.AccountData, *models.APIError, error) {
+	var result models.AccountData
+	apiErr, err := c.request(http.MethodGet, "/reg/"+accountData.ID, bearer(accountData.Token), nil, &result)
+	if err != nil {
+		return models.AccountData{}, apiErr, err
+	}
+	return result, nil, nil
+}
+
+// GetAccount fetches the account the device is registered to, e.g. to check its WARP+ quota.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device to query, only ID and Token are used.
+//
+// Returns:
+//   - models.Account: The account of the device.
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) GetAccount(accountData models.AccountData) (models.Account, *models.APIError, error) {
+	var result models.Account
+	apiErr, err := c.request(http.MethodGet, "/reg/"+accountData.ID+"/account", bearer(accountData.Token), nil, &result)
+	if err != nil {
+		return models.Account{}, apiErr, err
+	}
+	return result, nil, nil
+}
+
+// GetBoundDevices lists the devices bound to the account of the device.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device to query, only ID and Token are used.
+//
+// Returns:
+//   - []models.BoundDevice: The devices of the account, including this one.
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) GetBoundDevices(accountData models.AccountData) ([]models.BoundDevice, *models.APIError, error) {
+	var result []models.BoundDevice
+	apiErr, err := c.request(http.MethodGet, "/reg/"+accountData.ID+"/account/devices", bearer(accountData.Token), nil, &result)
+	if err != nil {
+		return nil, apiErr, err
+	}
+	return result, nil, nil
+}
+
+// UpdateBoundDevice renames or (de)activates a device bound to the account of the device.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device authenticating the request, only ID and Token are used.
+//   - deviceID: string - The ID of the device to update, may be the authenticating one.
+//   - update: models.BoundDeviceUpdate - The fields to change.
+//
+// Returns:
+//   - []models.BoundDevice: The devices of the account after the update.
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) UpdateBoundDevice(accountData models.AccountData, deviceID string, update models.BoundDeviceUpdate) ([]models.BoundDevice, *models.APIError, error) {
+	var result []models.BoundDevice
+	apiErr, err := c.request(http.MethodPatch, "/reg/"+accountData.ID+"/account/reg/"+deviceID, bearer(accountData.Token), update, &result)
+	if err != nil {
+		return nil, apiErr, err
+	}
+	return result, nil, nil
+}
+
+// UpdateLicense binds the device to the account of another license key, e.g. a WARP+ one.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device to update, only ID and Token are used.
+//   - license: string - The license key to bind to.
+//
+// Returns:
+//   - models.Account: The account the device is bound to now.
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) UpdateLicense(accountData models.AccountData, license string) (models.Account, *models.APIError, error) {
+	var result models.Account
+	apiErr, err := c.request(http.MethodPut, "/reg/"+accountData.ID+"/account", bearer(accountData.Token), models.LicenseUpdate{License: license}, &result)
+	if err != nil {
+		return models.Account{}, apiErr, err
+	}
+	return result, nil, nil
+}
+
+// DeleteRegistration deletes the device. Its config can't be used anymore afterwards.
+//
+// Parameters:
+//   - accountData: models.AccountData - The device to delete, only ID and Token are used.
+//
+// Returns:
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails.
+func (c *Client) DeleteRegistration(accountData models.AccountData) (*models.APIError, error) {
+	return c.request(http.MethodDelete, "/reg/"+accountData.ID, bearer(accountData.Token), nil, nil)
+}
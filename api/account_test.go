@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/Diniboy1123/usque/models"
+)
+
+// recordedRequest is a request received by a fake client API.
+type recordedRequest struct {
+	method        string
+	path          string
+	authorization string
+	body          string
+}
+
+// startFakeAPI starts a fake client API answering every request with status and body,
+// and returns a client talking to it along with the requests it received.
+func startFakeAPI(t *testing.T, status int, body string) (*Client, *[]recordedRequest) {
+	t.Helper()
+
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request body: %v", err)
+		}
+		requests = append(requests, recordedRequest{
+			method:        r.Method,
+			path:          r.URL.Path,
+			authorization: r.Header.Get("Authorization"),
+			body:          string(reqBody),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewClient(ClientConfig{BaseURL: server.URL, Version: "v0test"}), &requests
+}
+
+// checkRequest fails the test unless exactly one request with the given method, path and body
+// was sent, authenticated with the device's token.
+func checkRequest(t *testing.T, requests []recordedRequest, method, path, body string) {
+	t.Helper()
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	r := requests[0]
+	if r.method != method || r.path != path {
+		t.Errorf("request is %s %s, want %s %s", r.method, r.path, method, path)
+	}
+	if r.authorization != "Bearer token" {
+		t.Errorf("Authorization header is %q, want %q", r.authorization, "Bearer token")
+	}
+	if body == "" {
+		if r.body != "" {
+			t.Errorf("request body is %q, want none", r.body)
+		}
+		return
+	}
+	var got, want any
+	if err := json.Unmarshal([]byte(r.body), &got); err != nil {
+		t.Fatalf("request body %q is not JSON: %v", r.body, err)
+	}
+	if err := json.Unmarshal([]byte(body), &want); err != nil {
+		t.Fatalf("expected body %q is not JSON: %v", body, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request body is %s, want %s", r.body, body)
+	}
+}
+
+var testDevice = models.AccountData{ID: "device", Token: "token"}
+
+const testAccountJSON = `{"id":"account","account_type":"limited","premium_data":1000,"quota":2000,"warp_plus":true}`
+
+var testAccount = models.Account{ID: "account", AccountType: "limited", PremiumData: 1000, Quota: 2000, WarpPlus: true}
+
+const testDevicesJSON = `[
+	{"id":"device","type":"Android","model":"PC","name":"usque","active":true,"role":"child"},
+	{"id":"other","type":"Android","model":"Phone","name":"phone","active":false,"role":"parent"}
+]`
+
+var testDevices = []models.BoundDevice{
+	{ID: "device", Type: "Android", Model: "PC", Name: "usque", Active: true, Role: "child"},
+	{ID: "other", Type: "Android", Model: "Phone", Name: "phone", Active: false, Role: "parent"},
+}
+
+func TestGetAccount(t *testing.T) {
+	client, requests := startFakeAPI(t, http.StatusOK, testAccountJSON)
+
+	account, apiErr, err := client.GetAccount(testDevice)
+	if err != nil || apiErr != nil {
+		t.Fatalf("GetAccount failed: %v, %v", apiErr, err)
+	}
+	checkRequest(t, *requests, http.MethodGet, "/v0test/reg/device/account", "")
+	if account != testAccount {
+		t.Errorf("account is %+v, want %+v", account, testAccount)
+	}
+}
+
+func TestGetBoundDevices(t *testing.T) {
+	client, requests := startFakeAPI(t, http.StatusOK, testDevicesJSON)
+
+	devices, apiErr, err := client.GetBoundDevices(testDevice)
+	if err != nil || apiErr != nil {
+		t.Fatalf("GetBoundDevices failed: %v, %v", apiErr, err)
+	}
+	checkRequest(t, *requests, http.MethodGet, "/v0test/reg/device/account/devices", "")
+	if !reflect.DeepEqual(devices, testDevices) {
+		t.Errorf("devices are %+v, want %+v", devices, testDevices)
+	}
+}
+
+func TestUpdateBoundDevice(t *testing.T) {
+	inactive := false
+	tests := []struct {
+		name   string
+		update models.BoundDeviceUpdate
+		body   string
+	}{
+		{"rename", models.BoundDeviceUpdate{Name: "laptop"}, `{"name":"laptop"}`},
+		{"deactivate", models.BoundDeviceUpdate{Active: &inactive}, `{"active":false}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := startFakeAPI(t, http.StatusOK, testDevicesJSON)
+
+			devices, apiErr, err := client.UpdateBoundDevice(testDevice, "other", tt.update)
+			if err != nil || apiErr != nil {
+				t.Fatalf("UpdateBoundDevice failed: %v, %v", apiErr, err)
+			}
+			checkRequest(t, *requests, http.MethodPatch, "/v0test/reg/device/account/reg/other", tt.body)
+			if !reflect.DeepEqual(devices, testDevices) {
+				t.Errorf("devices are %+v, want %+v", devices, testDevices)
+			}
+		})
+	}
+}
+
+func TestUpdateLicense(t *testing.T) {
+	client, requests := startFakeAPI(t, http.StatusOK, testAccountJSON)
+
+	account, apiErr, err := client.UpdateLicense(testDevice, "license-key")
+	if err != nil || apiErr != nil {
+		t.Fatalf("UpdateLicense failed: %v, %v", apiErr, err)
+	}
+	checkRequest(t, *requests, http.MethodPut, "/v0test/reg/device/account", `{"license":"license-key"}`)
+	if account != testAccount {
+		t.Errorf("account is %+v, want %+v", account, testAccount)
+	}
+}
+
+func TestDeleteRegistration(t *testing.T) {
+	client, requests := startFakeAPI(t, http.StatusNoContent, "")
+
+	apiErr, err := client.DeleteRegistration(testDevice)
+	if err != nil || apiErr != nil {
+		t.Fatalf("DeleteRegistration failed: %v, %v", apiErr, err)
+	}
+	checkRequest(t, *requests, http.MethodDelete, "/v0test/reg/device", "")
+}
+
+func TestAccountAPIErrors(t *testing.T) {
+	calls := []struct {
+		name string
+		call func(*Client) (*models.APIError, error)
+	}{
+		{"GetAccount", func(c *Client) (*models.APIError, error) {
+			_, apiErr, err := c.GetAccount(testDevice)
+			return apiErr, err
+		}},
+		{"GetBoundDevices", func(c *Client) (*models.APIError, error) {
+			_, apiErr, err := c.GetBoundDevices(testDevice)
+			return apiErr, err
+		}},
+		{"UpdateBoundDevice", func(c *Client) (*models.APIError, error) {
+			_, apiErr, err := c.UpdateBoundDevice(testDevice, "other", models.BoundDeviceUpdate{Name: "laptop"})
+			return apiErr, err
+		}},
+		{"UpdateLicense", func(c *Client) (*models.APIError, error) {
+			_, apiErr, err := c.UpdateLicense(testDevice, "license-key")
+			return apiErr, err
+		}},
+		{"DeleteRegistration", func(c *Client) (*models.APIError, error) {
+			return c.DeleteRegistration(testDevice)
+		}},
+	}
+
+	for _, call := range calls {
+		t.Run(call.name+"/api error", func(t *testing.T) {
+			client, _ := startFakeAPI(t, http.StatusBadRequest,
+				`{"result":null,"success":false,"errors":[{"code":1001,"message":"Invalid license"},{"code":1002,"message":"Too many devices"}],"messages":[]}`)
+
+			apiErr, err := call.call(client)
+			var statusErr *APIStatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("error is %v, want *APIStatusError", err)
+			}
+			if statusErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("status code is %d, want %d", statusErr.StatusCode, http.StatusBadRequest)
+			}
+			if apiErr == nil || apiErr != statusErr.APIError {
+				t.Fatalf("returned API error %v is not the one of the status error %v", apiErr, statusErr.APIError)
+			}
+			if got := apiErr.ErrorsAsString("; "); got != "Invalid license; Too many devices" {
+				t.Errorf("API errors are %q, want %q", got, "Invalid license; Too many devices")
+			}
+			if IsInvalidPublicKey(err) {
+				t.Errorf("IsInvalidPublicKey is true for an unrelated error")
+			}
+		})
+
+		t.Run(call.name+"/no api error", func(t *testing.T) {
+			client, _ := startFakeAPI(t, http.StatusInternalServerError, "<html>Internal Server Error</html>")
+
+			apiErr, err := call.call(client)
+			var statusErr *APIStatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("error is %v, want *APIStatusError", err)
+			}
+			if statusErr.StatusCode != http.StatusInternalServerError {
+				t.Errorf("status code is %d, want %d", statusErr.StatusCode, http.StatusInternalServerError)
+			}
+			if apiErr != nil || statusErr.APIError != nil {
+				t.Errorf("API error is %v, want nil for a non-JSON body", apiErr)
+			}
+		})
+	}
+}
+
+func TestIsInvalidPublicKey(t *testing.T) {
+	client, _ := startFakeAPI(t, http.StatusBadRequest, `{"success":false,"errors":[{"code":1000,"message":"Invalid public key"}]}`)
+
+	_, _, err := client.GetAccount(testDevice)
+	if !IsInvalidPublicKey(err) {
+		t.Errorf("IsInvalidPublicKey is false for %v", err)
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		io.WriteString(w, testAccountJSON)
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientConfig{
+		BaseURL: server.URL + "/",
+		Version: "v0test",
+		Headers: map[string]string{"cf-client-version": "a-test", "User-Agent": "", "X-Test": "1"},
+	})
+	if _, _, err := client.GetAccount(testDevice); err != nil {
+		t.Fatalf("GetAccount failed: %v", err)
+	}
+
+	if got := header.Get("CF-Client-Version"); got != "a-test" {
+		t.Errorf("CF-Client-Version is %q, want the override", got)
+	}
+	if got := header.Get("X-Test"); got != "1" {
+		t.Errorf("X-Test is %q, want the added header", got)
+	}
+	if got := header.Get("User-Agent"); got == internal.Headers["User-Agent"] {
+		t.Errorf("User-Agent is %q, want the default one removed", got)
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/Diniboy1123/usque/internal"
+)
+
+// CaptureConfig holds the settings of a packet capture.
+type CaptureConfig struct {
+	Path     string // The pcapng file to write to
+	MaxSize  int64  // The size in bytes after which the file is rotated, 0 for no limit
+	MaxFiles int    // The number of files to keep when rotating including the current one, 1 if 0
+	SnapLen  int    // The maximum number of bytes stored per packet, 0 for the whole packet
+	Filter   string // A tcpdump-like filter expression, see internal.ParsePacketFilter; empty captures everything
+}
+
+// PacketCapture writes the packets passing through a TunnelDevice into a pcapng file.
+type PacketCapture struct {
+	writer  *internal.PcapngWriter
+	filter  internal.PacketFilter
+	packets atomic.Uint64
+	failed  atomic.Bool
+}
+
+// NewPacketCapture creates the capture file and compiles the filter.
+//
+// Parameters:
+//   - config: CaptureConfig - The settings of the capture.
+//
+// Returns:
+//   - *PacketCapture: The capture, to be attached to a device with NewCaptureAdapter.
+//   - error: An error if the filter is invalid or the file can't be created.
+func NewPacketCapture(config CaptureConfig) (*PacketCapture, error) {
+	filter, err := internal.ParsePacketFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFiles := config.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = 1
+	}
+	writer, err := internal.NewPcapngWriter(config.Path, config.MaxSize, maxFiles, config.SnapLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PacketCapture{writer: writer, filter: filter}, nil
+}
+
+// Packets returns the number of packets captured so far.
+func (c *PacketCapture) Packets() uint64 {
+	return c.packets.Load()
+}
+
+// Close closes the capture file. Packets passed to the capture afterwards are ignored.
+//
+// Returns:
+//   - error: An error if the file can't be closed.
+func (c *PacketCapture) Close() error {
+	c.failed.Store(true)
+	return c.writer.Close()
+}
+
+// capture writes a packet into the capture file if it matches the filter.
+// The first write error is logged and stops the capture, the tunnel keeps running.
+func (c *PacketCapture) capture(pkt []byte, inbound bool) {
+	if c.failed.Load() || (c.filter != nil && !c.filter(pkt)) {
+		return
+	}
+	if err := c.writer.WritePacket(time.Now(), pkt, inbound); err != nil {
+		if !c.failed.Swap(true) {
+			log.Printf("Packet capture stopped: %v", err)
+		}
+		return
+	}
+	c.packets.Add(1)
+}
+
+// CaptureAdapter wraps a TunnelDevice and tees every packet read from it (outbound)
+// and written to it (inbound) into a PacketCapture.
+type CaptureAdapter struct {
+	device  TunnelDevice
+	current atomic.Pointer[PacketCapture]
+}
+
+// NewCaptureAdapter creates a new CaptureAdapter.
+//
+// Parameters:
+//   - device: TunnelDevice - The device to wrap.
+//   - capture: *PacketCapture - The capture to write packets to, nil to start without capturing.
+//
+// Returns:
+//   - *CaptureAdapter: The wrapped device.
+func NewCaptureAdapter(device TunnelDevice, capture *PacketCapture) *CaptureAdapter {
+	a := &CaptureAdapter{device: device}
+	a.current.Store(capture)
+	return a
+}
+
+// SetCapture replaces the capture packets are written to while the tunnel keeps running.
+// The previous capture is returned and not closed.
+//
+// Parameters:
+//   - capture: *PacketCapture - The new capture, nil to stop capturing.
+//
+// Returns:
+//   - *PacketCapture: The previous capture, nil if none was attached.
+func (a *CaptureAdapter) SetCapture(capture *PacketCapture) *PacketCapture {
+	return a.current.Swap(capture)
+}
+
+func (a *CaptureAdapter) ReadPacket(buf []byte) (int, error) {
+	n, err := a.device.ReadPacket(buf)
+	if err == nil {
+		if capture := a.current.Load(); capture != nil {
+			capture.capture(buf[:n], false)
+		}
+	}
+	return n, err
+}
+
+func (a *CaptureAdapter) WritePacket(pkt []byte) error {
+	if capture := a.current.Load(); capture != nil {
+		capture.capture(pkt, true)
+	}
+	return a.device.WritePacket(pkt)
+}
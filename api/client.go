@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/Diniboy1123/usque/models"
+)
+
+// DefaultAPITimeout is the timeout of a client API request if ClientConfig.Timeout is 0.
+const DefaultAPITimeout = 30 * time.Second
+
+// ClientConfig holds the settings used to talk to the Cloudflare client API.
+type ClientConfig struct {
+	BaseURL     string                                                            // The URL of the API without the version, internal.ApiUrl if empty
+	Version     string                                                            // The API version path segment, internal.ApiVersion if empty
+	Headers     map[string]string                                                 // Overrides of the default client identity headers, an empty value removes a header
+	Timeout     time.Duration                                                     // The timeout of a whole request, DefaultAPITimeout if 0
+	Proxy       func(*http.Request) (*url.URL, error)                             // Selects the proxy of a request, the environment's (HTTPS_PROXY etc.) if nil
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error) // Dials the connections to the API or proxy if not nil
+	HTTPClient  *http.Client                                                      // Sends the requests if not nil, Timeout, Proxy and DialContext are ignored then
+}
+
+// ErrTOSNotAccepted is returned when registering without accepting the Terms of Service.
+var ErrTOSNotAccepted = errors.New("the Terms of Service (https://www.cloudflare.com/application/terms/) must be accepted to register")
+
+// APIStatusError is returned when the client API answers a request with a non-2xx status.
+type APIStatusError struct {
+	StatusCode int              // HTTP status code of the response
+	Status     string           // HTTP status line of the response
+	APIError   *models.APIError // The errors returned by the API, nil if the response had none
+}
+
+func (e *APIStatusError) Error() string {
+	return "request failed: " + e.Status
+}
+
+// IsInvalidPublicKey reports whether err is the API rejecting the enrolled public key,
+// which is fixed by enrolling a newly generated key pair.
+//
+// Parameters:
+//   - err: error - The error returned by a client API call.
+//
+// Returns:
+//   - bool: True if the API reported an invalid public key, false otherwise.
+func IsInvalidPublicKey(err error) bool {
+	var statusErr *APIStatusError
+	return errors.As(err, &statusErr) && statusErr.APIError != nil && statusErr.APIError.HasErrorMessage(models.InvalidPublicKey)
+}
+
+// Client sends requests to the Cloudflare client API.
+type Client struct {
+	baseURL    string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Client. The zero ClientConfig talks to the official API like the Android app.
+//
+// Parameters:
+//   - config: ClientConfig - The settings of the client.
+//
+// Returns:
+//   - *Client: The client.
+func NewClient(config ClientConfig) *Client {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = internal.ApiUrl
+	}
+	version := config.Version
+	if version == "" {
+		version = internal.ApiVersion
+	}
+
+	headers := make(map[string]string, len(internal.Headers)+len(config.Headers))
+	for k, v := range internal.Headers {
+		headers[k] = v
+	}
+	for k, v := range config.Headers {
+		// header names are case-insensitive
+		for existing := range headers {
+			if strings.EqualFold(existing, k) {
+				delete(headers, existing)
+			}
+		}
+		if v != "" {
+			headers[k] = v
+		}
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = DefaultAPITimeout
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if config.Proxy != nil {
+			transport.Proxy = config.Proxy
+		}
+		if config.DialContext != nil {
+			transport.DialContext = config.DialContext
+		}
+		httpClient = &http.Client{Timeout: timeout, Transport: transport}
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/" + version,
+		headers:    headers,
+		httpClient: httpClient,
+	}
+}
+
+// request sends a request to the client API.
+//
+// Parameters:
+//   - method: string - The HTTP method.
+//   - path: string - The path below the API version, e.g. /reg/{id}.
+//   - headers: map[string]string - Headers set in addition to the client identity ones, e.g. Authorization.
+//   - body: any - Marshalled as the JSON body if not nil.
+//   - result: any - Unmarshalled from the JSON response if not nil.
+//
+// Returns:
+//   - *models.APIError: The error returned by the API, if any.
+//   - error: An error if the request fails, *APIStatusError if the API returns an error.
+func (c *Client) request(method, path string, headers map[string]string, body, result any) (*models.APIError, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal json: %v", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &APIStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		var apiErr models.APIError
+		if err := json.Unmarshal(respBody, &apiErr); err == nil && len(apiErr.Errors) > 0 {
+			statusErr.APIError = &apiErr
+		}
+		return statusErr.APIError, statusErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// bearer returns the Authorization header of a device's access token.
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
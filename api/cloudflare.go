@@ -1,11 +1,8 @@
 package api
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"time"
 
@@ -22,19 +19,24 @@ import (
 //   - model: string - The device model string to register. (e.g., "PC")
 //   - locale: string - The user's locale. (e.g., "en-US")
 //   - jwt: string - Team token to register.
-//   - acceptTos: bool - Whether the user accepts the Terms of Service (TOS). If false, the user will be prompted to accept.
+//   - acceptTos: bool - Whether the user accepted the Terms of Service (TOS). Asking the user is up to the caller.
 //
 // Returns:
 //   - models.AccountData: The account data returned from the registration process.
-//   - error:              An error if registration fails at any step.
+//   - error:              ErrTOSNotAccepted if acceptTos is false, *APIStatusError if the API rejects the registration
+//     or another error if registration fails at any step.
 //
 // Example:
 //
-//	account, err := Register("PC", "en-US", "", false)
+//	account, err := client.Register("PC", "en-US", "", true)
 //	if err != nil {
 //	    log.Fatalf("Registration failed: %v", err)
 //	}
-func Register(model, locale, jwt string, acceptTos bool) (models.AccountData, error) {
+func (c *Client) Register(model, locale, jwt string, acceptTos bool) (models.AccountData, error) {
+	if !acceptTos {
+		return models.AccountData{}, ErrTOSNotAccepted
+	}
+
 	wgKey, err := internal.GenerateRandomWgPubkey()
 	if err != nil {
 		return models.AccountData{}, fmt.Errorf("failed to generate wg key: %v", err)
@@ -44,17 +46,6 @@ func Register(model, locale, jwt string, acceptTos bool) (models.AccountData, er
 		return models.AccountData{}, fmt.Errorf("failed to generate serial: %v", err)
 	}
 
-	if !acceptTos {
-		fmt.Print("You must accept the Terms of Service (https://www.cloudflare.com/application/terms/) to register. Do you agree? (y/n): ")
-		var response string
-		if _, err := fmt.Scanln(&response); err != nil {
-			return models.AccountData{}, fmt.Errorf("failed to read user input: %v", err)
-		}
-		if response != "y" {
-			return models.AccountData{}, fmt.Errorf("user did not accept TOS")
-		}
-	}
-
 	data := models.Registration{
 		Key:       wgKey,
 		InstallID: "",
@@ -68,37 +59,14 @@ func Register(model, locale, jwt string, acceptTos bool) (models.AccountData, er
 		Locale:    locale,
 	}
 
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return models.AccountData{}, fmt.Errorf("failed to marshal json: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", internal.ApiUrl+"/"+internal.ApiVersion+"/reg", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return models.AccountData{}, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	for k, v := range internal.Headers {
-		req.Header.Set(k, v)
-	}
-
+	var headers map[string]string
 	if jwt != "" {
-		req.Header.Set("CF-Access-Jwt-Assertion", jwt)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return models.AccountData{}, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return models.AccountData{}, fmt.Errorf("failed to register: %v", resp.Status)
+		headers = map[string]string{"CF-Access-Jwt-Assertion": jwt}
 	}
 
 	var accountData models.AccountData
-	if err := json.NewDecoder(resp.Body).Decode(&accountData); err != nil {
-		return models.AccountData{}, fmt.Errorf("failed to decode response: %v", err)
+	if _, err := c.request(http.MethodPost, "/reg", headers, data, &accountData); err != nil {
+		return models.AccountData{}, fmt.Errorf("failed to register: %w", err)
 	}
 
 	return accountData, nil
@@ -115,15 +83,16 @@ func Register(model, locale, jwt string, acceptTos bool) (models.AccountData, er
 //
 // Returns:
 //   - models.AccountData: The updated account data.
-//   - error:              An error if the update process fails.
+//   - *models.APIError:   The error returned by the API, if any.
+//   - error:              *APIStatusError if the API rejects the key (see IsInvalidPublicKey) or another error if the update process fails.
 //
 // Example:
 //
-//	updatedAccount, apiErr, err := EnrollKey(account, pubKey, "PC")
+//	updatedAccount, apiErr, err := client.EnrollKey(account, pubKey, "PC")
 //	if err != nil {
 //	    log.Fatalf("Key enrollment failed: %v", err)
 //	}
-func EnrollKey(accountData models.AccountData, pubKey []byte, deviceName string) (models.AccountData, *models.APIError, error) {
+func (c *Client) EnrollKey(accountData models.AccountData, pubKey []byte, deviceName string) (models.AccountData, *models.APIError, error) {
 	deviceUpdate := models.DeviceUpdate{
 		Key:     base64.StdEncoding.EncodeToString(pubKey),
 		KeyType: internal.KeyTypeMasque,
@@ -134,43 +103,41 @@ func EnrollKey(accountData models.AccountData, pubKey []byte, deviceName string)
 		deviceUpdate.Name = deviceName
 	}
 
-	jsonData, err := json.Marshal(deviceUpdate)
-	if err != nil {
-		return models.AccountData{}, nil, fmt.Errorf("failed to marshal json: %v", err)
-	}
-
-	req, err := http.NewRequest("PATCH", internal.ApiUrl+"/"+internal.ApiVersion+"/reg/"+accountData.ID, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return models.AccountData{}, nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	for k, v := range internal.Headers {
-		req.Header.Set(k, v)
-	}
-	req.Header.Set("Authorization", "Bearer "+accountData.Token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return models.AccountData{}, nil, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	apiErr, err := c.request(http.MethodPatch, "/reg/"+accountData.ID, bearer(accountData.Token), deviceUpdate, &accountData)
 	if err != nil {
-		return models.AccountData{}, nil, fmt.Errorf("failed to read response body: %v", err)
+		return models.AccountData{}, apiErr, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var apiErr models.APIError
-		if err := json.Unmarshal(body, &apiErr); err != nil {
-			return models.AccountData{}, nil, fmt.Errorf("failed to parse error response: %v", err)
-		}
-		return models.AccountData{}, &apiErr, fmt.Errorf("failed to update: %s", resp.Status)
-	}
+	return accountData, nil, nil
+}
 
-	if err := json.Unmarshal(body, &accountData); err != nil {
-		return models.AccountData{}, nil, fmt.Errorf("failed to decode response: %v", err)
-	}
+// Register creates a new user account with the default client, see Client.Register.
+//
+// Parameters:
+//   - model: string - The device model string to register. (e.g., "PC")
+//   - locale: string - The user's locale. (e.g., "en-US")
+//   - jwt: string - Team token to register.
+//   - acceptTos: bool - Whether the user accepted the Terms of Service (TOS). Asking the user is up to the caller.
+//
+// Returns:
+//   - models.AccountData: The account data returned from the registration process.
+//   - error:              ErrTOSNotAccepted if acceptTos is false, *APIStatusError if the API rejects the registration
+//     or another error if registration fails at any step.
+func Register(model, locale, jwt string, acceptTos bool) (models.AccountData, error) {
+	return NewClient(ClientConfig{}).Register(model, locale, jwt, acceptTos)
+}
 
-	return accountData, nil, nil
+// EnrollKey updates an existing user account with a new MASQUE public key using the default client, see Client.EnrollKey.
+//
+// Parameters:
+//   - accountData: models.AccountData - The account data of the user being updated.
+//   - pubKey: []byte - The new MASQUE public key in binary format.
+//   - deviceName: string - The name of the device to enroll. (optional)
+//
+// Returns:
+//   - models.AccountData: The updated account data.
+//   - *models.APIError:   The error returned by the API, if any.
+//   - error:              *APIStatusError if the API rejects the key (see IsInvalidPublicKey) or another error if the update process fails.
+func EnrollKey(accountData models.AccountData, pubKey []byte, deviceName string) (models.AccountData, *models.APIError, error) {
+	return NewClient(ClientConfig{}).EnrollKey(accountData, pubKey, deviceName)
 }
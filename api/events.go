@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net"
+	"time"
+)
+
+// TunnelState describes the lifecycle state of a tunnel.
+type TunnelState int
+
+const (
+	// TunnelConnecting is reported before every connection attempt.
+	TunnelConnecting TunnelState = iota
+	// TunnelConnected is reported once the CONNECT-IP request succeeded and packets flow.
+	TunnelConnected
+	// TunnelDisconnected is reported when an attempt failed or an established session was lost.
+	// Another attempt follows after TunnelEvent.RetryIn.
+	TunnelDisconnected
+	// TunnelStopped is reported when the tunnel was stopped on purpose, e.g. by cancelling its context.
+	TunnelStopped
+	// TunnelFatal is reported when the tunnel gave up, either because the reconnect policy
+	// said so or because the device can no longer be read.
+	TunnelFatal
+)
+
+// String returns the lowercase name of the state.
+func (s TunnelState) String() string {
+	switch s {
+	case TunnelConnecting:
+		return "connecting"
+	case TunnelConnected:
+		return "connected"
+	case TunnelDisconnected:
+		return "disconnected"
+	case TunnelStopped:
+		return "stopped"
+	case TunnelFatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+// TunnelEvent describes a lifecycle change of a tunnel.
+type TunnelEvent struct {
+	State    TunnelState   // The state the tunnel entered
+	Time     time.Time     // When the state was entered
+	Endpoint *net.UDPAddr  // The MASQUE server used (or about to be used) by the tunnel
+	Attempt  int           // Connecting: number of this attempt since the last success. Disconnected/Fatal: consecutive failures so far
+	RetryIn  time.Duration // Disconnected: delay before the next attempt
+
+	// StatusCode is the HTTP status of the CONNECT-IP response, 0 if none was received.
+	StatusCode int
+	// CfTeam is the Cf-Team header of the CONNECT-IP response, if any.
+	CfTeam string
+	// Err is the reason for a Disconnected, Stopped or Fatal event.
+	Err error
+}
+
+// TunnelEventHandler is called for every lifecycle event of a tunnel.
+// Handlers are called synchronously from the goroutine maintaining the tunnel, so they must not block.
+type TunnelEventHandler func(TunnelEvent)
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	connectip "github.com/Diniboy1123/connect-ip-go"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// DefaultAttemptDelay is the time to wait for a connection attempt before starting the next one
+// in parallel, the "Connection Attempt Delay" recommended by RFC 8305.
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// InterleaveEndpoints orders endpoints as described in RFC 8305 section 4: addresses of both
+// families alternate, starting with the family of the first endpoint, while the relative order
+// within each family is kept.
+//
+// Parameters:
+//   - endpoints: []*net.UDPAddr - The candidate endpoints in order of preference.
+//
+// Returns:
+//   - []*net.UDPAddr: The interleaved endpoints.
+func InterleaveEndpoints(endpoints []*net.UDPAddr) []*net.UDPAddr {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	var preferred, other []*net.UDPAddr
+	preferV4 := endpoints[0].IP.To4() != nil
+	for _, endpoint := range endpoints {
+		if (endpoint.IP.To4() != nil) == preferV4 {
+			preferred = append(preferred, endpoint)
+		} else {
+			other = append(other, endpoint)
+		}
+	}
+
+	interleaved := make([]*net.UDPAddr, 0, len(endpoints))
+	for i := 0; i < len(preferred) || i < len(other); i++ {
+		if i < len(preferred) {
+			interleaved = append(interleaved, preferred[i])
+		}
+		if i < len(other) {
+			interleaved = append(interleaved, other[i])
+		}
+	}
+	return interleaved
+}
+
+// ConnectTunnelRace connects to the first of several candidate endpoints that accepts the tunnel,
+// racing them in the style of RFC 8305 (Happy Eyeballs): the attempts are started in order,
+// each one attemptDelay after the previous one or as soon as the previous one failed.
+// The first successful attempt wins and all others are cancelled.
+//
+// Parameters:
+//   - ctx: context.Context - The QUIC TLS context.
+//   - tlsConfig: *tls.Config - The TLS configuration for secure communication.
+//   - quicConfig: *quic.Config - The QUIC configuration settings.
+//   - connectUri: string - The URI template for the Connect-IP request.
+//   - endpoints: []*net.UDPAddr - The candidate endpoints in order of preference, see InterleaveEndpoints.
+//   - attemptDelay: time.Duration - The delay between starting two attempts, DefaultAttemptDelay if 0.
+//
+// Returns:
+//   - *net.UDPAddr: The endpoint that won the race.
+//   - *net.UDPConn: The UDP connection used for the QUIC session.
+//   - *http3.Transport: The HTTP/3 transport used for initial request.
+//   - *connectip.Conn: The Connect-IP connection instance.
+//   - *http.Response: The response from the Connect-IP handshake.
+//   - error: An error if no endpoint accepted the tunnel.
+func ConnectTunnelRace(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoints []*net.UDPAddr, attemptDelay time.Duration) (*net.UDPAddr, *net.UDPConn, *http3.Transport, *connectip.Conn, *http.Response, error) {
+	session, rsp, err := dialRace(ctx, tlsConfig, quicConfig, connectUri, endpoints, attemptDelay)
+	if err != nil {
+		return nil, nil, nil, nil, rsp, err
+	}
+	return session.endpoint, session.udpConn, session.tr, session.ipConn, rsp, nil
+}
+
+// dialRace does the work of ConnectTunnelRace. Unlike dialSession, an attempt only succeeds
+// if the CONNECT-IP request is answered with 200, other statuses are reported as *ConnectStatusError.
+// On failure the returned session is nil, the response is the last one received, if any.
+func dialRace(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoints []*net.UDPAddr, attemptDelay time.Duration) (*tunnelSession, *http.Response, error) {
+	if len(endpoints) == 0 {
+		return nil, nil, errNoEndpoints
+	}
+	if attemptDelay <= 0 {
+		attemptDelay = DefaultAttemptDelay
+	}
+
+	type dialResult struct {
+		session *tunnelSession
+		rsp     *http.Response
+		err     error
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// buffered, so that attempts finishing after the race was decided don't block
+	results := make(chan dialResult, len(endpoints))
+
+	next, running := 0, 0
+	start := func() {
+		endpoint := endpoints[next]
+		next++
+		running++
+		go func() {
+			started := time.Now()
+			session, rsp, err := dialSession(raceCtx, tlsConfig, quicConfig, connectUri, endpoint)
+			session.handshakeTime = time.Since(started)
+			if rsp != nil && rsp.StatusCode != http.StatusOK {
+				err = &ConnectStatusError{StatusCode: rsp.StatusCode, Status: rsp.Status}
+			}
+			results <- dialResult{session: session, rsp: rsp, err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(attemptDelay)
+	defer timer.Stop()
+
+	var errs []error
+	var lastRsp *http.Response
+	for running > 0 {
+		select {
+		case res := <-results:
+			running--
+			if res.err == nil {
+				// close the sessions of attempts that were still running
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						(<-results).session.close()
+					}
+				}(running)
+				return res.session, res.rsp, nil
+			}
+
+			res.session.close()
+			if res.rsp != nil {
+				lastRsp = res.rsp
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			if len(endpoints) > 1 {
+				log.Printf("Connection to %s failed: %v", res.session.endpoint, res.err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", res.session.endpoint, res.err))
+
+			if next < len(endpoints) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		case <-timer.C:
+			if next < len(endpoints) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, lastRsp, context.Cause(ctx)
+	}
+	if len(errs) == 1 {
+		return nil, lastRsp, errors.Unwrap(errs[0])
+	}
+	return nil, lastRsp, fmt.Errorf("all %d endpoints failed: %w", len(errs), errors.Join(errs...))
+}
@@ -5,8 +5,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 
@@ -24,11 +24,12 @@ import (
 //   - peerPubKey: *ecdsa.PublicKey - The endpoint's public key to pin to.
 //   - cert: [][]byte - The certificate chain to use for TLS authentication.
 //   - sni: string - The Server Name Indication (SNI) to use.
+//   - keyLogWriter: io.Writer - Receives the TLS secrets in the NSS key log format if not nil, see OpenKeyLogFile.
 //
 // Returns:
 //   - *tls.Config: A TLS configuration for secure communication.
 //   - error: An error if TLS setup fails.
-func PrepareTlsConfig(privKey *ecdsa.PrivateKey, peerPubKey *ecdsa.PublicKey, cert [][]byte, sni string) (*tls.Config, error) {
+func PrepareTlsConfig(privKey *ecdsa.PrivateKey, peerPubKey *ecdsa.PublicKey, cert [][]byte, sni string, keyLogWriter io.Writer) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{
 			{
@@ -36,8 +37,9 @@ func PrepareTlsConfig(privKey *ecdsa.PrivateKey, peerPubKey *ecdsa.PublicKey, ce
 				PrivateKey:  privKey,
 			},
 		},
-		ServerName: sni,
-		NextProtos: []string{http3.NextProtoH3},
+		ServerName:   sni,
+		NextProtos:   []string{http3.NextProtoH3},
+		KeyLogWriter: keyLogWriter,
 		// WARN: SNI is usually not for the endpoint, so we must skip verification
 		InsecureSkipVerify: true,
 		// we pin to the endpoint public key
@@ -91,32 +93,44 @@ func PrepareTlsConfig(privKey *ecdsa.PrivateKey, peerPubKey *ecdsa.PublicKey, ce
 //   - *http.Response: The response from the Connect-IP handshake.
 //   - error: An error if the connection setup fails.
 func ConnectTunnel(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoint *net.UDPAddr) (*net.UDPConn, *http3.Transport, *connectip.Conn, *http.Response, error) {
-	var udpConn *net.UDPConn
+	session, rsp, err := dialSession(ctx, tlsConfig, quicConfig, connectUri, endpoint)
+	return session.udpConn, session.tr, session.ipConn, rsp, err
+}
+
+// dialSession does the work of ConnectTunnel, but returns the connections as a tunnelSession
+// which also keeps the QUIC connection around for statistics.
+// The returned session is never nil, it holds whatever was set up before an error occurred.
+func dialSession(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoint *net.UDPAddr) (*tunnelSession, *http.Response, error) {
+	session := &tunnelSession{
+		endpoint: endpoint,
+		errChan:  make(chan error, 1),
+	}
+
 	var err error
 	if endpoint.IP.To4() == nil {
-		udpConn, err = net.ListenUDP("udp", &net.UDPAddr{
+		session.udpConn, err = net.ListenUDP("udp", &net.UDPAddr{
 			IP:   net.IPv6zero,
 			Port: 0,
 		})
 	} else {
-		udpConn, err = net.ListenUDP("udp", &net.UDPAddr{
+		session.udpConn, err = net.ListenUDP("udp", &net.UDPAddr{
 			IP:   net.IPv4zero,
 			Port: 0,
 		})
 	}
 	if err != nil {
-		return udpConn, nil, nil, nil, err
+		return session, nil, err
 	}
 
 	conn, err := quic.Dial(
 		ctx,
-		udpConn,
+		session.udpConn,
 		endpoint,
 		tlsConfig,
 		quicConfig,
 	)
 	if err != nil {
-		return udpConn, nil, nil, nil, err
+		return session, nil, err
 	}
 
 	tr := &http3.Transport{
@@ -140,11 +154,18 @@ func ConnectTunnel(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.
 	template := uritemplate.MustNew(connectUri)
 	ipConn, rsp, err := connectip.Dial(ctx, hconn, template, "cf-connect-ip", additionalHeaders, true)
 	if err != nil {
+		tr.Close()
+		conn.CloseWithError(0, "")
 		if err.Error() == "CRYPTO_ERROR 0x131 (remote): tls: access denied" {
-			return udpConn, nil, nil, nil, errors.New("login failed! Please double-check if your tls key and cert is enrolled in the Cloudflare Access service")
+			return session, nil, ErrAccessDenied
 		}
-		return udpConn, nil, nil, nil, fmt.Errorf("failed to dial connect-ip: %v", err)
+		// rsp is set if the server answered with an error status
+		return session, rsp, fmt.Errorf("failed to dial connect-ip: %v", err)
 	}
 
-	return udpConn, tr, ipConn, rsp, nil
+	session.conn = conn
+	session.tr = tr
+	session.ipConn = ipConn
+
+	return session, rsp, nil
 }
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultReconnectMultiplier is the factor the reconnect delay grows by after every failed attempt.
+	DefaultReconnectMultiplier = 2.0
+	// DefaultReconnectJitter is the fraction of the delay that is randomized to avoid synchronized reconnects.
+	DefaultReconnectJitter = 0.2
+	// DefaultStableSession is how long a session has to stay up before the failures preceding it are
+	// forgotten, unless the reconnect policy decides otherwise (see StableSessionPolicy).
+	DefaultStableSession = time.Minute
+)
+
+// ErrAccessDenied is returned when Cloudflare rejects the client certificate during the handshake.
+// Retrying will not help until the key is (re-)enrolled.
+var ErrAccessDenied = errors.New("login failed! Please double-check if your tls key and cert is enrolled in the Cloudflare Access service")
+
+// ConnectStatusError is returned when the CONNECT-IP request is answered with a non-200 status.
+type ConnectStatusError struct {
+	StatusCode int    // HTTP status code of the CONNECT-IP response
+	Status     string // HTTP status line of the CONNECT-IP response
+}
+
+func (e *ConnectStatusError) Error() string {
+	return "tunnel connection failed: " + e.Status
+}
+
+// IsFatalTunnelError reports whether err is an authentication failure that
+// won't go away by reconnecting, as opposed to a transient network error.
+//
+// Parameters:
+//   - err: error - The error returned by a connection attempt.
+//
+// Returns:
+//   - bool: True if reconnecting is pointless, false otherwise.
+func IsFatalTunnelError(err error) bool {
+	if errors.Is(err, ErrAccessDenied) {
+		return true
+	}
+
+	var statusErr *ConnectStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden
+	}
+
+	return false
+}
+
+// ReconnectPolicy decides whether and when MaintainTunnel makes another connection attempt.
+type ReconnectPolicy interface {
+	// NextDelay is called after every failed or lost connection.
+	// attempt is the number of consecutive failures so far (starting at 1) and err is the latest failure.
+	// It returns how long to wait before the next attempt, or false to give up.
+	NextDelay(attempt int, err error) (time.Duration, bool)
+}
+
+// StableSessionPolicy is implemented by reconnect policies that decide how long a session has to stay up
+// before the failures preceding it are forgotten. A session lost earlier counts as another failed attempt,
+// so an endpoint that drops every session right after the handshake keeps backing off.
+type StableSessionPolicy interface {
+	// StableAfter returns the minimum uptime of a session that resets the attempt count.
+	StableAfter() time.Duration
+}
+
+// BackoffPolicy is a ReconnectPolicy implementing exponential backoff with jitter.
+// Fatal errors (see IsFatalTunnelError) stop reconnecting immediately.
+type BackoffPolicy struct {
+	InitialDelay time.Duration // Delay before the first reconnect attempt
+	MaxDelay     time.Duration // Upper bound for the delay, 0 means no bound other than a day
+	Multiplier   float64       // Factor the delay grows by per attempt, values below 1 keep the delay fixed
+	Jitter       float64       // Fraction (0-1) of the delay that is randomized
+	MaxAttempts  int           // Number of consecutive failures after which to give up, 0 means unlimited
+	// StableSession is how long a session has to stay up to reset the attempt count,
+	// MaxDelay (or DefaultStableSession without one) if 0
+	StableSession time.Duration
+}
+
+// unboundedReconnectDelay caps the delay of a BackoffPolicy without MaxDelay, so it can't overflow time.Duration.
+const unboundedReconnectDelay = 24 * time.Hour
+
+// NewBackoffPolicy creates a BackoffPolicy with the default multiplier and jitter.
+//
+// Parameters:
+//   - initialDelay: time.Duration - The delay before the first reconnect attempt.
+//   - maxDelay: time.Duration - The maximum delay between attempts (0 for no limit other than a day).
+//   - maxAttempts: int - The number of consecutive failures before giving up (0 for unlimited).
+//
+// Returns:
+//   - *BackoffPolicy: The configured policy.
+func NewBackoffPolicy(initialDelay, maxDelay time.Duration, maxAttempts int) *BackoffPolicy {
+	return &BackoffPolicy{
+		InitialDelay: initialDelay,
+		MaxDelay:     maxDelay,
+		Multiplier:   DefaultReconnectMultiplier,
+		Jitter:       DefaultReconnectJitter,
+		MaxAttempts:  maxAttempts,
+	}
+}
+
+// NextDelay implements ReconnectPolicy.
+func (p *BackoffPolicy) NextDelay(attempt int, err error) (time.Duration, bool) {
+	if IsFatalTunnelError(err) {
+		return 0, false
+	}
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	maxDelay := float64(unboundedReconnectDelay)
+	if p.MaxDelay > 0 {
+		maxDelay = float64(p.MaxDelay)
+	}
+
+	delay := float64(p.InitialDelay)
+	if p.Multiplier > 1 && attempt > 1 {
+		// the growth may overflow to +Inf after many attempts, min keeps it bounded
+		delay = math.Min(delay*math.Pow(p.Multiplier, float64(attempt-1)), maxDelay)
+	}
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		// spread the delay evenly across [delay*(1-jitter), delay*(1+jitter))
+		delay *= 1 - jitter + 2*jitter*rand.Float64()
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return time.Duration(delay), true
+}
+
+// StableAfter implements StableSessionPolicy.
+func (p *BackoffPolicy) StableAfter() time.Duration {
+	switch {
+	case p.StableSession > 0:
+		return p.StableSession
+	case p.MaxDelay > 0:
+		return p.MaxDelay
+	default:
+		return DefaultStableSession
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ScanResult is the outcome of probing a single endpoint.
+type ScanResult struct {
+	Endpoint *net.UDPAddr // The probed endpoint
+	// OK is true if the tunnel could be established, i.e. the CONNECT-IP request was answered with 200.
+	OK bool
+	// Reachable is true if the endpoint completed the QUIC handshake or answered, even if it rejected the tunnel.
+	Reachable bool
+	// Latency is the time it took to establish the tunnel, or to fail.
+	Latency time.Duration
+	// StatusCode is the HTTP status of the CONNECT-IP response, 0 if none was received.
+	StatusCode int
+	// Err is the reason the probe failed, nil if OK.
+	Err error
+}
+
+// ScanEndpoints probes every endpoint with a real QUIC and CONNECT-IP handshake, the same one
+// ConnectTunnel performs, and closes the tunnel again right away.
+// Results are ranked: working endpoints by latency first, then endpoints that were reachable
+// but rejected the tunnel, then unreachable ones in their original order.
+//
+// Parameters:
+//   - ctx: context.Context - Cancels the scan; endpoints not probed yet are reported as failed.
+//   - tlsConfig: *tls.Config - The TLS configuration for secure communication.
+//   - quicConfig: *quic.Config - The QUIC configuration settings.
+//   - connectUri: string - The URI template for the Connect-IP request.
+//   - endpoints: []*net.UDPAddr - The endpoints to probe.
+//   - concurrency: int - How many endpoints to probe at the same time, at least 1.
+//   - timeout: time.Duration - How long to wait for a single endpoint.
+//   - progress: func(ScanResult) - Called after every probe if not nil, possibly concurrently.
+//
+// Returns:
+//   - []ScanResult: The ranked results, one per endpoint.
+func ScanEndpoints(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoints []*net.UDPAddr, concurrency int, timeout time.Duration, progress func(ScanResult)) []ScanResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]ScanResult, len(endpoints))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i] = ScanResult{Endpoint: endpoint, Err: context.Cause(ctx)}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, endpoint *net.UDPAddr) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = probeEndpoint(ctx, tlsConfig, quicConfig, connectUri, endpoint, timeout)
+			if progress != nil {
+				progress(results[i])
+			}
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	rank := func(r ScanResult) int {
+		switch {
+		case r.OK:
+			return 0
+		case r.Reachable:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		ri, rj := rank(results[i]), rank(results[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ri == 2 {
+			return false
+		}
+		return results[i].Latency < results[j].Latency
+	})
+
+	return results
+}
+
+// probeEndpoint establishes a tunnel to a single endpoint and closes it again.
+func probeEndpoint(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, connectUri string, endpoint *net.UDPAddr, timeout time.Duration) ScanResult {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	session, rsp, err := dialSession(probeCtx, tlsConfig, quicConfig, connectUri, endpoint)
+	result := ScanResult{Endpoint: endpoint, Latency: time.Since(started)}
+	session.close()
+
+	if rsp != nil {
+		result.StatusCode = rsp.StatusCode
+		if rsp.StatusCode != http.StatusOK {
+			err = &ConnectStatusError{StatusCode: rsp.StatusCode, Status: rsp.Status}
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = errors.New("timed out")
+	}
+
+	result.OK = err == nil
+	result.Reachable = result.OK || rsp != nil || errors.Is(err, ErrAccessDenied)
+	result.Err = err
+	return result
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	connectip "github.com/Diniboy1123/connect-ip-go"
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+// startConnectIPServer starts a local stand-in of a MASQUE endpoint answering CONNECT-IP requests
+// with status after delay, and returns its address. Established tunnels are handed to session if
+// not nil, which closes them when it returns, otherwise they are kept open until the client closes them.
+func startConnectIPServer(t *testing.T, key *ecdsa.PrivateKey, status int, delay time.Duration, session func(*connectip.Conn)) *net.UDPAddr {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	template := uritemplate.MustNew(internal.ConnectURI)
+	proxy := &connectip.Proxy{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := connectip.ParseRequest(r, template, "cf-connect-ip")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		time.Sleep(delay)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		conn, err := proxy.Proxy(w, req)
+		if err != nil {
+			return
+		}
+		if session != nil {
+			session(conn)
+			conn.Close()
+			return
+		}
+		// keep the tunnel open until the client closes it
+		buf := make([]byte, 1500)
+		for {
+			if _, err := conn.ReadPacket(buf, true); err != nil {
+				return
+			}
+		}
+	})
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &http3.Server{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			NextProtos:   []string{http3.NextProtoH3},
+		},
+		Handler:         handler,
+		EnableDatagrams: true,
+		QUICConfig:      &quic.Config{EnableDatagrams: true},
+	}
+	go server.Serve(udpConn)
+	t.Cleanup(func() {
+		server.Close()
+		udpConn.Close()
+	})
+
+	return udpConn.LocalAddr().(*net.UDPAddr)
+}
+
+// newTestKeys generates the key of a test endpoint and a client TLS configuration trusting it.
+func newTestKeys(t *testing.T) (*ecdsa.PrivateKey, *tls.Config) {
+	t.Helper()
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate server key: %v", err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	clientCert, err := internal.GenerateCert(clientKey, &clientKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to generate client certificate: %v", err)
+	}
+	tlsConfig, err := PrepareTlsConfig(clientKey, &serverKey.PublicKey, clientCert, internal.ConnectSNI, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare TLS config: %v", err)
+	}
+	return serverKey, tlsConfig
+}
+
+func TestScanEndpoints(t *testing.T) {
+	serverKey, tlsConfig := newTestKeys(t)
+
+	fast := startConnectIPServer(t, serverKey, http.StatusOK, 0, nil)
+	slow := startConnectIPServer(t, serverKey, http.StatusOK, 300*time.Millisecond, nil)
+	rejecting := startConnectIPServer(t, serverKey, http.StatusForbidden, 0, nil)
+
+	// a socket that never answers, so the handshake times out
+	silentConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer silentConn.Close()
+	silent := silentConn.LocalAddr().(*net.UDPAddr)
+
+	endpoints := []*net.UDPAddr{silent, slow, rejecting, fast}
+	var probed atomic.Int32
+	results := ScanEndpoints(context.Background(), tlsConfig, internal.DefaultQuicConfig(30*time.Second, 1242), internal.ConnectURI,
+		endpoints, 1, 2*time.Second, func(ScanResult) { probed.Add(1) })
+
+	if int(probed.Load()) != len(endpoints) {
+		t.Errorf("progress called %d times, want %d", probed.Load(), len(endpoints))
+	}
+	if len(results) != len(endpoints) {
+		t.Fatalf("got %d results, want %d", len(results), len(endpoints))
+	}
+
+	want := []struct {
+		endpoint   *net.UDPAddr
+		ok         bool
+		reachable  bool
+		statusCode int
+	}{
+		{fast, true, true, http.StatusOK},
+		{slow, true, true, http.StatusOK},
+		{rejecting, false, true, http.StatusForbidden},
+		{silent, false, false, 0},
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Endpoint != w.endpoint {
+			t.Errorf("result %d is %v, want %v", i, r.Endpoint, w.endpoint)
+			continue
+		}
+		if r.OK != w.ok || r.Reachable != w.reachable || r.StatusCode != w.statusCode {
+			t.Errorf("result for %v: OK %v, Reachable %v, StatusCode %d; want %v, %v, %d (err: %v)",
+				r.Endpoint, r.OK, r.Reachable, r.StatusCode, w.ok, w.reachable, w.statusCode, r.Err)
+		}
+	}
+
+	if results[0].Latency >= results[1].Latency {
+		t.Errorf("fast endpoint latency %v is not below slow endpoint latency %v", results[0].Latency, results[1].Latency)
+	}
+	if results[0].Err != nil {
+		t.Errorf("working endpoint has error: %v", results[0].Err)
+	}
+	var statusErr *ConnectStatusError
+	if !errors.As(results[2].Err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Errorf("rejecting endpoint error is %v, want *ConnectStatusError with status 403", results[2].Err)
+	}
+	if results[3].Err == nil || results[3].Err.Error() != "timed out" {
+		t.Errorf("silent endpoint error is %v, want timed out", results[3].Err)
+	}
+}
+
+func TestScanEndpointsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	endpoints := []*net.UDPAddr{{IP: net.IPv4(127, 0, 0, 1), Port: 1}, {IP: net.IPv4(127, 0, 0, 1), Port: 2}}
+	results := ScanEndpoints(ctx, &tls.Config{}, &quic.Config{}, internal.ConnectURI, endpoints, 1, time.Second, nil)
+
+	for i, r := range results {
+		if r.Endpoint != endpoints[i] {
+			t.Errorf("result %d is %v, want %v in original order", i, r.Endpoint, endpoints[i])
+		}
+		if r.OK || r.Reachable || r.Err == nil {
+			t.Errorf("result for %v: OK %v, Reachable %v, Err %v; want failed", r.Endpoint, r.OK, r.Reachable, r.Err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// QUICStats is a snapshot of the congestion and loss statistics of a QUIC connection.
+type QUICStats struct {
+	MinRTT        time.Duration // Minimum RTT observed on the path
+	LatestRTT     time.Duration // Last RTT sample
+	SmoothedRTT   time.Duration // Exponentially weighted moving average of the RTT
+	MeanDeviation time.Duration // Variation of the RTT samples
+
+	PacketsSent     uint64 // QUIC packets sent, including retransmissions
+	PacketsReceived uint64 // QUIC packets received
+	PacketsLost     uint64 // QUIC packets declared lost
+	BytesSent       uint64 // Bytes sent, including retransmissions
+	BytesReceived   uint64 // Bytes received
+	BytesLost       uint64 // Bytes declared lost
+}
+
+// TunnelStats is a snapshot of the traffic and health counters of a tunnel.
+// Packet and byte counters cover the lifetime of the tunnel, across reconnects.
+type TunnelStats struct {
+	Connected bool   // Whether a session is currently established
+	Endpoint  string // The MASQUE server of the current session
+
+	PacketsSent     uint64 // IP packets forwarded from the device into the tunnel
+	BytesSent       uint64 // Bytes forwarded from the device into the tunnel
+	PacketsReceived uint64 // IP packets forwarded from the tunnel to the device
+	BytesReceived   uint64 // Bytes forwarded from the tunnel to the device
+	DroppedPackets  uint64 // Packets read from the device while no session was established
+	WriteErrors     uint64 // Packets that couldn't be written to the IP connection or to the device
+	ICMPReplies     uint64 // ICMP replies generated for packets that couldn't be proxied (e.g. too big)
+
+	Sessions       uint64        // Sessions established so far
+	Reconnects     uint64        // Sessions established after the first one
+	FailedAttempts uint64        // Connection attempts that failed
+	SessionUptime  time.Duration // How long the current session has been up
+
+	Handshakes    uint64        // Successful handshakes (QUIC + CONNECT-IP) so far
+	HandshakeTime time.Duration // Total time spent in successful handshakes
+	LastHandshake time.Duration // Duration of the most recent successful handshake
+
+	// QUIC holds the statistics of the current session's QUIC connection, nil if not connected.
+	QUIC *QUICStats
+}
+
+// tunnelCounters holds the counters a tunnel updates while forwarding packets.
+type tunnelCounters struct {
+	packetsSent     atomic.Uint64
+	bytesSent       atomic.Uint64
+	packetsReceived atomic.Uint64
+	bytesReceived   atomic.Uint64
+	droppedPackets  atomic.Uint64
+	writeErrors     atomic.Uint64
+	icmpReplies     atomic.Uint64
+	sessions        atomic.Uint64
+	failedAttempts  atomic.Uint64
+	handshakes      atomic.Uint64
+	handshakeNanos  atomic.Uint64
+	lastHandshake   atomic.Int64
+}
+
+// recordHandshake adds the duration of a successful handshake to the counters.
+func (c *tunnelCounters) recordHandshake(d time.Duration) {
+	c.handshakes.Add(1)
+	c.handshakeNanos.Add(uint64(d))
+	c.lastHandshake.Store(int64(d))
+}
+
+// Stats returns a snapshot of the tunnel's traffic and health counters.
+// It is safe to call concurrently with Run.
+//
+// Returns:
+//   - TunnelStats: The current statistics.
+func (t *Tunnel) Stats() TunnelStats {
+	stats := TunnelStats{
+		PacketsSent:     t.counters.packetsSent.Load(),
+		BytesSent:       t.counters.bytesSent.Load(),
+		PacketsReceived: t.counters.packetsReceived.Load(),
+		BytesReceived:   t.counters.bytesReceived.Load(),
+		DroppedPackets:  t.counters.droppedPackets.Load(),
+		WriteErrors:     t.counters.writeErrors.Load(),
+		ICMPReplies:     t.counters.icmpReplies.Load(),
+		Sessions:        t.counters.sessions.Load(),
+		FailedAttempts:  t.counters.failedAttempts.Load(),
+		Handshakes:      t.counters.handshakes.Load(),
+		HandshakeTime:   time.Duration(t.counters.handshakeNanos.Load()),
+		LastHandshake:   time.Duration(t.counters.lastHandshake.Load()),
+	}
+	if stats.Sessions > 0 {
+		stats.Reconnects = stats.Sessions - 1
+	}
+
+	session := t.session.Load()
+	if session == nil {
+		return stats
+	}
+
+	stats.Connected = true
+	stats.Endpoint = session.endpoint.String()
+	stats.SessionUptime = time.Since(session.connectedAt)
+
+	if session.conn != nil {
+		connStats := session.conn.ConnectionStats()
+		stats.QUIC = &QUICStats{
+			MinRTT:          connStats.MinRTT,
+			LatestRTT:       connStats.LatestRTT,
+			SmoothedRTT:     connStats.SmoothedRTT,
+			MeanDeviation:   connStats.MeanDeviation,
+			PacketsSent:     connStats.PacketsSent,
+			PacketsReceived: connStats.PacketsReceived,
+			PacketsLost:     connStats.PacketsLost,
+			BytesSent:       connStats.BytesSent,
+			BytesReceived:   connStats.BytesReceived,
+			BytesLost:       connStats.BytesLost,
+		}
+	}
+
+	return stats
+}
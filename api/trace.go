@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/quic-go/quic-go/qlog"
+)
+
+// QuicTracer creates the tracer of a QUIC connection, see quic.Config.Tracer.
+type QuicTracer func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer
+
+// OpenKeyLogFile opens a file to write TLS secrets to in the NSS key log format, as understood
+// by Wireshark, to be passed to PrepareTlsConfig. Secrets are appended, like browsers do with SSLKEYLOGFILE.
+//
+// Parameters:
+//   - path: string - The key log file.
+//
+// Returns:
+//   - *os.File: The opened file.
+//   - error: An error if the file can't be opened.
+func OpenKeyLogFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key log file: %v", err)
+	}
+	return file, nil
+}
+
+// NewQlogTracer returns a QUIC tracer writing a qlog trace of every connection into dir,
+// named <time>_<connection id>_client.sqlog. The traces can be inspected with qvis.
+//
+// Parameters:
+//   - dir: string - The directory to write the traces to, created if missing.
+//
+// Returns:
+//   - QuicTracer: The tracer to set as quic.Config.Tracer or TunnelConfig.QuicTracer.
+//   - error: An error if the directory can't be created.
+func NewQlogTracer(dir string) (QuicTracer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create qlog directory: %v", err)
+	}
+
+	return func(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s_client.sqlog", time.Now().Format("20060102-150405"), connID))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Printf("Failed to create qlog file: %v", err)
+			return nil
+		}
+		return qlog.NewConnectionTracer(&bufferedFile{Writer: bufio.NewWriter(file), file: file}, p, connID)
+	}, nil
+}
+
+// bufferedFile flushes its buffer before closing the file.
+type bufferedFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+func (f *bufferedFile) Close() error {
+	if err := f.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	connectip "github.com/Diniboy1123/connect-ip-go"
 	"github.com/Diniboy1123/usque/internal"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/songgao/water"
 	"golang.zx2c4.com/wireguard/tun"
 )
@@ -143,114 +147,575 @@ func NewWaterAdapter(iface *water.Interface) TunnelDevice {
 	return &WaterAdapter{iface: iface}
 }
 
-// MaintainTunnel continuously connects to the MASQUE server, then starts two
-// forwarding goroutines: one forwarding from the device to the IP connection (and handling
-// any ICMP reply), and the other forwarding from the IP connection to the device.
-// If an error occurs in either loop, the connection is closed and a reconnect is attempted.
+// tunnelSession holds the connections belonging to a single MASQUE session.
+type tunnelSession struct {
+	endpoint    *net.UDPAddr
+	udpConn     *net.UDPConn
+	conn        *quic.Conn
+	tr          *http3.Transport
+	ipConn      *connectip.Conn
+	connectedAt time.Time
+	// handshakeTime is how long it took to establish the session
+	handshakeTime time.Duration
+	// errChan receives the reason the session broke, the first one wins
+	errChan chan error
+}
+
+// fail reports that the session broke without blocking if a reason was already reported.
+func (s *tunnelSession) fail(err error) {
+	select {
+	case s.errChan <- err:
+	default:
+	}
+}
+
+// close tears down the IP connection, the HTTP/3 transport, the QUIC connection and the UDP socket of the session.
+func (s *tunnelSession) close() {
+	if s.ipConn != nil {
+		s.ipConn.Close()
+	}
+	if s.tr != nil {
+		s.tr.Close()
+	}
+	if s.conn != nil {
+		s.conn.CloseWithError(0, "")
+	}
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+}
+
+// forwardFromDevice reads packets from the device for as long as the tunnel is maintained
+// and writes them to the IP connection of the current session, handling any ICMP reply.
+// Packets read while no session is established are dropped.
+// It returns nil once ctx is done, or an error once the device can no longer be read.
 //
 // Parameters:
-//   - ctx: context.Context - The context for the connection.
-//   - tlsConfig: *tls.Config - The TLS configuration for secure communication.
-//   - keepalivePeriod: time.Duration - The keepalive period for the QUIC connection.
-//   - initialPacketSize: uint16 - The initial packet size for the QUIC connection.
-//   - endpoint: *net.UDPAddr - The UDP address of the MASQUE server.
-//   - device: TunnelDevice - The TUN device to forward packets to and from.
-//   - mtu: int - The MTU of the TUN device.
-//   - reconnectDelay: time.Duration - The delay between reconnect attempts.
-func MaintainTunnel(ctx context.Context, tlsConfig *tls.Config, keepalivePeriod time.Duration, initialPacketSize uint16, endpoint *net.UDPAddr, device TunnelDevice, mtu int, reconnectDelay time.Duration) {
-	packetBufferPool := NewNetBuffer(mtu)
+//   - ctx: context.Context - The context of the tunnel.
+//   - device: TunnelDevice - The TUN device to read packets from.
+//
+// Returns:
+//   - error: The reason the device could no longer be read.
+func (t *Tunnel) forwardFromDevice(ctx context.Context, device TunnelDevice) error {
+	buf := make([]byte, t.config.MTU)
 	for {
-		log.Printf("Establishing MASQUE connection to %s:%d", endpoint.IP, endpoint.Port)
-		udpConn, tr, ipConn, rsp, err := ConnectTunnel(
-			ctx,
-			tlsConfig,
-			internal.DefaultQuicConfig(keepalivePeriod, initialPacketSize),
-			internal.ConnectURI,
-			endpoint,
-		)
+		n, err := device.ReadPacket(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
-			log.Printf("Failed to connect tunnel: %v", err)
-			time.Sleep(reconnectDelay)
+			return fmt.Errorf("failed to read from TUN device: %v", err)
+		}
+
+		session := t.session.Load()
+		if session == nil {
+			t.counters.droppedPackets.Add(1)
 			continue
 		}
-		if rsp.StatusCode != 200 {
-			log.Printf("Tunnel connection failed: %s", rsp.Status)
-			ipConn.Close()
-			if udpConn != nil {
-				udpConn.Close()
+
+		icmp, err := session.ipConn.WritePacket(buf[:n])
+		if err != nil {
+			t.counters.writeErrors.Add(1)
+			if errors.As(err, new(*connectip.CloseError)) {
+				session.fail(fmt.Errorf("connection closed while writing to IP connection: %v", err))
+				continue
 			}
-			if tr != nil {
-				tr.Close()
+			log.Printf("Error writing to IP connection: %v, continuing...", err)
+			continue
+		}
+		t.counters.packetsSent.Add(1)
+		t.counters.bytesSent.Add(uint64(n))
+
+		if len(icmp) > 0 {
+			t.counters.icmpReplies.Add(1)
+			if err := device.WritePacket(icmp); err != nil {
+				t.counters.writeErrors.Add(1)
+				if errors.As(err, new(*connectip.CloseError)) {
+					session.fail(fmt.Errorf("connection closed while writing ICMP to TUN device: %v", err))
+					continue
+				}
+				log.Printf("Error writing ICMP to TUN device: %v, continuing...", err)
 			}
-			time.Sleep(reconnectDelay)
+		}
+	}
+}
+
+// forwardToDevice reads packets from the IP connection of a session and writes them to the device.
+// It returns once the IP connection is closed or the device can no longer be written.
+//
+// Parameters:
+//   - session: *tunnelSession - The session to read packets from.
+//   - device: TunnelDevice - The TUN device to write packets to.
+//   - packetBufferPool: *NetBuffer - The pool to take the read buffer from.
+func (t *Tunnel) forwardToDevice(session *tunnelSession, device TunnelDevice, packetBufferPool *NetBuffer) {
+	buf := packetBufferPool.Get()
+	defer packetBufferPool.Put(buf)
+	for {
+		n, err := session.ipConn.ReadPacket(buf, true)
+		if err != nil {
+			if errors.As(err, new(*connectip.CloseError)) {
+				session.fail(fmt.Errorf("connection closed while reading from IP connection: %v", err))
+				return
+			}
+			log.Printf("Error reading from IP connection: %v, continuing...", err)
 			continue
 		}
+		if err := device.WritePacket(buf[:n]); err != nil {
+			t.counters.writeErrors.Add(1)
+			session.fail(fmt.Errorf("failed to write to TUN device: %v", err))
+			return
+		}
+		t.counters.packetsReceived.Add(1)
+		t.counters.bytesReceived.Add(uint64(n))
+	}
+}
+
+// TunnelConfig holds the settings used to establish and maintain a MASQUE tunnel.
+type TunnelConfig struct {
+	TLSConfig         *tls.Config     // The TLS configuration for secure communication
+	KeepalivePeriod   time.Duration   // The keepalive period for the QUIC connection
+	InitialPacketSize uint16          // The initial packet size for the QUIC connection
+	Endpoint          *net.UDPAddr    // The UDP address of the MASQUE server, used if Endpoints is empty
+	Endpoints         []*net.UDPAddr  // Candidate MASQUE servers in order of preference, raced on every attempt
+	AttemptDelay      time.Duration   // Delay between starting attempts to two candidates, DefaultAttemptDelay if 0
+	MTU               int             // The MTU of the TUN device
+	ReconnectPolicy   ReconnectPolicy // Decides the delay between reconnect attempts and when to give up
+	QuicTracer        QuicTracer      // Traces the QUIC connections if not nil, see NewQlogTracer
+}
+
+// errNoEndpoints is returned when the tunnel has no MASQUE server to connect to.
+var errNoEndpoints = errors.New("no endpoints to connect to")
+
+// errReconnectRequested is the reason reported for sessions closed by Tunnel.Reconnect.
+var errReconnectRequested = errors.New("reconnect requested")
+
+// Tunnel maintains a MASQUE tunnel and reports its lifecycle to subscribers.
+type Tunnel struct {
+	// mu guards the handlers and the fields of config that can change while running (Endpoints, TLSConfig)
+	mu     sync.Mutex
+	config TunnelConfig
+
+	// session is the currently established session, nil while (re)connecting
+	session  atomic.Pointer[tunnelSession]
+	counters tunnelCounters
+	// reconnect receives requests to drop the current session and connect again right away
+	reconnect chan struct{}
+
+	handlers      map[int]TunnelEventHandler
+	nextHandlerID int
+}
+
+// NewTunnel creates a new Tunnel. Call Run to bring it up.
+//
+// Parameters:
+//   - config: TunnelConfig - The settings of the tunnel.
+//
+// Returns:
+//   - *Tunnel: The tunnel.
+func NewTunnel(config TunnelConfig) *Tunnel {
+	if len(config.Endpoints) == 0 && config.Endpoint != nil {
+		config.Endpoints = []*net.UDPAddr{config.Endpoint}
+	}
+	return &Tunnel{
+		config:    config,
+		reconnect: make(chan struct{}, 1),
+		handlers:  make(map[int]TunnelEventHandler),
+	}
+}
+
+// Endpoint returns the preferred MASQUE server, the one tried first by the next connection attempt.
+//
+// Returns:
+//   - *net.UDPAddr: The preferred endpoint, nil if there are no endpoints.
+func (t *Tunnel) Endpoint() *net.UDPAddr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.config.Endpoints) == 0 {
+		return nil
+	}
+	return t.config.Endpoints[0]
+}
+
+// Endpoints returns the candidate MASQUE servers in the order the next connection attempt tries them.
+//
+// Returns:
+//   - []*net.UDPAddr: A copy of the candidate endpoints.
+func (t *Tunnel) Endpoints() []*net.UDPAddr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*net.UDPAddr(nil), t.config.Endpoints...)
+}
+
+// SNI returns the server name sent during the TLS handshake.
+//
+// Returns:
+//   - string: The SNI used by the next connection attempt.
+func (t *Tunnel) SNI() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.config.TLSConfig.ServerName
+}
+
+// SetEndpoint switches the tunnel to a single MASQUE server and reconnects.
+//
+// Parameters:
+//   - endpoint: *net.UDPAddr - The UDP address of the new MASQUE server.
+//
+// Returns:
+//   - error: An error if endpoint is nil, the endpoints are unchanged then.
+func (t *Tunnel) SetEndpoint(endpoint *net.UDPAddr) error {
+	return t.SetEndpoints([]*net.UDPAddr{endpoint})
+}
+
+// SetEndpoints replaces the candidate MASQUE servers and reconnects.
+//
+// Parameters:
+//   - endpoints: []*net.UDPAddr - The candidate endpoints in order of preference.
+//
+// Returns:
+//   - error: An error if endpoints is empty or holds nil, the endpoints are unchanged then.
+func (t *Tunnel) SetEndpoints(endpoints []*net.UDPAddr) error {
+	if len(endpoints) == 0 {
+		return errNoEndpoints
+	}
+	if slices.Contains(endpoints, nil) {
+		return errors.New("endpoints must not be nil")
+	}
+
+	t.mu.Lock()
+	t.config.Endpoints = append([]*net.UDPAddr(nil), endpoints...)
+	t.mu.Unlock()
+	t.Reconnect()
+	return nil
+}
+
+// demoteEndpoint moves an endpoint that stopped working to the end of the candidates,
+// so the next attempts prefer the others.
+func (t *Tunnel) demoteEndpoint(endpoint *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.config.Endpoints) < 2 {
+		return
+	}
+
+	endpoints := make([]*net.UDPAddr, 0, len(t.config.Endpoints))
+	var demoted []*net.UDPAddr
+	for _, candidate := range t.config.Endpoints {
+		if candidate.IP.Equal(endpoint.IP) && candidate.Port == endpoint.Port {
+			demoted = append(demoted, candidate)
+		} else {
+			endpoints = append(endpoints, candidate)
+		}
+	}
+	t.config.Endpoints = append(endpoints, demoted...)
+}
+
+// SetSNI changes the server name sent during the TLS handshake and reconnects.
+//
+// Parameters:
+//   - sni: string - The new Server Name Indication.
+func (t *Tunnel) SetSNI(sni string) {
+	t.mu.Lock()
+	tlsConfig := t.config.TLSConfig.Clone()
+	tlsConfig.ServerName = sni
+	t.config.TLSConfig = tlsConfig
+	t.mu.Unlock()
+	t.Reconnect()
+}
+
+// Reconnect drops the current session and connects again without waiting for the reconnect policy.
+// If the tunnel is waiting for its next attempt, the attempt is made right away.
+// It doesn't block; requests made while a connection attempt is in progress cause another one.
+func (t *Tunnel) Reconnect() {
+	select {
+	case t.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// dialSettings returns the candidate endpoints and TLS configuration for the next connection attempt.
+func (t *Tunnel) dialSettings() ([]*net.UDPAddr, *tls.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*net.UDPAddr(nil), t.config.Endpoints...), t.config.TLSConfig
+}
+
+// Subscribe registers a handler that is called for every lifecycle event of the tunnel.
+//
+// Parameters:
+//   - handler: TunnelEventHandler - The handler to call. It must not block.
+//
+// Returns:
+//   - func(): Removes the handler again.
+func (t *Tunnel) Subscribe(handler TunnelEventHandler) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextHandlerID
+	t.nextHandlerID++
+	t.handlers[id] = handler
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.handlers, id)
+	}
+}
+
+// publish sends an event to all subscribers.
+func (t *Tunnel) publish(event TunnelEvent) {
+	event.Time = time.Now()
+	if event.Endpoint == nil {
+		event.Endpoint = t.Endpoint()
+	}
+
+	t.mu.Lock()
+	handlers := make([]TunnelEventHandler, 0, len(t.handlers))
+	for _, handler := range t.handlers {
+		handlers = append(handlers, handler)
+	}
+	t.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Run continuously connects to the MASQUE server and forwards packets
+// between the device and the IP connection: a single goroutine forwards from the device
+// to the current IP connection (and handles any ICMP reply), while every session starts
+// another one forwarding from its IP connection to the device.
+// If an error occurs in either direction, the session is closed and a reconnect is attempted
+// after the delay chosen by the reconnect policy. Reconnect, SetEndpoint and SetSNI
+// close the session as well, but reconnect right away.
+//
+// Every attempt races the candidate endpoints as described in ConnectTunnelRace.
+// When an established session is lost, its endpoint is moved to the end of the candidates,
+// so the tunnel fails over to the next one.
+//
+// Run returns when ctx is done, when the reconnect policy gives up or when the
+// device can no longer be read. Before returning, the current session is torn down.
+// The goroutine reading the device stops with the next packet it reads, so callers
+// should close the device once Run returns.
+//
+// Parameters:
+//   - ctx: context.Context - The context for the connection, cancel it to stop the tunnel.
+//   - device: TunnelDevice - The TUN device to forward packets to and from.
+//
+// Returns:
+//   - error: The reason the tunnel stopped. Wraps the context error if ctx was cancelled.
+func (t *Tunnel) Run(ctx context.Context, device TunnelDevice) error {
+	packetBufferPool := NewNetBuffer(t.config.MTU)
+
+	deviceErr := make(chan error, 1)
+	go func() {
+		if err := t.forwardFromDevice(ctx, device); err != nil {
+			deviceErr <- err
+		}
+	}()
+
+	attempt := 0
 
-		log.Println("Connected to MASQUE server")
-		errChan := make(chan error, 2)
+	stopped := func() error {
+		err := fmt.Errorf("tunnel stopped: %w", context.Cause(ctx))
+		t.publish(TunnelEvent{State: TunnelStopped, Attempt: attempt, Err: err})
+		return err
+	}
+	fatal := func(err error) error {
+		t.publish(TunnelEvent{State: TunnelFatal, Attempt: attempt, Err: err})
+		return err
+	}
 
+	// backoff waits before the next attempt, or returns why the tunnel should stop instead
+	backoff := func(err error, statusCode int, cfTeam string) error {
+		attempt++
+		t.counters.failedAttempts.Add(1)
+		delay, retry := t.config.ReconnectPolicy.NextDelay(attempt, err)
+		if !retry {
+			return fatal(fmt.Errorf("giving up after %d attempts: %w", attempt, err))
+		}
+		t.publish(TunnelEvent{
+			State:      TunnelDisconnected,
+			Attempt:    attempt,
+			RetryIn:    delay,
+			StatusCode: statusCode,
+			CfTeam:     cfTeam,
+			Err:        err,
+		})
+		log.Printf("Reconnecting in %s (attempt %d)", delay.Round(time.Millisecond), attempt)
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-t.reconnect:
+			return nil
+		case err := <-deviceErr:
+			return fatal(err)
+		case <-ctx.Done():
+			return stopped()
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return stopped()
+		}
+
+		// this attempt satisfies any reconnect requested so far
+		select {
+		case <-t.reconnect:
+		default:
+		}
+
+		endpoints, tlsConfig := t.dialSettings()
+		if len(endpoints) == 0 {
+			return fatal(errNoEndpoints)
+		}
+		t.publish(TunnelEvent{State: TunnelConnecting, Endpoint: endpoints[0], Attempt: attempt + 1})
+		if len(endpoints) == 1 {
+			log.Printf("Establishing MASQUE connection to %s:%d", endpoints[0].IP, endpoints[0].Port)
+		} else {
+			log.Printf("Establishing MASQUE connection to one of %d endpoints, starting with %s:%d", len(endpoints), endpoints[0].IP, endpoints[0].Port)
+		}
+		// a reconnect requested while dialing aborts the attempt, e.g. to escape an unresponsive endpoint
+		dialCtx, dialCancel := context.WithCancel(ctx)
+		interrupted := make(chan struct{})
 		go func() {
-			for {
-				buf := packetBufferPool.Get()
-				n, err := device.ReadPacket(buf)
-				if err != nil {
-					packetBufferPool.Put(buf)
-					errChan <- fmt.Errorf("failed to read from TUN device: %v", err)
-					return
-				}
-				icmp, err := ipConn.WritePacket(buf[:n])
-				if err != nil {
-					packetBufferPool.Put(buf)
-					if errors.As(err, new(*connectip.CloseError)) {
-						errChan <- fmt.Errorf("connection closed while writing to IP connection: %v", err)
-						return
-					}
-					log.Printf("Error writing to IP connection: %v, continuing...", err)
-					continue
-				}
-				packetBufferPool.Put(buf)
-
-				if len(icmp) > 0 {
-					if err := device.WritePacket(icmp); err != nil {
-						if errors.As(err, new(*connectip.CloseError)) {
-							errChan <- fmt.Errorf("connection closed while writing ICMP to TUN device: %v", err)
-							return
-						}
-						log.Printf("Error writing ICMP to TUN device: %v, continuing...", err)
-					}
-				}
+			select {
+			case <-t.reconnect:
+				close(interrupted)
+				dialCancel()
+			case <-dialCtx.Done():
 			}
 		}()
+		quicConfig := internal.DefaultQuicConfig(t.config.KeepalivePeriod, t.config.InitialPacketSize)
+		quicConfig.Tracer = t.config.QuicTracer
+		session, rsp, err := dialRace(
+			dialCtx,
+			tlsConfig,
+			quicConfig,
+			internal.ConnectURI,
+			endpoints,
+			t.config.AttemptDelay,
+		)
+		dialCancel()
+		if err == nil {
+			select {
+			case <-interrupted:
+				// the dial completed anyway, handle the request once the session is up
+				t.Reconnect()
+			default:
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return stopped()
+			}
+			select {
+			case <-interrupted:
+				log.Println("Reconnect requested, aborting the connection attempt")
+				continue
+			default:
+			}
 
-		go func() {
-			buf := packetBufferPool.Get()
-			defer packetBufferPool.Put(buf)
-			for {
-				n, err := ipConn.ReadPacket(buf, true)
-				if err != nil {
-					if errors.As(err, new(*connectip.CloseError)) {
-						errChan <- fmt.Errorf("connection closed while reading from IP connection: %v", err)
-						return
-					}
-					log.Printf("Error reading from IP connection: %v, continuing...", err)
-					continue
-				}
-				if err := device.WritePacket(buf[:n]); err != nil {
-					errChan <- fmt.Errorf("failed to write to TUN device: %v", err)
-					return
-				}
+			statusCode, cfTeam := 0, ""
+			if rsp != nil {
+				statusCode, cfTeam = rsp.StatusCode, rsp.Header.Get("Cf-Team")
+			}
+			log.Printf("Failed to connect tunnel: %v", err)
+			if err := backoff(err, statusCode, cfTeam); err != nil {
+				return err
 			}
+			continue
+		}
+		cfTeam := rsp.Header.Get("Cf-Team")
+		endpoint := session.endpoint
+
+		t.counters.recordHandshake(session.handshakeTime)
+		log.Printf("Connected to MASQUE server %s:%d", endpoint.IP, endpoint.Port)
+		t.publish(TunnelEvent{State: TunnelConnected, Endpoint: endpoint, Attempt: attempt + 1, StatusCode: rsp.StatusCode, CfTeam: cfTeam})
+
+		session.connectedAt = time.Now()
+		t.counters.sessions.Add(1)
+
+		pumpDone := make(chan struct{})
+		go func() {
+			defer close(pumpDone)
+			t.forwardToDevice(session, device, packetBufferPool)
 		}()
+		t.session.Store(session)
+
+		var stop func() error
+		select {
+		case err = <-session.errChan:
+			log.Printf("Tunnel connection lost: %v. Reconnecting...", err)
+			t.demoteEndpoint(endpoint)
+		case <-t.reconnect:
+			err = errReconnectRequested
+			log.Println("Reconnect requested, closing the current session")
+		case err = <-deviceErr:
+			stop = func() error { return fatal(err) }
+		case <-ctx.Done():
+			stop = stopped
+		}
+
+		t.session.Store(nil)
+		session.close()
+		<-pumpDone
+
+		// only a session that stayed up for a while forgets the failures before it,
+		// an endpoint dropping every session right away keeps backing off
+		if time.Since(session.connectedAt) >= t.stableAfter() {
+			attempt = 0
+		}
 
-		err = <-errChan
-		log.Printf("Tunnel connection lost: %v. Reconnecting...", err)
-		ipConn.Close()
-		if udpConn != nil {
-			udpConn.Close()
+		if stop != nil {
+			return stop()
 		}
-		if tr != nil {
-			tr.Close()
+		if err == errReconnectRequested {
+			t.publish(TunnelEvent{State: TunnelDisconnected, Endpoint: endpoint, StatusCode: rsp.StatusCode, CfTeam: cfTeam, Err: err})
+			continue
+		}
+		if err := backoff(err, rsp.StatusCode, cfTeam); err != nil {
+			return err
+		}
+	}
+}
+
+// stableAfter returns how long a session has to stay up to reset the attempt count.
+func (t *Tunnel) stableAfter() time.Duration {
+	if policy, ok := t.config.ReconnectPolicy.(StableSessionPolicy); ok {
+		if stableAfter := policy.StableAfter(); stableAfter > 0 {
+			return stableAfter
 		}
-		time.Sleep(reconnectDelay)
 	}
+	return DefaultStableSession
+}
+
+// MaintainTunnel creates a Tunnel from the given settings and runs it until it stops.
+// See Tunnel.Run for details; use NewTunnel directly to subscribe to lifecycle events.
+//
+// Parameters:
+//   - ctx: context.Context - The context for the connection, cancel it to stop the tunnel.
+//   - tlsConfig: *tls.Config - The TLS configuration for secure communication.
+//   - keepalivePeriod: time.Duration - The keepalive period for the QUIC connection.
+//   - initialPacketSize: uint16 - The initial packet size for the QUIC connection.
+//   - endpoint: *net.UDPAddr - The UDP address of the MASQUE server.
+//   - device: TunnelDevice - The TUN device to forward packets to and from.
+//   - mtu: int - The MTU of the TUN device.
+//   - reconnectPolicy: ReconnectPolicy - Decides the delay between reconnect attempts and when to give up.
+//
+// Returns:
+//   - error: The reason the tunnel stopped. Wraps the context error if ctx was cancelled.
+func MaintainTunnel(ctx context.Context, tlsConfig *tls.Config, keepalivePeriod time.Duration, initialPacketSize uint16, endpoint *net.UDPAddr, device TunnelDevice, mtu int, reconnectPolicy ReconnectPolicy) error {
+	return NewTunnel(TunnelConfig{
+		TLSConfig:         tlsConfig,
+		KeepalivePeriod:   keepalivePeriod,
+		InitialPacketSize: initialPacketSize,
+		Endpoint:          endpoint,
+		MTU:               mtu,
+		ReconnectPolicy:   reconnectPolicy,
+	}).Run(ctx, device)
 }
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	connectip "github.com/Diniboy1123/connect-ip-go"
+)
+
+// idleDevice is a TunnelDevice without traffic, reads block until it is closed.
+type idleDevice struct {
+	closed chan struct{}
+}
+
+func (d *idleDevice) ReadPacket(buf []byte) (int, error) {
+	<-d.closed
+	return 0, errors.New("device closed")
+}
+
+func (d *idleDevice) WritePacket(pkt []byte) error {
+	return nil
+}
+
+// runFlappingTunnel runs a tunnel against an endpoint closing every session after uptime,
+// and returns why the tunnel stopped and how many sessions were established.
+func runFlappingTunnel(t *testing.T, ctx context.Context, uptime time.Duration, policy ReconnectPolicy) (error, int32) {
+	t.Helper()
+
+	serverKey, tlsConfig := newTestKeys(t)
+	endpoint := startConnectIPServer(t, serverKey, http.StatusOK, 0, func(*connectip.Conn) {
+		time.Sleep(uptime)
+	})
+
+	tunnel := NewTunnel(TunnelConfig{
+		TLSConfig:         tlsConfig,
+		KeepalivePeriod:   30 * time.Second,
+		InitialPacketSize: 1242,
+		Endpoint:          endpoint,
+		MTU:               1280,
+		ReconnectPolicy:   policy,
+	})
+	var connected atomic.Int32
+	tunnel.Subscribe(func(event TunnelEvent) {
+		if event.State == TunnelConnected {
+			connected.Add(1)
+		}
+	})
+
+	device := &idleDevice{closed: make(chan struct{})}
+	defer close(device.closed)
+	err := tunnel.Run(ctx, device)
+	return err, connected.Load()
+}
+
+func TestTunnelBacksOffFlappingEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	policy := &BackoffPolicy{
+		InitialDelay:  10 * time.Millisecond,
+		MaxDelay:      50 * time.Millisecond,
+		Multiplier:    2,
+		MaxAttempts:   3,
+		StableSession: time.Hour,
+	}
+	err, connected := runFlappingTunnel(t, ctx, 0, policy)
+
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("tunnel stopped with %v, want giving up after 3 attempts", err)
+	}
+	if connected != 3 {
+		t.Errorf("%d sessions were established, want 3", connected)
+	}
+}
+
+func TestTunnelResetsAttemptsAfterStableSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	policy := &BackoffPolicy{
+		InitialDelay:  10 * time.Millisecond,
+		MaxDelay:      50 * time.Millisecond,
+		Multiplier:    2,
+		MaxAttempts:   2,
+		StableSession: 100 * time.Millisecond,
+	}
+	err, connected := runFlappingTunnel(t, ctx, 300*time.Millisecond, policy)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("tunnel stopped with %v, want it to keep reconnecting until the context is done", err)
+	}
+	if connected <= int32(policy.MaxAttempts) {
+		t.Errorf("%d sessions were established, want more than MaxAttempts", connected)
+	}
+}
+
+func TestBackoffPolicyStableAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BackoffPolicy
+		want   time.Duration
+	}{
+		{"explicit", BackoffPolicy{MaxDelay: time.Minute, StableSession: 5 * time.Second}, 5 * time.Second},
+		{"max delay", BackoffPolicy{MaxDelay: 2 * time.Minute}, 2 * time.Minute},
+		{"default", BackoffPolicy{}, DefaultStableSession},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.StableAfter(); got != tt.want {
+				t.Errorf("StableAfter is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
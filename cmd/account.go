@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Diniboy1123/usque/config"
+	"github.com/Diniboy1123/usque/models"
+	"github.com/spf13/cobra"
+)
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage the account and devices of the registration",
+	Long:  "Queries and manages the registered device and its account through the Cloudflare client API.",
+}
+
+var accountShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the registered device and its account",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			cmd.Printf("Failed to configure API client: %v\n", err)
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			cmd.Printf("Failed to get json flag: %v\n", err)
+			return
+		}
+
+		reg, apiErr, err := client.GetRegistration(configAccountData(cfg))
+		if err != nil {
+			cmd.Printf("Failed to get device: %s\n", apiErrorString(err, apiErr))
+			return
+		}
+		// the registration only holds a summary of consumer accounts,
+		// fall back to it if the account can't be fetched
+		account, apiErr, err := client.GetAccount(configAccountData(cfg))
+		accountLoaded := err == nil
+		if accountLoaded {
+			reg.Account = account
+		} else {
+			cmd.Printf("Warning: failed to get account, showing the summary of the device: %s\n", apiErrorString(err, apiErr))
+			account = reg.Account
+		}
+
+		if asJSON {
+			printJSON(cmd, reg)
+			return
+		}
+
+		cmd.Printf("Device:      %s\n", reg.ID)
+		cmd.Printf("Name:        %s\n", reg.Name)
+		cmd.Printf("Model:       %s\n", reg.Model)
+		cmd.Printf("Type:        %s\n", reg.Type)
+		cmd.Printf("Tunnel:      %s (%s key)\n", reg.TunType, reg.KeyType)
+		cmd.Printf("Created:     %s\n", reg.Created)
+		cmd.Printf("Account:     %s\n", account.ID)
+		cmd.Printf("Plan:        %s\n", account.AccountType)
+		if account.Organization != "" {
+			cmd.Printf("Team:        %s\n", account.Organization)
+		}
+		if account.Role != "" {
+			cmd.Printf("Role:        %s\n", account.Role)
+		}
+		if accountLoaded && account.AccountType != "team" {
+			cmd.Printf("WARP+:       %t\n", account.WarpPlus)
+			cmd.Printf("Quota:       %d bytes (%d bytes premium data)\n", account.Quota, account.PremiumData)
+		}
+	},
+}
+
+var accountDevicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the devices bound to the account",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			cmd.Printf("Failed to configure API client: %v\n", err)
+			return
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			cmd.Printf("Failed to get json flag: %v\n", err)
+			return
+		}
+
+		devices, apiErr, err := client.GetBoundDevices(configAccountData(cfg))
+		if err != nil {
+			cmd.Printf("Failed to list devices: %s\n", apiErrorString(err, apiErr))
+			return
+		}
+
+		if asJSON {
+			printJSON(cmd, devices)
+			return
+		}
+		printDevices(cmd, cfg.ID, devices)
+	},
+}
+
+var accountDevicesRenameCmd = &cobra.Command{
+	Use:   "rename <device id> <name>",
+	Short: "Rename a device of the account",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateDevice(cmd, args[0], models.BoundDeviceUpdate{Name: args[1]})
+	},
+}
+
+var accountDevicesActivateCmd = &cobra.Command{
+	Use:   "activate <device id>",
+	Short: "Activate a device of the account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		active := true
+		updateDevice(cmd, args[0], models.BoundDeviceUpdate{Active: &active})
+	},
+}
+
+var accountDevicesDeactivateCmd = &cobra.Command{
+	Use:   "deactivate <device id>",
+	Short: "Deactivate a device of the account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		active := false
+		updateDevice(cmd, args[0], models.BoundDeviceUpdate{Active: &active})
+	},
+}
+
+var accountLicenseCmd = &cobra.Command{
+	Use:   "license <license key>",
+	Short: "Bind the device to another license key, e.g. a WARP+ one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			cmd.Printf("Failed to configure API client: %v\n", err)
+			return
+		}
+
+		account, apiErr, err := client.UpdateLicense(configAccountData(cfg), args[0])
+		if err != nil {
+			cmd.Printf("Failed to update license: %s\n", apiErrorString(err, apiErr))
+			return
+		}
+
+		cfg.License = args[0]
+		if account.License != "" {
+			cfg.License = account.License
+		}
+		if err := saveLoadedConfig(cmd, cfg); err != nil {
+			cmd.Printf("Failed to save config: %v\n", err)
+			return
+		}
+		cmd.Printf("Bound to account %s (%s, WARP+: %t)\n", account.ID, account.AccountType, account.WarpPlus)
+	},
+}
+
+var accountDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete the registered device",
+	Long:  "Deletes the registered device from its account. The config can't be used afterwards.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			cmd.Printf("Failed to configure API client: %v\n", err)
+			return
+		}
+
+		remove, err := confirm(cmd, fmt.Sprintf("Delete device %s? The config can't be used afterwards.", cfg.ID), "yes")
+		if err != nil {
+			cmd.Printf("Failed to confirm deletion: %v\n", err)
+			return
+		}
+		if !remove {
+			return
+		}
+
+		if apiErr, err := client.DeleteRegistration(configAccountData(cfg)); err != nil {
+			cmd.Printf("Failed to delete device: %s\n", apiErrorString(err, apiErr))
+			return
+		}
+		cmd.Printf("Deleted device %s, remove its profile with profile remove or register again\n", cfg.ID)
+	},
+}
+
+// updateDevice applies an update to a device of the account and prints the devices afterwards.
+func updateDevice(cmd *cobra.Command, deviceID string, update models.BoundDeviceUpdate) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		cmd.Printf("Config not loaded, please register first: %v\n", err)
+		return
+	}
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		cmd.Printf("Failed to configure API client: %v\n", err)
+		return
+	}
+
+	devices, apiErr, err := client.UpdateBoundDevice(configAccountData(cfg), deviceID, update)
+	if err != nil {
+		cmd.Printf("Failed to update device: %s\n", apiErrorString(err, apiErr))
+		return
+	}
+	printDevices(cmd, cfg.ID, devices)
+}
+
+// printDevices prints the devices of the account as a table, marking the current one.
+func printDevices(cmd *cobra.Command, currentID string, devices []models.BoundDevice) {
+	cmd.Printf("  %-36s  %-20s  %-10s  %-8s  %s\n", "ID", "NAME", "MODEL", "ACTIVE", "CREATED")
+	for _, device := range devices {
+		marker := " "
+		if device.ID == currentID {
+			marker = "*"
+		}
+		cmd.Printf("%s %-36s  %-20s  %-10s  %-8t  %s\n", marker, device.ID, device.Name, device.Model, device.Active, device.Created)
+	}
+}
+
+// configAccountData returns the credentials of the config for the account API.
+func configAccountData(cfg *config.Config) models.AccountData {
+	return models.AccountData{ID: cfg.ID, Token: cfg.AccessToken}
+}
+
+// saveLoadedConfig saves a config loaded with loadConfig back to its profile.
+func saveLoadedConfig(cmd *cobra.Command, cfg *config.Config) error {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %v", err)
+	}
+	profile, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %v", err)
+	}
+
+	passphrase, err := savePassphrase(cmd, configPath, profile)
+	if err != nil {
+		return err
+	}
+	return cfg.SaveConfig(configPath, profile, passphrase)
+}
+
+// apiErrorString formats a failed API request with the errors returned by the API.
+func apiErrorString(err error, apiErr *models.APIError) string {
+	if apiErr != nil {
+		return fmt.Sprintf("%v (API errors: %s)", err, apiErr.ErrorsAsString("; "))
+	}
+	return err.Error()
+}
+
+// printJSON prints a value as indented JSON to stdout.
+func printJSON(cmd *cobra.Command, value any) {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		cmd.Printf("Failed to marshal JSON: %v\n", err)
+		return
+	}
+	fmt.Println(string(out))
+}
+
+func init() {
+	accountShowCmd.Flags().BoolP("json", "j", false, "Print the device as JSON")
+	accountDevicesCmd.Flags().BoolP("json", "j", false, "Print the devices as JSON")
+	addYesFlag(accountDeleteCmd)
+	accountDevicesCmd.AddCommand(accountDevicesRenameCmd, accountDevicesActivateCmd, accountDevicesDeactivateCmd)
+	accountCmd.AddCommand(accountShowCmd, accountDevicesCmd, accountLicenseCmd, accountDeleteCmd)
+	addAPIFlags(accountCmd)
+	rootCmd.AddCommand(accountCmd)
+}
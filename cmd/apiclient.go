@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/spf13/cobra"
+)
+
+// addAPIFlags registers the flags configuring the client API of a command and its subcommands.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addAPIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("api-url", "", "Base URL of the client API, e.g. to use a mock (default is the official API)")
+	cmd.PersistentFlags().String("api-version", "", "Version of the client API (default is the version of the bundled client)")
+	cmd.PersistentFlags().StringArray("api-header", nil, "Override a client identity header as \"Name: value\", e.g. \"CF-Client-Version: a-6.36-4500\"; an empty value removes it")
+	cmd.PersistentFlags().Duration("api-timeout", api.DefaultAPITimeout, "Timeout of a client API request")
+	cmd.PersistentFlags().String("api-proxy", "", "HTTP or SOCKS5 proxy for the client API, e.g. socks5://127.0.0.1:1080 (default is the HTTPS_PROXY environment variable)")
+}
+
+// newAPIClient builds the client API client from the flags registered by addAPIFlags.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//
+// Returns:
+//   - *api.Client: The configured client.
+//   - error: An error if a flag can't be read or is invalid.
+func newAPIClient(cmd *cobra.Command) (*api.Client, error) {
+	baseURL, err := cmd.Flags().GetString("api-url")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API URL: %v", err)
+	}
+	if baseURL != "" {
+		if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid API URL %q", baseURL)
+		}
+	}
+
+	version, err := cmd.Flags().GetString("api-version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API version: %v", err)
+	}
+
+	headerFlags, err := cmd.Flags().GetStringArray("api-header")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API headers: %v", err)
+	}
+	headers := make(map[string]string, len(headerFlags))
+	for _, header := range headerFlags {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid API header %q, expected \"Name: value\"", header)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("api-timeout")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API timeout: %v", err)
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("API timeout must not be negative")
+	}
+
+	proxy, err := cmd.Flags().GetString("api-proxy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API proxy: %v", err)
+	}
+	var proxyFunc func(*http.Request) (*url.URL, error)
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid API proxy %q", proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported API proxy scheme %q, expected http, https or socks5", proxyURL.Scheme)
+		}
+		proxyFunc = http.ProxyURL(proxyURL)
+	}
+
+	return api.NewClient(api.ClientConfig{
+		BaseURL: baseURL,
+		Version: version,
+		Headers: headers,
+		Timeout: timeout,
+		Proxy:   proxyFunc,
+	}), nil
+}
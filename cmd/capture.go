@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/spf13/cobra"
+)
+
+// addCaptureFlags registers the flags controlling the packet capture of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addCaptureFlags(cmd *cobra.Command) {
+	cmd.Flags().String("capture", "", "Write the tunneled IP packets of both directions to this pcapng file (empty = disabled)")
+	cmd.Flags().Int64("capture-max-size", 0, "Rotate the capture file after this many MiB (0 = no limit)")
+	cmd.Flags().Int("capture-max-files", 5, "Number of capture files to keep when rotating, including the current one")
+	cmd.Flags().Int("capture-snaplen", 0, "Store at most this many bytes per packet (0 = whole packet)")
+	cmd.Flags().String("capture-filter", "", "Only capture packets matching this tcpdump-like filter, e.g. \"tcp port 443 and not host 1.1.1.1\"")
+}
+
+// startCapture wraps device with a packet capture if enabled by the flags registered by addCaptureFlags.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - device: api.TunnelDevice - The device to capture the packets of.
+//
+// Returns:
+//   - api.TunnelDevice: The device to run the tunnel with, device itself if capturing is disabled.
+//   - func(): Closes the capture file, to be called once the tunnel stopped.
+//   - error: An error if a flag is invalid or the capture file can't be created.
+func startCapture(cmd *cobra.Command, device api.TunnelDevice) (api.TunnelDevice, func(), error) {
+	path, err := cmd.Flags().GetString("capture")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capture path: %v", err)
+	}
+	if path == "" {
+		return device, func() {}, nil
+	}
+
+	maxSize, err := cmd.Flags().GetInt64("capture-max-size")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capture max size: %v", err)
+	}
+	maxFiles, err := cmd.Flags().GetInt("capture-max-files")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capture max files: %v", err)
+	}
+	snapLen, err := cmd.Flags().GetInt("capture-snaplen")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capture snaplen: %v", err)
+	}
+	filter, err := cmd.Flags().GetString("capture-filter")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capture filter: %v", err)
+	}
+
+	capture, err := api.NewPacketCapture(api.CaptureConfig{
+		Path:     path,
+		MaxSize:  maxSize << 20,
+		MaxFiles: maxFiles,
+		SnapLen:  snapLen,
+		Filter:   filter,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("Capturing packets to %s", path)
+	return api.NewCaptureAdapter(device, capture), func() {
+		if err := capture.Close(); err != nil {
+			log.Printf("Failed to close capture file: %v", err)
+			return
+		}
+		log.Printf("Captured %d packets to %s", capture.Packets(), path)
+	}, nil
+}
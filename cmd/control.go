@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/spf13/cobra"
+)
+
+// controlStatus is the status of a running instance as reported by the control API.
+type controlStatus struct {
+	Connected     bool         `json:"connected"`
+	Endpoint      string       `json:"endpoint"`
+	SNI           string       `json:"sni"`
+	IPv4          string       `json:"ipv4,omitempty"`
+	IPv6          string       `json:"ipv6,omitempty"`
+	Uptime        float64      `json:"uptime_seconds"`
+	SessionUptime float64      `json:"session_uptime_seconds"`
+	Stats         controlStats `json:"stats"`
+}
+
+// controlStats are the tunnel statistics reported by the control API.
+type controlStats struct {
+	PacketsSent     uint64  `json:"packets_sent"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	PacketsReceived uint64  `json:"packets_received"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	DroppedPackets  uint64  `json:"dropped_packets"`
+	WriteErrors     uint64  `json:"write_errors"`
+	Reconnects      uint64  `json:"reconnects"`
+	FailedAttempts  uint64  `json:"failed_attempts"`
+	LastHandshake   float64 `json:"last_handshake_seconds"`
+	SmoothedRTT     float64 `json:"smoothed_rtt_seconds,omitempty"`
+	PacketsLost     uint64  `json:"quic_packets_lost,omitempty"`
+}
+
+// controlRequest is the body of the control API actions that take an argument.
+type controlRequest struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	SNI      string `json:"sni,omitempty"`
+}
+
+// controlResponse is the body returned by the control API actions.
+type controlResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// controlServer serves the control API of a running tunnel command.
+type controlServer struct {
+	tunnel  *api.Tunnel
+	stop    context.CancelFunc
+	token   string // Required as bearer token of every request if not empty
+	ipv4    string
+	ipv6    string
+	started time.Time
+}
+
+// addControlFlags registers the flags controlling the control API of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addControlFlags(cmd *cobra.Command) {
+	cmd.Flags().String("control-listen", "", "Serve the control API on this address, e.g. unix:/run/usque.sock or 127.0.0.1:9091 (empty = disabled)")
+	cmd.Flags().String("control-token", "", "Require this bearer token for control API requests, mandatory for non-loopback TCP addresses")
+}
+
+// parseControlAddress splits a control API address into a network and an address.
+// Addresses prefixed with "unix:" are Unix sockets, everything else is TCP.
+//
+// Parameters:
+//   - addr: string - The address as given on the command line.
+//
+// Returns:
+//   - string: The network, "unix" or "tcp".
+//   - string: The address without the prefix.
+func parseControlAddress(addr string) (string, string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// startControlServer serves the control API on addr until ctx is done.
+//
+// Parameters:
+//   - ctx: context.Context - Stops the server once done.
+//   - addr: string - The address to listen on, see parseControlAddress.
+//   - server: *controlServer - The instance to control.
+//
+// Returns:
+//   - error: An error if the address can't be listened on.
+func startControlServer(ctx context.Context, addr string, server *controlServer) error {
+	network, address := parseControlAddress(addr)
+
+	var (
+		listener net.Listener
+		err      error
+	)
+	if network == "unix" {
+		listener, err = listenPrivateUnix(address)
+	} else {
+		if server.token == "" && !isLoopbackAddress(address) {
+			return fmt.Errorf("refusing to serve the control API on non-loopback address %s without --control-token", addr)
+		}
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	httpServer := &http.Server{
+		Handler:           server.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+		if network == "unix" {
+			os.Remove(address)
+		}
+	}()
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Control server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Serving control API on %s", addr)
+	return nil
+}
+
+// handler returns the handler serving the control API.
+func (s *controlServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /reconnect", s.handleReconnect)
+	mux.HandleFunc("POST /endpoint", s.handleEndpoint)
+	mux.HandleFunc("POST /sni", s.handleSNI)
+	mux.HandleFunc("POST /stop", s.handleStop)
+	return rejectBrowserRequests(s.authenticate(mux))
+}
+
+// listenPrivateUnix listens on a Unix socket only accessible by the current user.
+// The socket is created in a private directory and moved into place afterwards,
+// so other users can't connect before its permissions are restricted.
+//
+// Parameters:
+//   - path: string - The path of the socket.
+//
+// Returns:
+//   - net.Listener: The listener, it doesn't remove the socket when closed.
+//   - error: An error if the socket can't be created.
+func listenPrivateUnix(path string) (net.Listener, error) {
+	// remove a socket left behind by a previous instance
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".usque-control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// the directory is private, so nobody can connect until the socket is moved out of it
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of %s: %v", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// isLoopbackAddress reports whether a TCP listen address only accepts local connections.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// rejectBrowserRequests refuses requests a web page can send to the control API, since loopback
+// addresses may be served without a token. Browsers add an Origin header to cross-origin requests
+// and can't send a JSON Content-Type without a CORS preflight, which the control API never answers.
+func rejectBrowserRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeControlError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeControlError(w, http.StatusUnsupportedMediaType, errors.New("the Content-Type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate rejects requests without the control token, if one is set.
+func (s *controlServer) authenticate(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeControlError(w, http.StatusUnauthorized, errors.New("invalid or missing control token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *controlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	stats := s.tunnel.Stats()
+	endpoint := stats.Endpoint
+	if !stats.Connected {
+		endpoint = ""
+		if preferred := s.tunnel.Endpoint(); preferred != nil {
+			endpoint = preferred.String()
+		}
+	}
+	status := controlStatus{
+		Connected:     stats.Connected,
+		Endpoint:      endpoint,
+		SNI:           s.tunnel.SNI(),
+		IPv4:          s.ipv4,
+		IPv6:          s.ipv6,
+		Uptime:        time.Since(s.started).Seconds(),
+		SessionUptime: stats.SessionUptime.Seconds(),
+		Stats: controlStats{
+			PacketsSent:     stats.PacketsSent,
+			BytesSent:       stats.BytesSent,
+			PacketsReceived: stats.PacketsReceived,
+			BytesReceived:   stats.BytesReceived,
+			DroppedPackets:  stats.DroppedPackets,
+			WriteErrors:     stats.WriteErrors,
+			Reconnects:      stats.Reconnects,
+			FailedAttempts:  stats.FailedAttempts,
+			LastHandshake:   stats.LastHandshake.Seconds(),
+		},
+	}
+	if stats.QUIC != nil {
+		status.Stats.SmoothedRTT = stats.QUIC.SmoothedRTT.Seconds()
+		status.Stats.PacketsLost = stats.QUIC.PacketsLost
+	}
+	writeControlJSON(w, http.StatusOK, status)
+}
+
+func (s *controlServer) handleReconnect(w http.ResponseWriter, r *http.Request) {
+	log.Println("Control API: reconnect requested")
+	s.tunnel.Reconnect()
+	writeControlJSON(w, http.StatusOK, controlResponse{OK: true})
+}
+
+func (s *controlServer) handleEndpoint(w http.ResponseWriter, r *http.Request) {
+	var req controlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+
+	// keep the port of the current endpoint if none is given
+	port := 443
+	if current := s.tunnel.Endpoint(); current != nil {
+		port = current.Port
+	}
+	endpoint, err := parseEndpoint(req.Endpoint, port)
+	if err != nil {
+		writeControlError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	log.Printf("Control API: switching endpoint to %s", endpoint)
+	if err := s.tunnel.SetEndpoint(endpoint); err != nil {
+		writeControlError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, controlResponse{OK: true})
+}
+
+func (s *controlServer) handleSNI(w http.ResponseWriter, r *http.Request) {
+	var req controlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if req.SNI == "" {
+		writeControlError(w, http.StatusBadRequest, errors.New("sni must not be empty"))
+		return
+	}
+
+	log.Printf("Control API: switching SNI to %s", req.SNI)
+	s.tunnel.SetSNI(req.SNI)
+	writeControlJSON(w, http.StatusOK, controlResponse{OK: true})
+}
+
+func (s *controlServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	log.Println("Control API: stop requested")
+	writeControlJSON(w, http.StatusOK, controlResponse{OK: true})
+	// let the response go out before the server is closed
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.stop()
+	}()
+}
+
+// writeControlJSON writes v as the JSON body of a control API response.
+func writeControlJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeControlError writes err as the body of a failed control API response.
+func writeControlError(w http.ResponseWriter, statusCode int, err error) {
+	writeControlJSON(w, statusCode, controlResponse{Error: err.Error()})
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestControlServer serves the control API of a server without a tunnel,
+// only the stop action can be used. stopped is closed once it is called.
+func startTestControlServer(t *testing.T, token string) (url string, stopped chan struct{}) {
+	t.Helper()
+
+	stopped = make(chan struct{})
+	server := &controlServer{
+		stop:    func() { close(stopped) },
+		token:   token,
+		started: time.Now(),
+	}
+	httpServer := httptest.NewServer(server.handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL, stopped
+}
+
+func TestControlRejectsBrowserRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{"cross-origin simple POST", "https://evil.example", "text/plain", http.StatusForbidden},
+		{"cross-origin form POST", "https://evil.example", "application/x-www-form-urlencoded", http.StatusForbidden},
+		{"cross-origin JSON POST", "https://evil.example", "application/json", http.StatusForbidden},
+		{"null origin", "null", "application/json", http.StatusForbidden},
+		{"POST without Content-Type", "", "", http.StatusUnsupportedMediaType},
+		{"POST with form Content-Type", "", "multipart/form-data; boundary=x", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, stopped := startTestControlServer(t, "")
+
+			req, err := http.NewRequest(http.MethodPost, url+"/stop", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status is %d, want %d", resp.StatusCode, tt.status)
+			}
+			select {
+			case <-stopped:
+				t.Errorf("tunnel was stopped by a refused request")
+			case <-time.After(300 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestControlStop(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{"without token", "", "", http.StatusOK},
+		{"with token", "secret", "Bearer secret", http.StatusOK},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, stopped := startTestControlServer(t, tt.token)
+
+			req, err := http.NewRequest(http.MethodPost, url+"/stop", nil)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status is %d, want %d", resp.StatusCode, tt.status)
+			}
+			select {
+			case <-stopped:
+				if tt.status != http.StatusOK {
+					t.Errorf("tunnel was stopped by a refused request")
+				}
+			case <-time.After(time.Second):
+				if tt.status == http.StatusOK {
+					t.Errorf("tunnel was not stopped")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running usque instance",
+	Long: "Talks to the control API of a running tunnel command started with --control-listen." +
+		" Queries its status or makes it reconnect, switch endpoint or SNI, or stop.",
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the running instance",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			cmd.Printf("Failed to get json flag: %v\n", err)
+			return
+		}
+
+		var status controlStatus
+		if err := ctlRequest(cmd, http.MethodGet, "/status", nil, &status); err != nil {
+			cmd.Printf("Failed to get status: %v\n", err)
+			return
+		}
+
+		if asJSON {
+			out, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				cmd.Printf("Failed to marshal status: %v\n", err)
+				return
+			}
+			fmt.Println(string(out))
+			return
+		}
+
+		state := "disconnected"
+		if status.Connected {
+			state = "connected"
+		}
+		cmd.Printf("State:       %s\n", state)
+		cmd.Printf("Endpoint:    %s\n", status.Endpoint)
+		cmd.Printf("SNI:         %s\n", status.SNI)
+		if status.IPv4 != "" {
+			cmd.Printf("IPv4:        %s\n", status.IPv4)
+		}
+		if status.IPv6 != "" {
+			cmd.Printf("IPv6:        %s\n", status.IPv6)
+		}
+		cmd.Printf("Uptime:      %s\n", secondsToDuration(status.Uptime))
+		if status.Connected {
+			cmd.Printf("Session:     %s\n", secondsToDuration(status.SessionUptime))
+			cmd.Printf("RTT:         %s\n", secondsToDuration(status.Stats.SmoothedRTT).Round(time.Millisecond))
+		}
+		cmd.Printf("Sent:        %d packets, %d bytes\n", status.Stats.PacketsSent, status.Stats.BytesSent)
+		cmd.Printf("Received:    %d packets, %d bytes\n", status.Stats.PacketsReceived, status.Stats.BytesReceived)
+		cmd.Printf("Reconnects:  %d (%d failed attempts)\n", status.Stats.Reconnects, status.Stats.FailedAttempts)
+	},
+}
+
+var ctlReconnectCmd = &cobra.Command{
+	Use:   "reconnect",
+	Short: "Drop the current session and reconnect",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ctlRequest(cmd, http.MethodPost, "/reconnect", nil, nil); err != nil {
+			cmd.Printf("Failed to reconnect: %v\n", err)
+			return
+		}
+		cmd.Println("Reconnect requested")
+	},
+}
+
+var ctlEndpointCmd = &cobra.Command{
+	Use:   "endpoint <ip[:port]>",
+	Short: "Switch to another MASQUE endpoint",
+	Long:  "Switches the running instance to another MASQUE endpoint and reconnects. Without a port, the current one is kept.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ctlRequest(cmd, http.MethodPost, "/endpoint", controlRequest{Endpoint: args[0]}, nil); err != nil {
+			cmd.Printf("Failed to switch endpoint: %v\n", err)
+			return
+		}
+		cmd.Printf("Switching endpoint to %s\n", args[0])
+	},
+}
+
+var ctlSNICmd = &cobra.Command{
+	Use:   "sni <server name>",
+	Short: "Switch the SNI used for the MASQUE connection",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ctlRequest(cmd, http.MethodPost, "/sni", controlRequest{SNI: args[0]}, nil); err != nil {
+			cmd.Printf("Failed to switch SNI: %v\n", err)
+			return
+		}
+		cmd.Printf("Switching SNI to %s\n", args[0])
+	},
+}
+
+var ctlStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Gracefully stop the running instance",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ctlRequest(cmd, http.MethodPost, "/stop", nil, nil); err != nil {
+			cmd.Printf("Failed to stop: %v\n", err)
+			return
+		}
+		cmd.Println("Stop requested")
+	},
+}
+
+// ctlRequest sends a request to the control API given by the --address flag.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - method: string - The HTTP method.
+//   - path: string - The path of the action, e.g. /status.
+//   - body: any - Marshalled as the JSON body if not nil.
+//   - result: any - Unmarshalled from the JSON response if not nil.
+//
+// Returns:
+//   - error: An error if the request fails or the action is rejected.
+func ctlRequest(cmd *cobra.Command, method, path string, body, result any) error {
+	addr, err := cmd.Flags().GetString("address")
+	if err != nil {
+		return fmt.Errorf("failed to get address: %v", err)
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get timeout: %v", err)
+	}
+	token, err := cmd.Flags().GetString("control-token")
+	if err != nil {
+		return fmt.Errorf("failed to get control token: %v", err)
+	}
+
+	network, address := parseControlAddress(addr)
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	// the host is ignored by the dialer above
+	req, err := http.NewRequest(method, "http://usque"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	// the control API refuses other POSTs, see rejectBrowserRequests
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %v", addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp controlResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			return fmt.Errorf("%s", errResp.Error)
+		}
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// secondsToDuration converts seconds as reported by the control API to a duration rounded to seconds,
+// or to milliseconds for values below a second.
+func secondsToDuration(seconds float64) time.Duration {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+func init() {
+	ctlCmd.PersistentFlags().StringP("address", "a", "unix:/tmp/usque.sock", "Address of the control API, e.g. unix:/run/usque.sock or 127.0.0.1:9091")
+	ctlCmd.PersistentFlags().String("control-token", "", "Bearer token of the control API, if it requires one")
+	ctlCmd.PersistentFlags().DurationP("timeout", "t", 5*time.Second, "Timeout for control API requests")
+	ctlStatusCmd.Flags().BoolP("json", "j", false, "Print the status as JSON")
+	ctlCmd.AddCommand(ctlStatusCmd, ctlReconnectCmd, ctlEndpointCmd, ctlSNICmd, ctlStopCmd)
+	rootCmd.AddCommand(ctlCmd)
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"crypto/x509"
 	"encoding/base64"
-	"fmt"
 	"log"
 
 	"github.com/Diniboy1123/usque/api"
@@ -19,8 +18,9 @@ var enrollCmd = &cobra.Command{
 	Long: "Enrolls a MASQUE private key and switches mode. Useful for ZeroTier where IPv6 address can change." +
 		" Or if you just want to deploy a new key.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !config.ConfigLoaded {
-			cmd.Println("Config not loaded. Please register first.")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
 			return
 		}
 
@@ -32,6 +32,11 @@ var enrollCmd = &cobra.Command{
 			log.Fatalf("Config path is required")
 		}
 
+		profile, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			log.Fatalf("Failed to get profile: %v", err)
+		}
+
 		deviceName, err := cmd.Flags().GetString("name")
 		if err != nil {
 			log.Fatalf("Failed to get device name: %v", err)
@@ -42,11 +47,16 @@ var enrollCmd = &cobra.Command{
 			log.Fatalf("Failed to get regen-key: %v", err)
 		}
 
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			log.Fatalf("Failed to configure API client: %v", err)
+		}
+
 		log.Printf("Enrolling device key...")
 
 		accountData := models.AccountData{
-			Token: config.AppConfig.AccessToken,
-			ID:    config.AppConfig.ID,
+			Token: cfg.AccessToken,
+			ID:    cfg.ID,
 		}
 
 		var (
@@ -61,7 +71,7 @@ var enrollCmd = &cobra.Command{
 				log.Fatalf("Failed to generate key pair: %v", err)
 			}
 		} else {
-			privKey, err := config.AppConfig.GetEcPrivateKey()
+			privKey, err := cfg.GetEcPrivateKey()
 			if err != nil {
 				log.Fatalf("Failed to get private key: %v", err)
 			}
@@ -77,17 +87,15 @@ var enrollCmd = &cobra.Command{
 			}
 		}
 
-		updatedAccountData, apiErr, err := api.EnrollKey(accountData, publicKey, deviceName)
+		updatedAccountData, apiErr, err := client.EnrollKey(accountData, publicKey, deviceName)
 		if err != nil {
-			if apiErr != nil && apiErr.HasErrorMessage(models.InvalidPublicKey) {
-				fmt.Print("Invalid public key detected. Regenerate key? (y/n): ")
-
-				var response string
-				if _, err := fmt.Scanln(&response); err != nil {
-					log.Fatalf("Failed to read user input: %v", err)
+			if api.IsInvalidPublicKey(err) {
+				regenerate, confirmErr := confirm(cmd, "Invalid public key detected. Regenerate key?", "regen-on-invalid")
+				if confirmErr != nil {
+					log.Fatalf("Failed to confirm key regeneration: %v", confirmErr)
 				}
 
-				if response == "y" {
+				if regenerate {
 					log.Printf("Regenerating key pair...")
 					privKeyBytes, publicKey, err = internal.GenerateEcKeyPair()
 					if err != nil {
@@ -95,7 +103,7 @@ var enrollCmd = &cobra.Command{
 					}
 
 					log.Println("Re-enrolling device key with new key pair...")
-					updatedAccountData, apiErr, err = api.EnrollKey(accountData, publicKey, deviceName)
+					updatedAccountData, apiErr, err = client.EnrollKey(accountData, publicKey, deviceName)
 					if err != nil {
 						if apiErr != nil {
 							log.Fatalf("Failed to enroll key: %v (API errors: %s)", err, apiErr.ErrorsAsString("; "))
@@ -105,29 +113,41 @@ var enrollCmd = &cobra.Command{
 				} else {
 					log.Fatalf("Enrollment aborted by user. API errors: %s", apiErr.ErrorsAsString("; "))
 				}
-			} else {
+			} else if apiErr != nil {
 				log.Fatalf("Failed to enroll key: %v (API errors: %s)", err, apiErr.ErrorsAsString("; "))
+			} else {
+				log.Fatalf("Failed to enroll key: %v", err)
 			}
 		}
 
 		log.Printf("Successful registration. Saving config...")
 
-		config.AppConfig = config.Config{
-			PrivateKey: base64.StdEncoding.EncodeToString(privKeyBytes),
-			// TODO: proper endpoint parsing in utils
-			// strip :0
-			EndpointV4: updatedAccountData.Config.Peers[0].Endpoint.V4[:len(updatedAccountData.Config.Peers[0].Endpoint.V4)-2],
-			// strip [ from beginning and ]:0 from end
-			EndpointV6:     updatedAccountData.Config.Peers[0].Endpoint.V6[1 : len(updatedAccountData.Config.Peers[0].Endpoint.V6)-3],
-			EndpointPubKey: updatedAccountData.Config.Peers[0].PublicKey,
-			License:        updatedAccountData.Account.License,
-			ID:             updatedAccountData.ID,
-			AccessToken:    accountData.Token,
-			IPv4:           updatedAccountData.Config.Interface.Addresses.V4,
-			IPv6:           updatedAccountData.Config.Interface.Addresses.V6,
+		cfg = &config.Config{
+			PrivateKey:  base64.StdEncoding.EncodeToString(privKeyBytes),
+			License:     updatedAccountData.Account.License,
+			ID:          updatedAccountData.ID,
+			AccessToken: accountData.Token,
+			IPv4:        updatedAccountData.Config.Interface.Addresses.V4,
+			IPv6:        updatedAccountData.Config.Interface.Addresses.V6,
+			SNI:         cfg.SNI,
+		}
+
+		skipped, err := cfg.SetPeers(updatedAccountData.Config.Peers)
+		if err != nil {
+			log.Fatalf("Failed to parse endpoints: %v", err)
+		}
+		for _, err := range skipped {
+			log.Printf("Warning: skipping %v", err)
 		}
 
-		config.AppConfig.SaveConfig(configPath)
+		passphrase, err := savePassphrase(cmd, configPath, profile)
+		if err != nil {
+			log.Fatalf("Failed to get passphrase: %v", err)
+		}
+
+		if err := cfg.SaveConfig(configPath, profile, passphrase); err != nil {
+			log.Fatalf("Failed to save config: %v", err)
+		}
 
 		log.Printf("Config saved to %s", configPath)
 	},
@@ -136,5 +156,8 @@ var enrollCmd = &cobra.Command{
 func init() {
 	enrollCmd.Flags().StringP("name", "n", "", "Rename device a given name")
 	enrollCmd.Flags().BoolP("regen-key", "r", false, "Regenerate the key pair")
+	enrollCmd.Flags().Bool("regen-on-invalid", false, "Regenerate the key pair without asking if the API rejects the current one")
+	addYesFlag(enrollCmd)
+	addAPIFlags(enrollCmd)
 	rootCmd.AddCommand(enrollCmd)
 }
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/Diniboy1123/usque/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the registration for other clients",
+	Long: "Renders the config as a connection bundle or as an outbound of another client." +
+		" A bundle holds the whole registration and can be imported on another machine with import or into the Android app." +
+		" Clash exports a MASQUE proxy for mihomo. sing-box and Xray can't speak MASQUE, so their outbound chains through usque socks.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			cmd.Printf("Failed to get format: %v\n", err)
+			return
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			cmd.Printf("Failed to get output: %v\n", err)
+			return
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			cmd.Printf("Failed to get name: %v\n", err)
+			return
+		}
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			cmd.Printf("Failed to get port: %v\n", err)
+			return
+		}
+		ipv6, err := cmd.Flags().GetBool("ipv6")
+		if err != nil {
+			cmd.Printf("Failed to get ipv6 flag: %v\n", err)
+			return
+		}
+		mtu, err := cmd.Flags().GetInt("mtu")
+		if err != nil {
+			cmd.Printf("Failed to get MTU: %v\n", err)
+			return
+		}
+		socksAddr, err := cmd.Flags().GetString("socks")
+		if err != nil {
+			cmd.Printf("Failed to get SOCKS address: %v\n", err)
+			return
+		}
+
+		var data []byte
+		switch format {
+		case "bundle":
+			data, err = cfg.Bundle()
+		case "clash":
+			server, family := cfg.EndpointV4, "IPv4"
+			if ipv6 {
+				server, family = cfg.EndpointV6, "IPv6"
+			}
+			if server == "" {
+				err = fmt.Errorf("the registration has no %s endpoint", family)
+				break
+			}
+			// a proxy holds a single port, take the first one the API registered
+			if port == 0 {
+				port = 443
+				if peer := cfg.EndpointPeer(); peer != nil && len(peer.Ports) > 0 {
+					port = peer.Ports[0]
+				}
+			}
+			data, err = exportClash(cfg, name, server, port, mtu)
+		case "sing-box", "xray":
+			data, err = exportSocksOutbound(format, name, socksAddr)
+		default:
+			err = fmt.Errorf("unknown format %q, expected bundle, clash, sing-box or xray", format)
+		}
+		if err != nil {
+			cmd.Printf("Failed to export config: %v\n", err)
+			return
+		}
+
+		if output == "" || output == "-" {
+			os.Stdout.Write(data)
+			return
+		}
+		// bundles and Clash proxies hold the private key
+		if err := os.WriteFile(output, data, 0600); err != nil {
+			cmd.Printf("Failed to write %s: %v\n", output, err)
+			return
+		}
+		cmd.Printf("Exported %s to %s\n", format, output)
+	},
+}
+
+// clashMasqueProxy is a MASQUE proxy of mihomo (Clash Meta).
+type clashMasqueProxy struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Server     string `yaml:"server"`
+	Port       int    `yaml:"port"`
+	PrivateKey string `yaml:"private-key"`
+	PublicKey  string `yaml:"public-key"`
+	IP         string `yaml:"ip,omitempty"`
+	IPv6       string `yaml:"ipv6,omitempty"`
+	MTU        int    `yaml:"mtu"`
+	UDP        bool   `yaml:"udp"`
+}
+
+// exportClash renders the config as a MASQUE proxy of mihomo.
+//
+// Parameters:
+//   - cfg: *config.Config - The configuration to export.
+//   - name: string - The name of the proxy.
+//   - server: string - The endpoint address to connect to.
+//   - port: int - The endpoint port to connect to.
+//   - mtu: int - The MTU of the tunnel.
+//
+// Returns:
+//   - []byte: The YAML of the proxies section.
+//   - error: An error if the endpoint public key is invalid.
+func exportClash(cfg *config.Config, name, server string, port, mtu int) ([]byte, error) {
+	block, _ := pem.Decode([]byte(cfg.EndpointPubKey))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode endpoint public key")
+	}
+
+	proxy := clashMasqueProxy{
+		Name:       name,
+		Type:       "masque",
+		Server:     server,
+		Port:       port,
+		PrivateKey: cfg.PrivateKey,
+		PublicKey:  base64.StdEncoding.EncodeToString(block.Bytes),
+		IP:         cfg.IPv4,
+		IPv6:       cfg.IPv6,
+		MTU:        mtu,
+		UDP:        true,
+	}
+
+	data, err := yaml.Marshal(map[string][]clashMasqueProxy{"proxies": {proxy}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proxy: %v", err)
+	}
+	return data, nil
+}
+
+// exportSocksOutbound renders an outbound of sing-box or Xray to a usque SOCKS proxy.
+//
+// Parameters:
+//   - format: string - Either sing-box or xray.
+//   - name: string - The tag of the outbound.
+//   - socksAddr: string - The address of the usque SOCKS proxy.
+//
+// Returns:
+//   - []byte: The JSON of the outbounds section.
+//   - error: An error if the address is invalid.
+func exportSocksOutbound(format, name, socksAddr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(socksAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS address: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid SOCKS port: %s", portStr)
+	}
+
+	var outbound map[string]any
+	if format == "sing-box" {
+		outbound = map[string]any{
+			"type":        "socks",
+			"tag":         name,
+			"server":      host,
+			"server_port": port,
+			"version":     "5",
+		}
+	} else {
+		outbound = map[string]any{
+			"tag":      name,
+			"protocol": "socks",
+			"settings": map[string]any{
+				"servers": []map[string]any{{"address": host, "port": port}},
+			},
+		}
+	}
+
+	data, err := json.MarshalIndent(map[string]any{"outbounds": []any{outbound}}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbound: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+func init() {
+	exportCmd.Flags().StringP("format", "f", "bundle", "Export format: bundle, clash, sing-box or xray")
+	exportCmd.Flags().StringP("output", "o", "", "File to write to (default is stdout)")
+	exportCmd.Flags().String("name", "warp", "Name of the exported proxy or outbound")
+	exportCmd.Flags().Int("port", 0, "Endpoint port of the Clash proxy (default is the first port registered for the endpoint, else 443)")
+	exportCmd.Flags().BoolP("ipv6", "6", false, "Use the IPv6 endpoint for the Clash proxy")
+	exportCmd.Flags().IntP("mtu", "m", 1280, "MTU of the Clash proxy")
+	exportCmd.Flags().String("socks", "127.0.0.1:1080", "Address of the usque SOCKS proxy the sing-box and Xray outbounds chain through")
+	rootCmd.AddCommand(exportCmd)
+}
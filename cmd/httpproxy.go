@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,7 +12,6 @@ import (
 	"time"
 
 	"github.com/Diniboy1123/usque/api"
-	"github.com/Diniboy1123/usque/config"
 	"github.com/Diniboy1123/usque/internal"
 	"github.com/spf13/cobra"
 	"golang.zx2c4.com/wireguard/tun/netstack"
@@ -22,8 +22,9 @@ var httpProxyCmd = &cobra.Command{
 	Short: "Expose Warp as an HTTP proxy with CONNECT support",
 	Long:  "Dual-stack HTTP proxy with CONNECT support. Doesn't require elevated privileges.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !config.ConfigLoaded {
-			cmd.Println("Config not loaded. Please register first.")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
 			return
 		}
 
@@ -33,12 +34,12 @@ var httpProxyCmd = &cobra.Command{
 			return
 		}
 
-		privKey, err := config.AppConfig.GetEcPrivateKey()
+		privKey, err := cfg.GetEcPrivateKey()
 		if err != nil {
 			cmd.Printf("Failed to get private key: %v\n", err)
 			return
 		}
-		peerPubKey, err := config.AppConfig.GetEcEndpointPublicKey()
+		peerPubKey, err := cfg.GetEcEndpointPublicKey()
 		if err != nil {
 			cmd.Printf("Failed to get public key: %v\n", err)
 			return
@@ -50,7 +51,13 @@ var httpProxyCmd = &cobra.Command{
 			return
 		}
 
-		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni)
+		keyLog, quicTracer, err := getTraceSettings(cmd)
+		if err != nil {
+			cmd.Printf("Invalid trace settings: %v\n", err)
+			return
+		}
+
+		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, keyLog)
 		if err != nil {
 			cmd.Printf("Failed to prepare TLS config: %v\n", err)
 			return
@@ -79,25 +86,12 @@ var httpProxyCmd = &cobra.Command{
 			return
 		}
 
-		connectPort, err := cmd.Flags().GetInt("connect-port")
+		endpoints, attemptDelay, err := getEndpoints(cmd, cfg)
 		if err != nil {
-			cmd.Printf("Failed to get connect port: %v\n", err)
+			cmd.Printf("Invalid endpoint settings: %v\n", err)
 			return
 		}
 
-		var endpoint *net.UDPAddr
-		if ipv6, err := cmd.Flags().GetBool("ipv6"); err == nil && !ipv6 {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV4),
-				Port: connectPort,
-			}
-		} else {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV6),
-				Port: connectPort,
-			}
-		}
-
 		tunnelIPv4, err := cmd.Flags().GetBool("no-tunnel-ipv4")
 		if err != nil {
 			cmd.Printf("Failed to get no tunnel IPv4: %v\n", err)
@@ -112,7 +106,7 @@ var httpProxyCmd = &cobra.Command{
 
 		var localAddresses []netip.Addr
 		if !tunnelIPv4 {
-			v4, err := netip.ParseAddr(config.AppConfig.IPv4)
+			v4, err := netip.ParseAddr(cfg.IPv4)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv4 address: %v\n", err)
 				return
@@ -120,7 +114,7 @@ var httpProxyCmd = &cobra.Command{
 			localAddresses = append(localAddresses, v4)
 		}
 		if !tunnelIPv6 {
-			v6, err := netip.ParseAddr(config.AppConfig.IPv6)
+			v6, err := netip.ParseAddr(cfg.IPv6)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv6 address: %v\n", err)
 				return
@@ -174,9 +168,33 @@ var httpProxyCmd = &cobra.Command{
 			password = p
 		}
 
-		reconnectDelay, err := cmd.Flags().GetDuration("reconnect-delay")
+		reconnectPolicy, err := getReconnectPolicy(cmd)
+		if err != nil {
+			cmd.Printf("Invalid reconnect settings: %v\n", err)
+			return
+		}
+
+		statsInterval, err := cmd.Flags().GetDuration("stats-interval")
+		if err != nil {
+			cmd.Printf("Failed to get stats interval: %v\n", err)
+			return
+		}
+
+		metricsListen, err := cmd.Flags().GetString("metrics-listen")
 		if err != nil {
-			cmd.Printf("Failed to get reconnect delay: %v\n", err)
+			cmd.Printf("Failed to get metrics listen address: %v\n", err)
+			return
+		}
+
+		controlListen, err := cmd.Flags().GetString("control-listen")
+		if err != nil {
+			cmd.Printf("Failed to get control listen address: %v\n", err)
+			return
+		}
+
+		controlToken, err := cmd.Flags().GetString("control-token")
+		if err != nil {
+			cmd.Printf("Failed to get control token: %v\n", err)
 			return
 		}
 
@@ -192,12 +210,57 @@ var httpProxyCmd = &cobra.Command{
 		}
 		defer tunDev.Close()
 
-		resolver := internal.GetProxyResolver(localDNS, tunNet, dnsAddrs, dnsTimeout)
+		ctx, cancel := newShutdownContext()
+		defer cancel()
+
+		tunnel := api.NewTunnel(api.TunnelConfig{
+			TLSConfig:         tlsConfig,
+			KeepalivePeriod:   keepalivePeriod,
+			InitialPacketSize: initialPacketSize,
+			Endpoints:         endpoints,
+			AttemptDelay:      attemptDelay,
+			MTU:               mtu,
+			ReconnectPolicy:   reconnectPolicy,
+			QuicTracer:        quicTracer,
+		})
+		metrics := &tunnelMetrics{tunnel: tunnel, dns: &internal.DNSStats{}, conns: &connCounter{}}
+		if metricsListen != "" {
+			if err := startMetricsServer(ctx, metricsListen, metrics); err != nil {
+				cmd.Printf("Failed to start metrics server: %v\n", err)
+				return
+			}
+		}
+		if controlListen != "" {
+			control := &controlServer{
+				tunnel:  tunnel,
+				stop:    cancel,
+				token:   controlToken,
+				ipv4:    cfg.IPv4,
+				ipv6:    cfg.IPv6,
+				started: time.Now(),
+			}
+			if err := startControlServer(ctx, controlListen, control); err != nil {
+				cmd.Printf("Failed to start control server: %v\n", err)
+				return
+			}
+		}
+		device, closeCapture, err := startCapture(cmd, api.NewNetstackAdapter(tunDev))
+		if err != nil {
+			cmd.Printf("Failed to start packet capture: %v\n", err)
+			return
+		}
+		defer closeCapture()
+		tunnelDone := runTunnel(ctx, cancel, tunnel, device)
+		if statsInterval > 0 {
+			go logStats(ctx, tunnel, statsInterval)
+		}
 
-		go api.MaintainTunnel(context.Background(), tlsConfig, keepalivePeriod, initialPacketSize, endpoint, api.NewNetstackAdapter(tunDev), mtu, reconnectDelay)
+		resolver := internal.TunnelDNSResolver{TunNet: tunNet, DNSAddrs: dnsAddrs, Timeout: dnsTimeout, Stats: metrics.dns}
+		if localDNS {
+			resolver.TunNet = nil
+		}
 
 		server := &http.Server{
-			Addr: net.JoinHostPort(bindAddress, port),
 			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if !authenticate(r, authHeader) {
 					w.Header().Set("Proxy-Authenticate", `Basic realm="Proxy"`)
@@ -213,10 +276,24 @@ var httpProxyCmd = &cobra.Command{
 			}),
 		}
 
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, port))
+		if err != nil {
+			cmd.Printf("Failed to start HTTP proxy: %v\n", err)
+			return
+		}
+
 		log.Printf("HTTP proxy listening on %s:%s\n", bindAddress, port)
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.Serve(metrics.conns.trackListener(listener)); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			cmd.Printf("Failed to start HTTP proxy: %v\n", err)
+			return
 		}
+
+		waitTunnel(tunnelDone)
 	},
 }
 
@@ -239,26 +316,19 @@ func authenticate(r *http.Request, expectedAuth string) bool {
 //   - w: http.ResponseWriter - The response writer for the HTTP request.
 //   - r: *http.Request - The incoming HTTP request.
 //   - tunNet: *netstack.Net - The netstack network interface.
-//   - resolver: *net.Resolver - The DNS resolver to use for the tunnel.
-func handleHTTPSConnect(w http.ResponseWriter, r *http.Request, tunNet *netstack.Net, resolver *net.Resolver) {
+//   - resolver: internal.TunnelDNSResolver - The DNS resolver to use for the tunnel.
+func handleHTTPSConnect(w http.ResponseWriter, r *http.Request, tunNet *netstack.Net, resolver internal.TunnelDNSResolver) {
 	ctx := r.Context()
 
-	host, port, err := net.SplitHostPort(r.Host)
-	if err != nil {
+	if _, _, err := net.SplitHostPort(r.Host); err != nil {
 		http.Error(w, "Invalid host", http.StatusBadRequest)
 		return
 	}
 
-	var destAddr string
-	if resolver != nil {
-		ips, err := resolver.LookupIP(ctx, "ip", host)
-		if err != nil || len(ips) == 0 {
-			http.Error(w, "DNS resolution failed", http.StatusServiceUnavailable)
-			return
-		}
-		destAddr = net.JoinHostPort(ips[0].String(), port)
-	} else {
-		destAddr = r.Host
+	destAddr, err := resolveDestination(ctx, resolver, r.Host)
+	if err != nil {
+		http.Error(w, "DNS resolution failed", http.StatusServiceUnavailable)
+		return
 	}
 
 	destConn, err := tunNet.DialContext(ctx, "tcp", destAddr)
@@ -302,8 +372,8 @@ func handleHTTPSConnect(w http.ResponseWriter, r *http.Request, tunNet *netstack
 //   - w: http.ResponseWriter - The response writer for the HTTP request.
 //   - r: *http.Request - The incoming HTTP request.
 //   - tunNet: *netstack.Net - The netstack network interface.
-//   - resolver: *net.Resolver - The DNS resolver to use for the tunnel.
-func handleHTTPProxy(w http.ResponseWriter, r *http.Request, tunNet *netstack.Net, resolver *net.Resolver) {
+//   - resolver: internal.TunnelDNSResolver - The DNS resolver to use for the tunnel.
+func handleHTTPProxy(w http.ResponseWriter, r *http.Request, tunNet *netstack.Net, resolver internal.TunnelDNSResolver) {
 	port := r.URL.Port()
 	if port == "" {
 		port = "80"
@@ -312,20 +382,9 @@ func handleHTTPProxy(w http.ResponseWriter, r *http.Request, tunNet *netstack.Ne
 	client := &http.Client{
 		Transport: &http.Transport{
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				host, port, err := net.SplitHostPort(addr)
+				dialAddr, err := resolveDestination(ctx, resolver, addr)
 				if err != nil {
-					return nil, fmt.Errorf("invalid address: %w", err)
-				}
-
-				var dialAddr string
-				if resolver != nil {
-					ips, err := resolver.LookupIP(ctx, "ip", host)
-					if err != nil || len(ips) == 0 {
-						return nil, fmt.Errorf("DNS resolution failed for %s: %w", host, err)
-					}
-					dialAddr = net.JoinHostPort(ips[0].String(), port)
-				} else {
-					dialAddr = addr
+					return nil, err
 				}
 
 				return tunNet.DialContext(ctx, network, dialAddr)
@@ -352,6 +411,32 @@ func handleHTTPProxy(w http.ResponseWriter, r *http.Request, tunNet *netstack.Ne
 	io.Copy(w, resp.Body)
 }
 
+// resolveDestination resolves the host of a destination address, IP addresses are returned as is.
+//
+// Parameters:
+//   - ctx: context.Context - The context for the DNS lookup.
+//   - resolver: internal.TunnelDNSResolver - The DNS resolver to use.
+//   - addr: string - The destination as host:port.
+//
+// Returns:
+//   - string: The destination as ip:port.
+//   - error: An error if the address is invalid or the host can't be resolved.
+func resolveDestination(ctx context.Context, resolver internal.TunnelDNSResolver, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address: %w", err)
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	_, ip, err := resolver.Resolve(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("DNS resolution failed for %s: %w", host, err)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
 // copyHeader copies HTTP headers from one header map to another.
 //
 // Parameters:
@@ -370,17 +455,21 @@ func init() {
 	httpProxyCmd.Flags().StringP("port", "p", "8000", "Port to listen on for HTTP proxy")
 	httpProxyCmd.Flags().StringP("username", "u", "", "Username for proxy authentication (specify both username and password to enable)")
 	httpProxyCmd.Flags().StringP("password", "w", "", "Password for proxy authentication (specify both username and password to enable)")
-	httpProxyCmd.Flags().IntP("connect-port", "P", 443, "Used port for MASQUE connection")
 	httpProxyCmd.Flags().StringArrayP("dns", "d", []string{"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9"}, "DNS servers to use")
 	httpProxyCmd.Flags().DurationP("dns-timeout", "t", 2*time.Second, "Timeout for DNS queries")
-	httpProxyCmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 for MASQUE connection")
 	httpProxyCmd.Flags().BoolP("no-tunnel-ipv4", "F", false, "Disable IPv4 inside the MASQUE tunnel")
 	httpProxyCmd.Flags().BoolP("no-tunnel-ipv6", "S", false, "Disable IPv6 inside the MASQUE tunnel")
 	httpProxyCmd.Flags().StringP("sni-address", "s", internal.ConnectSNI, "SNI address to use for MASQUE connection")
 	httpProxyCmd.Flags().DurationP("keepalive-period", "k", 30*time.Second, "Keepalive period for MASQUE connection")
 	httpProxyCmd.Flags().IntP("mtu", "m", 1280, "MTU for MASQUE connection")
 	httpProxyCmd.Flags().Uint16P("initial-packet-size", "i", 1242, "Initial packet size for MASQUE connection")
-	httpProxyCmd.Flags().DurationP("reconnect-delay", "r", 1*time.Second, "Delay between reconnect attempts")
+	addEndpointFlags(httpProxyCmd)
+	addReconnectFlags(httpProxyCmd)
+	addStatsFlags(httpProxyCmd)
+	addMetricsFlags(httpProxyCmd)
+	addControlFlags(httpProxyCmd)
+	addCaptureFlags(httpProxyCmd)
+	addTraceFlags(httpProxyCmd)
 	httpProxyCmd.Flags().BoolP("local-dns", "l", false, "Don't use the tunnel for DNS queries")
 	rootCmd.AddCommand(httpProxyCmd)
 }
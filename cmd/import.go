@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+
+	"github.com/Diniboy1123/usque/config"
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/Diniboy1123/usque/models"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>...",
+	Short: "Import a registration from the official WARP client, wgcf or a connection bundle",
+	Long: "Imports the device of another client and enrolls a new MASQUE key for it. Reads reg.json and conf.json" +
+		" of the official WARP client (e.g. /var/lib/cloudflare-warp/reg.json, useful for Zero Trust) or wgcf-account.toml of wgcf." +
+		" The device switches to MASQUE, so the other client can't keep using it." +
+		" A connection bundle written by export is imported as is.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalf("Failed to get config path: %v", err)
+		}
+		if configPath == "" {
+			log.Fatalf("Config path is required")
+		}
+
+		profile, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			log.Fatalf("Failed to get profile: %v", err)
+		}
+
+		deviceName, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Fatalf("Failed to get device name: %v", err)
+		}
+
+		sni, err := cmd.Flags().GetString("sni")
+		if err != nil {
+			log.Fatalf("Failed to get SNI: %v", err)
+		}
+
+		// a connection bundle already holds an enrolled key
+		var bundle *config.Config
+		if len(args) == 1 {
+			bundle, err = config.LoadBundle(args[0])
+			if err != nil && !errors.Is(err, config.ErrNotBundle) {
+				log.Fatalf("Failed to import bundle: %v", err)
+			}
+		}
+
+		var account config.ImportedAccount
+		if bundle == nil {
+			account, err = config.ImportAccount(args...)
+			if err != nil {
+				log.Fatalf("Failed to import registration: %v", err)
+			}
+		}
+
+		if store, err := config.LoadStore(configPath); err == nil {
+			if _, err := store.Get(profile); err == nil {
+				overwrite, err := confirm(cmd, "You already have a config. Do you want to overwrite it?", "force")
+				if err != nil {
+					log.Fatalf("Failed to confirm overwrite: %v", err)
+				}
+				if !overwrite {
+					return
+				}
+			}
+		}
+
+		passphrase, err := savePassphrase(cmd, configPath, profile)
+		if err != nil {
+			log.Fatalf("Failed to get passphrase: %v", err)
+		}
+
+		if bundle != nil {
+			if sni != "" {
+				bundle.SNI = sni
+			}
+			if err := bundle.SaveConfig(configPath, profile, passphrase); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+			log.Printf("Imported bundle of device %s, config saved to %s", bundle.ID, configPath)
+			return
+		}
+
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			log.Fatalf("Failed to configure API client: %v", err)
+		}
+
+		privKey, pubKey, err := internal.GenerateEcKeyPair()
+		if err != nil {
+			log.Fatalf("Failed to generate key pair: %v", err)
+		}
+
+		log.Printf("Enrolling device key for device %s...", account.ID)
+
+		accountData := models.AccountData{
+			ID:    account.ID,
+			Token: account.AccessToken,
+		}
+
+		updatedAccountData, apiErr, err := client.EnrollKey(accountData, pubKey, deviceName)
+		if err != nil {
+			if apiErr != nil {
+				log.Fatalf("Failed to enroll key: %v (API errors: %s)", err, apiErr.ErrorsAsString("; "))
+			}
+			log.Fatalf("Failed to enroll key: %v", err)
+		}
+
+		license := updatedAccountData.Account.License
+		if license == "" {
+			license = account.License
+		}
+
+		log.Printf("Successful import. Saving config...")
+
+		cfg := config.Config{
+			PrivateKey:  base64.StdEncoding.EncodeToString(privKey),
+			License:     license,
+			ID:          updatedAccountData.ID,
+			AccessToken: account.AccessToken,
+			IPv4:        updatedAccountData.Config.Interface.Addresses.V4,
+			IPv6:        updatedAccountData.Config.Interface.Addresses.V6,
+			SNI:         sni,
+		}
+
+		skipped, err := cfg.SetPeers(updatedAccountData.Config.Peers)
+		if err != nil {
+			log.Fatalf("Failed to parse endpoints: %v", err)
+		}
+		for _, err := range skipped {
+			log.Printf("Warning: skipping %v", err)
+		}
+
+		if err := cfg.SaveConfig(configPath, profile, passphrase); err != nil {
+			log.Fatalf("Failed to save config: %v", err)
+		}
+
+		log.Printf("Config saved to %s", configPath)
+	},
+}
+
+func init() {
+	importCmd.Flags().StringP("name", "n", "", "device name")
+	importCmd.Flags().String("sni", "", "Preferred SNI for the MASQUE connection, e.g. zt-masque.cloudflareclient.com for Zero Trust")
+	importCmd.Flags().BoolP("force", "f", false, "Overwrite an existing config without asking")
+	addYesFlag(importCmd)
+	addAPIFlags(importCmd)
+	rootCmd.AddCommand(importCmd)
+}
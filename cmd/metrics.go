@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/spf13/cobra"
+)
+
+// connCounter counts the client connections handled by a proxy.
+// A nil *connCounter counts nothing.
+type connCounter struct {
+	active atomic.Int64
+	total  atomic.Uint64
+}
+
+// track registers a new connection.
+//
+// Returns:
+//   - func(): Marks the connection as closed. Calling it more than once has no effect.
+func (c *connCounter) track() func() {
+	if c == nil {
+		return func() {}
+	}
+	c.active.Add(1)
+	c.total.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { c.active.Add(-1) })
+	}
+}
+
+// trackListener wraps a listener so every accepted connection is counted until it is closed.
+//
+// Parameters:
+//   - listener: net.Listener - The listener to wrap.
+//
+// Returns:
+//   - net.Listener: The wrapped listener.
+func (c *connCounter) trackListener(listener net.Listener) net.Listener {
+	if c == nil {
+		return listener
+	}
+	return &trackedListener{Listener: listener, counter: c}
+}
+
+// trackedListener is a net.Listener counting its connections in a connCounter.
+type trackedListener struct {
+	net.Listener
+	counter *connCounter
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, done: l.counter.track()}, nil
+}
+
+// trackedConn is a net.Conn that reports to its connCounter once closed.
+type trackedConn struct {
+	net.Conn
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	c.done()
+	return c.Conn.Close()
+}
+
+// tunnelMetrics collects everything exported on the metrics endpoint of a tunnel command.
+type tunnelMetrics struct {
+	tunnel *api.Tunnel
+	dns    *internal.DNSStats // nil if the command doesn't resolve names itself
+	conns  *connCounter       // nil if the command doesn't proxy connections
+}
+
+// addMetricsFlags registers the flags controlling the metrics endpoint of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addMetricsFlags(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-listen", "", "Serve Prometheus metrics on this address, e.g. 127.0.0.1:9090 (empty = disabled)")
+}
+
+// startMetricsServer serves the metrics in the Prometheus text format on /metrics until ctx is done.
+//
+// Parameters:
+//   - ctx: context.Context - Stops the server once done.
+//   - addr: string - The address to listen on.
+//   - metrics: *tunnelMetrics - The metrics to serve.
+//
+// Returns:
+//   - error: An error if the address can't be listened on.
+func startMetricsServer(ctx context.Context, addr string, metrics *tunnelMetrics) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.write(w)
+	})
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// write renders the metrics in the Prometheus text exposition format.
+//
+// Parameters:
+//   - w: io.Writer - The writer to render the metrics to.
+func (m *tunnelMetrics) write(w io.Writer) {
+	stats := m.tunnel.Stats()
+
+	connected := 0
+	if stats.Connected {
+		connected = 1
+	}
+	writeMetric(w, "usque_tunnel_connected", "gauge", "Whether the MASQUE tunnel is currently connected.", connected)
+	writeMetric(w, "usque_tunnel_session_uptime_seconds", "gauge", "Time since the current session was established.", stats.SessionUptime.Seconds())
+	writeMetric(w, "usque_tunnel_packets_sent_total", "counter", "IP packets sent into the tunnel.", stats.PacketsSent)
+	writeMetric(w, "usque_tunnel_bytes_sent_total", "counter", "Bytes sent into the tunnel.", stats.BytesSent)
+	writeMetric(w, "usque_tunnel_packets_received_total", "counter", "IP packets received from the tunnel.", stats.PacketsReceived)
+	writeMetric(w, "usque_tunnel_bytes_received_total", "counter", "Bytes received from the tunnel.", stats.BytesReceived)
+	writeMetric(w, "usque_tunnel_dropped_packets_total", "counter", "Packets dropped while the tunnel was not connected.", stats.DroppedPackets)
+	writeMetric(w, "usque_tunnel_write_errors_total", "counter", "Packets that could not be written to the tunnel or the device.", stats.WriteErrors)
+	writeMetric(w, "usque_tunnel_icmp_replies_total", "counter", "ICMP replies generated for packets that could not be proxied.", stats.ICMPReplies)
+	writeMetric(w, "usque_tunnel_sessions_total", "counter", "Sessions established.", stats.Sessions)
+	writeMetric(w, "usque_tunnel_reconnects_total", "counter", "Sessions established after the first one.", stats.Reconnects)
+	writeMetric(w, "usque_tunnel_failed_attempts_total", "counter", "Connection attempts that failed.", stats.FailedAttempts)
+
+	writeSummary(w, "usque_tunnel_handshake_duration_seconds", "Duration of successful QUIC and CONNECT-IP handshakes.", stats.HandshakeTime.Seconds(), stats.Handshakes)
+	writeMetric(w, "usque_tunnel_last_handshake_duration_seconds", "gauge", "Duration of the most recent successful handshake.", stats.LastHandshake.Seconds())
+
+	if stats.QUIC != nil {
+		writeMetric(w, "usque_quic_smoothed_rtt_seconds", "gauge", "Smoothed RTT of the current QUIC connection.", stats.QUIC.SmoothedRTT.Seconds())
+		writeMetric(w, "usque_quic_min_rtt_seconds", "gauge", "Minimum RTT of the current QUIC connection.", stats.QUIC.MinRTT.Seconds())
+		writeMetric(w, "usque_quic_latest_rtt_seconds", "gauge", "Latest RTT sample of the current QUIC connection.", stats.QUIC.LatestRTT.Seconds())
+		writeMetric(w, "usque_quic_packets_sent_total", "counter", "Packets sent on the current QUIC connection.", stats.QUIC.PacketsSent)
+		writeMetric(w, "usque_quic_packets_lost_total", "counter", "Packets lost on the current QUIC connection.", stats.QUIC.PacketsLost)
+	}
+
+	if m.dns != nil {
+		writeMetric(w, "usque_dns_queries_total", "counter", "DNS lookups performed by the proxy.", m.dns.Queries())
+		writeMetric(w, "usque_dns_query_failures_total", "counter", "DNS lookups that failed on every server.", m.dns.Failures())
+		writeSummary(w, "usque_dns_query_duration_seconds", "Duration of DNS lookups.", m.dns.TotalLatency().Seconds(), m.dns.Queries())
+	}
+
+	if m.conns != nil {
+		writeMetric(w, "usque_proxy_active_connections", "gauge", "Client connections currently handled by the proxy.", m.conns.active.Load())
+		writeMetric(w, "usque_proxy_connections_total", "counter", "Client connections accepted by the proxy.", m.conns.total.Load())
+	}
+}
+
+// writeMetric renders a single unlabelled metric with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// writeSummary renders a summary without quantiles, i.e. only its sum and count.
+func writeSummary(w io.Writer, name, help string, sum float64, count uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n%s_sum %v\n%s_count %d\n", name, help, name, name, sum, name, count)
+}
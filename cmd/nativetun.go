@@ -1,9 +1,7 @@
 package cmd
 
 import (
-	"context"
 	"log"
-	"net"
 	"time"
 
 	"github.com/Diniboy1123/usque/api"
@@ -18,6 +16,7 @@ type tunDevice struct {
 	iproute2 bool
 	ipv4     bool
 	ipv6     bool
+	config   *config.Config
 }
 
 var nativeTunCmd = &cobra.Command{
@@ -25,8 +24,9 @@ var nativeTunCmd = &cobra.Command{
 	Short: "Expose Warp as a native TUN device",
 	Long:  longDescription,
 	Run: func(cmd *cobra.Command, args []string) {
-		if !config.ConfigLoaded {
-			cmd.Println("Config not loaded. Please register first.")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
 			return
 		}
 
@@ -36,12 +36,12 @@ var nativeTunCmd = &cobra.Command{
 			return
 		}
 
-		privKey, err := config.AppConfig.GetEcPrivateKey()
+		privKey, err := cfg.GetEcPrivateKey()
 		if err != nil {
 			cmd.Printf("Failed to get private key: %v\n", err)
 			return
 		}
-		peerPubKey, err := config.AppConfig.GetEcEndpointPublicKey()
+		peerPubKey, err := cfg.GetEcEndpointPublicKey()
 		if err != nil {
 			cmd.Printf("Failed to get public key: %v\n", err)
 			return
@@ -53,7 +53,13 @@ var nativeTunCmd = &cobra.Command{
 			return
 		}
 
-		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni)
+		keyLog, quicTracer, err := getTraceSettings(cmd)
+		if err != nil {
+			cmd.Printf("Invalid trace settings: %v\n", err)
+			return
+		}
+
+		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, keyLog)
 		if err != nil {
 			cmd.Printf("Failed to prepare TLS config: %v\n", err)
 			return
@@ -70,25 +76,12 @@ var nativeTunCmd = &cobra.Command{
 			return
 		}
 
-		connectPort, err := cmd.Flags().GetInt("connect-port")
+		endpoints, attemptDelay, err := getEndpoints(cmd, cfg)
 		if err != nil {
-			cmd.Printf("Failed to get connect port: %v\n", err)
+			cmd.Printf("Invalid endpoint settings: %v\n", err)
 			return
 		}
 
-		var endpoint *net.UDPAddr
-		if ipv6, err := cmd.Flags().GetBool("ipv6"); err == nil && !ipv6 {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV4),
-				Port: connectPort,
-			}
-		} else {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV6),
-				Port: connectPort,
-			}
-		}
-
 		tunnelIPv4, err := cmd.Flags().GetBool("no-tunnel-ipv4")
 		if err != nil {
 			cmd.Printf("Failed to get no tunnel IPv4: %v\n", err)
@@ -116,9 +109,33 @@ var nativeTunCmd = &cobra.Command{
 			return
 		}
 
-		reconnectDelay, err := cmd.Flags().GetDuration("reconnect-delay")
+		reconnectPolicy, err := getReconnectPolicy(cmd)
 		if err != nil {
-			cmd.Printf("Failed to get reconnect delay: %v\n", err)
+			cmd.Printf("Invalid reconnect settings: %v\n", err)
+			return
+		}
+
+		statsInterval, err := cmd.Flags().GetDuration("stats-interval")
+		if err != nil {
+			cmd.Printf("Failed to get stats interval: %v\n", err)
+			return
+		}
+
+		metricsListen, err := cmd.Flags().GetString("metrics-listen")
+		if err != nil {
+			cmd.Printf("Failed to get metrics listen address: %v\n", err)
+			return
+		}
+
+		controlListen, err := cmd.Flags().GetString("control-listen")
+		if err != nil {
+			cmd.Printf("Failed to get control listen address: %v\n", err)
+			return
+		}
+
+		controlToken, err := cmd.Flags().GetString("control-token")
+		if err != nil {
+			cmd.Printf("Failed to get control token: %v\n", err)
 			return
 		}
 
@@ -142,6 +159,7 @@ var nativeTunCmd = &cobra.Command{
 			iproute2: !setIproute2,
 			ipv4:     !tunnelIPv4,
 			ipv6:     !tunnelIPv6,
+			config:   cfg,
 		}
 
 		dev, err := t.create()
@@ -152,17 +170,63 @@ var nativeTunCmd = &cobra.Command{
 
 		log.Printf("Created TUN device: %s", t.name)
 
-		go api.MaintainTunnel(context.Background(), tlsConfig, keepalivePeriod, initialPacketSize, endpoint, dev, mtu, reconnectDelay)
+		ctx, cancel := newShutdownContext()
+		defer cancel()
+
+		tunnel := api.NewTunnel(api.TunnelConfig{
+			TLSConfig:         tlsConfig,
+			KeepalivePeriod:   keepalivePeriod,
+			InitialPacketSize: initialPacketSize,
+			Endpoints:         endpoints,
+			AttemptDelay:      attemptDelay,
+			MTU:               mtu,
+			ReconnectPolicy:   reconnectPolicy,
+			QuicTracer:        quicTracer,
+		})
+		metrics := &tunnelMetrics{tunnel: tunnel}
+		if metricsListen != "" {
+			if err := startMetricsServer(ctx, metricsListen, metrics); err != nil {
+				cmd.Printf("Failed to start metrics server: %v\n", err)
+				return
+			}
+		}
+		if controlListen != "" {
+			control := &controlServer{
+				tunnel:  tunnel,
+				stop:    cancel,
+				token:   controlToken,
+				ipv4:    cfg.IPv4,
+				ipv6:    cfg.IPv6,
+				started: time.Now(),
+			}
+			if err := startControlServer(ctx, controlListen, control); err != nil {
+				cmd.Printf("Failed to start control server: %v\n", err)
+				return
+			}
+		}
+		dev, closeCapture, err := startCapture(cmd, dev)
+		if err != nil {
+			cmd.Printf("Failed to start packet capture: %v\n", err)
+			return
+		}
+		defer closeCapture()
+		connected := connectedSignal(tunnel)
+		tunnelDone := runTunnel(ctx, cancel, tunnel, dev)
+		if statsInterval > 0 {
+			go logStats(ctx, tunnel, statsInterval)
+		}
 
-		log.Println("Tunnel established, you may now set up routing and DNS")
+		select {
+		case <-connected:
+			log.Println("Tunnel established, you may now set up routing and DNS")
+		case <-ctx.Done():
+		}
 
-		select {}
+		waitTunnel(tunnelDone)
 	},
 }
 
 func init() {
-	nativeTunCmd.Flags().IntP("connect-port", "P", 443, "Used port for MASQUE connection")
-	nativeTunCmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 for MASQUE connection")
 	nativeTunCmd.Flags().BoolP("no-tunnel-ipv4", "F", false, "Disable IPv4 inside the MASQUE tunnel")
 	nativeTunCmd.Flags().BoolP("no-tunnel-ipv6", "S", false, "Disable IPv6 inside the MASQUE tunnel")
 	nativeTunCmd.Flags().StringP("sni-address", "s", internal.ConnectSNI, "SNI address to use for MASQUE connection")
@@ -170,7 +234,13 @@ func init() {
 	nativeTunCmd.Flags().IntP("mtu", "m", 1280, "MTU for MASQUE connection")
 	nativeTunCmd.Flags().Uint16P("initial-packet-size", "i", 1242, "Initial packet size for MASQUE connection")
 	nativeTunCmd.Flags().BoolP("no-iproute2", "I", false, "Linux only: Do not set up IP addresses and do not set the link up")
-	nativeTunCmd.Flags().DurationP("reconnect-delay", "r", 1*time.Second, "Delay between reconnect attempts")
+	addEndpointFlags(nativeTunCmd)
+	addReconnectFlags(nativeTunCmd)
+	addStatsFlags(nativeTunCmd)
+	addMetricsFlags(nativeTunCmd)
+	addControlFlags(nativeTunCmd)
+	addCaptureFlags(nativeTunCmd)
+	addTraceFlags(nativeTunCmd)
 	nativeTunCmd.Flags().StringP("interface-name", "n", "", "Custom inteface name for the TUN interface")
 	rootCmd.AddCommand(nativeTunCmd)
 }
@@ -8,7 +8,6 @@ import (
 	"net"
 
 	"github.com/Diniboy1123/usque/api"
-	"github.com/Diniboy1123/usque/config"
 	"github.com/songgao/water"
 	"github.com/vishvananda/netlink"
 )
@@ -40,7 +39,7 @@ func (t *tunDevice) create() (api.TunnelDevice, error) {
 		if t.ipv4 {
 			if err := netlink.AddrAdd(link, &netlink.Addr{
 				IPNet: &net.IPNet{
-					IP:   net.ParseIP(config.AppConfig.IPv4),
+					IP:   net.ParseIP(t.config.IPv4),
 					Mask: net.CIDRMask(32, 32),
 				}}); err != nil {
 				return nil, fmt.Errorf("failed to add IPv4 address: %v", err)
@@ -49,7 +48,7 @@ func (t *tunDevice) create() (api.TunnelDevice, error) {
 		if t.ipv6 {
 			if err := netlink.AddrAdd(link, &netlink.Addr{
 				IPNet: &net.IPNet{
-					IP:   net.ParseIP(config.AppConfig.IPv6),
+					IP:   net.ParseIP(t.config.IPv6),
 					Mask: net.CIDRMask(128, 128),
 				}}); err != nil {
 				return nil, fmt.Errorf("failed to add IPv6 address: %v", err)
@@ -61,8 +60,8 @@ func (t *tunDevice) create() (api.TunnelDevice, error) {
 	} else {
 		log.Println("Skipping IP address and link setup. You should set the link up manually.")
 		log.Println("Config has the following IP addresses:")
-		log.Printf("IPv4: %s", config.AppConfig.IPv4)
-		log.Printf("IPv6: %s", config.AppConfig.IPv6)
+		log.Printf("IPv4: %s", t.config.IPv4)
+		log.Printf("IPv6: %s", t.config.IPv6)
 	}
 
 	return api.NewWaterAdapter(dev), nil
@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/Diniboy1123/usque/api"
-	"github.com/Diniboy1123/usque/config"
 	"github.com/Diniboy1123/usque/internal"
 	"golang.zx2c4.com/wireguard/tun"
 )
@@ -30,7 +29,7 @@ func (t *tunDevice) create() (api.TunnelDevice, error) {
 	}
 
 	if t.ipv4 {
-		err = internal.SetIPv4Address(t.name, config.AppConfig.IPv4, "255.255.255.255")
+		err = internal.SetIPv4Address(t.name, t.config.IPv4, "255.255.255.255")
 		if err != nil {
 			return nil, fmt.Errorf("failed to set IPv4 address: %v", err)
 		}
@@ -42,7 +41,7 @@ func (t *tunDevice) create() (api.TunnelDevice, error) {
 	}
 
 	if t.ipv6 {
-		err = internal.SetIPv6Address(t.name, config.AppConfig.IPv6, "128")
+		err = internal.SetIPv6Address(t.name, t.config.IPv6, "128")
 		if err != nil {
 			return nil, fmt.Errorf("failed to set IPv6 address: %v", err)
 		}
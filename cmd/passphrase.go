@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Diniboy1123/usque/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// passphraseEnv is the environment variable holding the passphrase of encrypted configs.
+const passphraseEnv = envPrefix + "PASSPHRASE"
+
+// promptedPassphrase caches the passphrase typed by the user, so it is only asked once per run.
+var promptedPassphrase []byte
+
+// configPassphrase returns the passphrase of encrypted configs. It is taken from the USQUE_PASSPHRASE
+// environment variable, the file given with --passphrase-file or asked for on the terminal, in that order.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - confirm: bool - Whether a passphrase typed by the user has to be repeated, for newly encrypted configs.
+//
+// Returns:
+//   - config.Passphrase: Supplies the passphrase when needed.
+func configPassphrase(cmd *cobra.Command, confirm bool) config.Passphrase {
+	return func() ([]byte, error) {
+		pass, ok, err := settingsPassphrase(cmd)
+		if err != nil || ok {
+			return pass, err
+		}
+		if promptedPassphrase != nil {
+			return promptedPassphrase, nil
+		}
+
+		pass, err = promptPassphrase("Config passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			again, err := promptPassphrase("Repeat passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(pass, again) {
+				return nil, errors.New("the passphrases don't match")
+			}
+		}
+
+		promptedPassphrase = pass
+		return pass, nil
+	}
+}
+
+// savePassphrase decides whether a profile is saved encrypted: if --encrypt is given, a passphrase is set
+// in the environment or a file, or the profile is already encrypted in the config file.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - configPath: string - The path to the configuration JSON file.
+//   - profile: string - The profile to save to, the default profile of the file if empty.
+//
+// Returns:
+//   - config.Passphrase: Supplies the passphrase to encrypt with, nil to save in plaintext.
+//   - error: An error if the flags can't be read or the config file can't be loaded.
+func savePassphrase(cmd *cobra.Command, configPath, profile string) (config.Passphrase, error) {
+	encrypt, err := cmd.Flags().GetBool("encrypt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encrypt flag: %v", err)
+	}
+
+	store, err := config.LoadOrNewStore(configPath)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := store.IsEncrypted(profile)
+
+	_, fromSettings, err := settingsPassphrase(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if !encrypt && !encrypted && !fromSettings {
+		return nil, nil
+	}
+	return configPassphrase(cmd, !encrypted), nil
+}
+
+// settingsPassphrase reads the passphrase from the USQUE_PASSPHRASE environment variable or the file given with --passphrase-file.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//
+// Returns:
+//   - []byte: The passphrase.
+//   - bool: Whether a passphrase was set.
+//   - error: An error if the passphrase file can't be read or is empty.
+func settingsPassphrase(cmd *cobra.Command) ([]byte, bool, error) {
+	if pass, ok := os.LookupEnv(passphraseEnv); ok && pass != "" {
+		return []byte(pass), true, nil
+	}
+
+	path, err := cmd.Flags().GetString("passphrase-file")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get passphrase file: %v", err)
+	}
+	if path == "" {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read passphrase file: %v", err)
+	}
+	pass := strings.TrimRight(string(data), "\r\n")
+	if pass == "" {
+		return nil, false, fmt.Errorf("passphrase file %s is empty", path)
+	}
+	return []byte(pass), true, nil
+}
+
+// promptPassphrase asks for a passphrase on the terminal without echoing it.
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to ask for the passphrase, set %s or --passphrase-file", passphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("the passphrase is empty")
+	}
+	return pass, nil
+}
@@ -2,18 +2,23 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Diniboy1123/usque/api"
-	"github.com/Diniboy1123/usque/config"
 	"github.com/Diniboy1123/usque/internal"
 	"github.com/spf13/cobra"
+	"github.com/things-go/go-socks5"
 	"golang.zx2c4.com/wireguard/tun/netstack"
 )
 
@@ -21,11 +26,12 @@ var portFwCmd = &cobra.Command{
 	Use:   "portfw",
 	Short: "Forward ports through a MASQUE tunnel",
 	Long: "This tool is useful if you have Cloudflare Zero Trust Gateway enabled and want to forward ports to/from the tunnel." +
-		" It creates a virtual TUN device and forward ports through it either from or to the client. It works a bit like SSH port forwarding. TCP only at the moment." +
+		" It creates a virtual TUN device and forward ports through it either from or to the client. It works a bit like SSH port forwarding, including dynamic forwarding through a SOCKS5 proxy. Supports TCP and UDP. " +
 		"Doesn't require elevated privileges.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !config.ConfigLoaded {
-			cmd.Println("Config not loaded. Please register first.")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
 			return
 		}
 
@@ -35,12 +41,12 @@ var portFwCmd = &cobra.Command{
 			return
 		}
 
-		privKey, err := config.AppConfig.GetEcPrivateKey()
+		privKey, err := cfg.GetEcPrivateKey()
 		if err != nil {
 			cmd.Printf("Failed to get private key: %v\n", err)
 			return
 		}
-		peerPubKey, err := config.AppConfig.GetEcEndpointPublicKey()
+		peerPubKey, err := cfg.GetEcEndpointPublicKey()
 		if err != nil {
 			cmd.Printf("Failed to get public key: %v\n", err)
 			return
@@ -52,7 +58,13 @@ var portFwCmd = &cobra.Command{
 			return
 		}
 
-		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni)
+		keyLog, quicTracer, err := getTraceSettings(cmd)
+		if err != nil {
+			cmd.Printf("Invalid trace settings: %v\n", err)
+			return
+		}
+
+		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, keyLog)
 		if err != nil {
 			cmd.Printf("Failed to prepare TLS config: %v\n", err)
 			return
@@ -69,25 +81,12 @@ var portFwCmd = &cobra.Command{
 			return
 		}
 
-		connectPort, err := cmd.Flags().GetInt("connect-port")
+		endpoints, attemptDelay, err := getEndpoints(cmd, cfg)
 		if err != nil {
-			cmd.Printf("Failed to get connect port: %v\n", err)
+			cmd.Printf("Invalid endpoint settings: %v\n", err)
 			return
 		}
 
-		var endpoint *net.UDPAddr
-		if ipv6, err := cmd.Flags().GetBool("ipv6"); err == nil && !ipv6 {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV4),
-				Port: connectPort,
-			}
-		} else {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV6),
-				Port: connectPort,
-			}
-		}
-
 		tunnelIPv4, err := cmd.Flags().GetBool("no-tunnel-ipv4")
 		if err != nil {
 			cmd.Printf("Failed to get no tunnel IPv4: %v\n", err)
@@ -102,7 +101,7 @@ var portFwCmd = &cobra.Command{
 
 		var localAddresses []netip.Addr
 		if !tunnelIPv4 {
-			v4, err := netip.ParseAddr(config.AppConfig.IPv4)
+			v4, err := netip.ParseAddr(cfg.IPv4)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv4 address: %v\n", err)
 				return
@@ -110,7 +109,7 @@ var portFwCmd = &cobra.Command{
 			localAddresses = append(localAddresses, v4)
 		}
 		if !tunnelIPv6 {
-			v6, err := netip.ParseAddr(config.AppConfig.IPv6)
+			v6, err := netip.ParseAddr(cfg.IPv6)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv6 address: %v\n", err)
 				return
@@ -164,6 +163,10 @@ var portFwCmd = &cobra.Command{
 				cmd.Printf("Failed to parse local port mapping: %v\n", err)
 				return
 			}
+			if portMapping.RemoteSocket != "" {
+				cmd.Printf("Failed to parse local port mapping: can't connect to a unix socket inside the tunnel, use -R\n")
+				return
+			}
 			localPortMappings = append(localPortMappings, portMapping)
 		}
 
@@ -173,12 +176,89 @@ var portFwCmd = &cobra.Command{
 				cmd.Printf("Failed to parse remote port mapping: %v\n", err)
 				return
 			}
+			if portMapping.BindSocket != "" {
+				cmd.Printf("Failed to parse remote port mapping: can't listen on a unix socket inside the tunnel, use -L\n")
+				return
+			}
 			remotePortMappings = append(remotePortMappings, portMapping)
 		}
 
-		reconnectDelay, err := cmd.Flags().GetDuration("reconnect-delay")
+		rulesPath, err := cmd.Flags().GetString("rules")
+		if err != nil {
+			cmd.Printf("Failed to get rules file: %v\n", err)
+			return
+		}
+
+		rulesInterval, err := cmd.Flags().GetDuration("rules-interval")
+		if err != nil {
+			cmd.Printf("Failed to get rules interval: %v\n", err)
+			return
+		}
+
+		var ruleLocal, ruleRemote []internal.PortMapping
+		if rulesPath != "" {
+			ruleLocal, ruleRemote, err = internal.LoadForwardRules(rulesPath)
+			if err != nil {
+				cmd.Printf("Failed to load rules: %v\n", err)
+				return
+			}
+		}
+
+		dynamicPorts, err := cmd.Flags().GetStringArray("dynamic-ports")
 		if err != nil {
-			cmd.Printf("Failed to get reconnect delay: %v\n", err)
+			cmd.Printf("Failed to get dynamic ports: %v\n", err)
+			return
+		}
+
+		var dynamicAddrs []string
+		for _, port := range dynamicPorts {
+			bindAddress, localPort, err := internal.ParseDynamicForward(port)
+			if err != nil {
+				cmd.Printf("Failed to parse dynamic port forwarding: %v\n", err)
+				return
+			}
+			dynamicAddrs = append(dynamicAddrs, net.JoinHostPort(bindAddress, strconv.Itoa(localPort)))
+		}
+
+		dnsTimeout, err := cmd.Flags().GetDuration("dns-timeout")
+		if err != nil {
+			cmd.Printf("Failed to get DNS timeout: %v\n", err)
+			return
+		}
+
+		udpTimeout, err := cmd.Flags().GetDuration("udp-timeout")
+		if err != nil {
+			cmd.Printf("Failed to get UDP timeout: %v\n", err)
+			return
+		}
+
+		reconnectPolicy, err := getReconnectPolicy(cmd)
+		if err != nil {
+			cmd.Printf("Invalid reconnect settings: %v\n", err)
+			return
+		}
+
+		statsInterval, err := cmd.Flags().GetDuration("stats-interval")
+		if err != nil {
+			cmd.Printf("Failed to get stats interval: %v\n", err)
+			return
+		}
+
+		metricsListen, err := cmd.Flags().GetString("metrics-listen")
+		if err != nil {
+			cmd.Printf("Failed to get metrics listen address: %v\n", err)
+			return
+		}
+
+		controlListen, err := cmd.Flags().GetString("control-listen")
+		if err != nil {
+			cmd.Printf("Failed to get control listen address: %v\n", err)
+			return
+		}
+
+		controlToken, err := cmd.Flags().GetString("control-token")
+		if err != nil {
+			cmd.Printf("Failed to get control token: %v\n", err)
 			return
 		}
 
@@ -189,28 +269,91 @@ var portFwCmd = &cobra.Command{
 		}
 		defer tunDev.Close()
 
-		go api.MaintainTunnel(context.Background(), tlsConfig, keepalivePeriod, initialPacketSize, endpoint, api.NewNetstackAdapter(tunDev), mtu, reconnectDelay)
+		ctx, cancel := newShutdownContext()
+		defer cancel()
+
+		tunnel := api.NewTunnel(api.TunnelConfig{
+			TLSConfig:         tlsConfig,
+			KeepalivePeriod:   keepalivePeriod,
+			InitialPacketSize: initialPacketSize,
+			Endpoints:         endpoints,
+			AttemptDelay:      attemptDelay,
+			MTU:               mtu,
+			ReconnectPolicy:   reconnectPolicy,
+			QuicTracer:        quicTracer,
+		})
+		metrics := &tunnelMetrics{tunnel: tunnel, dns: &internal.DNSStats{}, conns: &connCounter{}}
+		if metricsListen != "" {
+			if err := startMetricsServer(ctx, metricsListen, metrics); err != nil {
+				cmd.Printf("Failed to start metrics server: %v\n", err)
+				return
+			}
+		}
+		if controlListen != "" {
+			control := &controlServer{
+				tunnel:  tunnel,
+				stop:    cancel,
+				token:   controlToken,
+				ipv4:    cfg.IPv4,
+				ipv6:    cfg.IPv6,
+				started: time.Now(),
+			}
+			if err := startControlServer(ctx, controlListen, control); err != nil {
+				cmd.Printf("Failed to start control server: %v\n", err)
+				return
+			}
+		}
+		connected := connectedSignal(tunnel)
+		device, closeCapture, err := startCapture(cmd, api.NewNetstackAdapter(tunDev))
+		if err != nil {
+			cmd.Printf("Failed to start packet capture: %v\n", err)
+			return
+		}
+		defer closeCapture()
+		tunnelDone := runTunnel(ctx, cancel, tunnel, device)
+		if statsInterval > 0 {
+			go logStats(ctx, tunnel, statsInterval)
+		}
 
 		log.Printf("Virtual tunnel created, forwarding ports")
 
 		// Start Local Port Forwarding (-L)
 		for _, pm := range localPortMappings {
-			go func(pm internal.PortMapping) {
-				err := forwardPort(tunNet, pm, false) // false = local forwarding
-				if err != nil {
-					cmd.Printf("Error in local forwarding %d: %v\n", pm.LocalPort, err)
-				}
-			}(pm)
+			go runForward(ctx, tunNet, pm, false, udpTimeout, metrics.conns) // false = local forwarding
 		}
 
 		// Start Remote Port Forwarding (-R)
 		for _, pm := range remotePortMappings {
-			go func(pm internal.PortMapping) {
-				err := forwardPort(tunNet, pm, true) // true = remote forwarding
-				if err != nil {
-					cmd.Printf("Error in remote forwarding %d: %v\n", pm.LocalPort, err)
-				}
-			}(pm)
+			go runForward(ctx, tunNet, pm, true, udpTimeout, metrics.conns) // true = remote forwarding
+		}
+
+		// Start the forwardings of the rules file, reloading it on changes
+		if rulesPath != "" {
+			rules := &forwardRules{path: rulesPath, tunNet: tunNet, udpTimeout: udpTimeout, conns: metrics.conns}
+			rules.apply(ctx, ruleLocal, ruleRemote)
+			if rulesInterval > 0 {
+				go rules.watch(ctx, rulesInterval)
+			}
+		}
+
+		// Start Dynamic Port Forwarding (-D)
+		if len(dynamicAddrs) > 0 {
+			resolver := internal.TunnelDNSResolver{TunNet: tunNet, DNSAddrs: dnsAddrs, Timeout: dnsTimeout, Stats: metrics.dns}
+			server := newSocksServer(tunNet, localAddresses, resolver, udpTimeout, "", "")
+			for _, addr := range dynamicAddrs {
+				go func(addr string) {
+					if err := forwardDynamic(ctx, server, addr, metrics.conns); err != nil {
+						cmd.Printf("Error in dynamic forwarding %s: %v\n", addr, err)
+					}
+				}(addr)
+			}
+		}
+
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			waitTunnel(tunnelDone)
+			return
 		}
 
 		// One packet must be sent in order to listen for incoming packets
@@ -232,66 +375,138 @@ var portFwCmd = &cobra.Command{
 		}
 		log.Println("Successfully connected to Cloudflare")
 
-		select {}
+		waitTunnel(tunnelDone)
 	},
 }
 
+// runForward runs a port forwarding of either protocol until ctx is done and logs why it failed otherwise.
+//
+// Parameters:
+//   - ctx: context.Context - Stops the forwarding once done.
+//   - netstackNet: *netstack.Net - The network stack of the MASQUE tunnel.
+//   - pm: internal.PortMapping - The port mapping to forward.
+//   - isRemote: bool - Indicates whether the forwarding is remote (true) or local (false).
+//   - udpTimeout: time.Duration - How long UDP flows may stay idle.
+//   - conns: *connCounter - Counts the forwarded connections, may be nil.
+func runForward(ctx context.Context, netstackNet *netstack.Net, pm internal.PortMapping, isRemote bool, udpTimeout time.Duration, conns *connCounter) {
+	var err error
+	if pm.Protocol == "udp" {
+		err = forwardUDPPort(ctx, netstackNet, pm, isRemote, udpTimeout, conns)
+	} else {
+		err = forwardPort(ctx, netstackNet, pm, isRemote, conns)
+	}
+	if err == nil {
+		return
+	}
+
+	direction, name := "local", strconv.Itoa(pm.LocalPort)
+	if isRemote {
+		direction = "remote"
+	}
+	if pm.BindSocket != "" {
+		name = pm.BindSocket
+	}
+	log.Printf("Error in %s forwarding %s: %v", direction, name, err)
+}
+
 // forwardPort sets up a local or remote port forwarding using either the MASQUE tunnel or the local network.
 //
 // Parameters:
+//   - ctx: context.Context - Stops the forwarding once done, connections already accepted are kept.
 //   - netstackNet: *netstack.Net - The network stack used for handling remote forwarding.
 //   - pm: internal.PortMapping - The port mapping configuration containing bind address, local port, remote IP, and remote port.
 //   - isRemote: bool - Indicates whether the forwarding is remote (true) or local (false).
+//   - conns: *connCounter - Counts the forwarded connections, may be nil.
 //
 // Returns:
-//   - error: An error if port forwarding fails; otherwise, nil.
-func forwardPort(netstackNet *netstack.Net, pm internal.PortMapping, isRemote bool) error {
-	localAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", pm.BindAddress, pm.LocalPort))
-	if err != nil {
-		return fmt.Errorf("invalid local address: %w", err)
-	}
-
+//   - error: An error if port forwarding fails; nil once ctx is done.
+func forwardPort(ctx context.Context, netstackNet *netstack.Net, pm internal.PortMapping, isRemote bool, conns *connCounter) error {
+	var listener net.Listener
 	if isRemote {
+		localAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", pm.BindAddress, pm.LocalPort))
+		if err != nil {
+			return fmt.Errorf("invalid local address: %w", err)
+		}
+
 		// Remote forwarding: Listen inside the MASQUE tunnel
-		listener, err := netstackNet.ListenTCPAddrPort(localAddrPort)
+		listener, err = netstackNet.ListenTCPAddrPort(localAddrPort)
 		if err != nil {
 			return fmt.Errorf("failed to listen on %s: %w", localAddrPort, err)
 		}
-		defer listener.Close()
-
-		log.Printf("Remote forwarding: Listening on MASQUE network %s, forwarding to local %s:%d", localAddrPort, pm.RemoteIP, pm.RemotePort)
-
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Printf("Accept error on %s: %v", localAddrPort, err)
-				continue
-			}
 
-			go handleConnection(conn, pm, isRemote, netstackNet)
+		if pm.RemoteSocket != "" {
+			log.Printf("Remote forwarding: Listening on MASQUE network %s, forwarding to local unix:%s", localAddrPort, pm.RemoteSocket)
+		} else {
+			log.Printf("Remote forwarding: Listening on MASQUE network %s, forwarding to local %s:%d", localAddrPort, pm.RemoteIP, pm.RemotePort)
 		}
 	} else {
 		// Local forwarding: Listen on local machine
-		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", pm.BindAddress, pm.LocalPort))
+		network, address := "tcp", fmt.Sprintf("%s:%d", pm.BindAddress, pm.LocalPort)
+		if pm.BindSocket != "" {
+			network, address = "unix", pm.BindSocket
+			removeStaleSocket(pm.BindSocket)
+		}
+		var err error
+		listener, err = net.Listen(network, address)
 		if err != nil {
-			return fmt.Errorf("failed to listen on %s:%d: %w", pm.BindAddress, pm.LocalPort, err)
+			return fmt.Errorf("failed to listen on %s: %w", address, err)
 		}
-		defer listener.Close()
 
-		log.Printf("Local forwarding: Listening on %s:%d, forwarding to remote %s:%d", pm.BindAddress, pm.LocalPort, pm.RemoteIP, pm.RemotePort)
+		log.Printf("Local forwarding: Listening on %s, forwarding to remote %s:%d", listener.Addr(), pm.RemoteIP, pm.RemotePort)
+	}
+	defer listener.Close()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
 
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Printf("Accept error on %s:%d: %v", pm.BindAddress, pm.LocalPort, err)
-				continue
+	var active atomic.Int64
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("Accept error on %s: %v", listener.Addr(), err)
+			continue
+		}
 
-			go handleConnection(conn, pm, isRemote, netstackNet)
+		if !pm.AllowsSource(conn.RemoteAddr()) {
+			log.Printf("Rejected connection from %s to %s: source not allowed", conn.RemoteAddr(), listener.Addr())
+			conn.Close()
+			continue
 		}
+		if n := active.Add(1); pm.MaxConnections > 0 && n > int64(pm.MaxConnections) {
+			active.Add(-1)
+			log.Printf("Rejected connection from %s to %s: limit of %d connections reached", conn.RemoteAddr(), listener.Addr(), pm.MaxConnections)
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer active.Add(-1)
+			handleConnection(conn, pm, isRemote, netstackNet, conns)
+		}()
 	}
 }
 
+// removeStaleSocket removes a Unix socket left behind by a previous run that nothing listens on anymore.
+//
+// Parameters:
+//   - path: string - The Unix socket to check.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
 // handleConnection manages an individual forwarded connection between the local and remote endpoints.
 //
 // Parameters:
@@ -299,46 +514,243 @@ func forwardPort(netstackNet *netstack.Net, pm internal.PortMapping, isRemote bo
 //   - pm: internal.PortMapping - The port mapping configuration.
 //   - isRemote: bool - Indicates whether the connection is remote-forwarded.
 //   - tunNet: *netstack.Net - The network stack used for making remote connections.
-func handleConnection(localConn net.Conn, pm internal.PortMapping, isRemote bool, tunNet *netstack.Net) {
+//   - conns: *connCounter - Counts the forwarded connections, may be nil.
+func handleConnection(localConn net.Conn, pm internal.PortMapping, isRemote bool, tunNet *netstack.Net, conns *connCounter) {
+	defer conns.track()()
 	defer localConn.Close()
 
+	var remoteConn net.Conn
+	var err error
+	if pm.RemoteSocket != "" {
+		// Remote forwarding to a Unix socket on the local machine
+		remoteConn, err = net.Dial("unix", pm.RemoteSocket)
+		if err != nil {
+			log.Printf("Failed to connect to remote unix:%s: %v", pm.RemoteSocket, err)
+			return
+		}
+	} else {
+		remoteAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", pm.RemoteIP, pm.RemotePort))
+		if err != nil {
+			log.Printf("Invalid remote address: %v", err)
+			return
+		}
+
+		if isRemote {
+			// Remote forwarding: Connect to the external remote host
+			remoteConn, err = net.Dial("tcp", remoteAddrPort.String())
+		} else {
+			// Local forwarding: Connect inside the tunnel network
+			remoteConn, err = tunNet.DialContext(context.Background(), "tcp", remoteAddrPort.String())
+		}
+
+		if err != nil {
+			log.Printf("Failed to connect to remote %s: %v", remoteAddrPort, err)
+			return
+		}
+	}
+	defer remoteConn.Close()
+
+	go func() { io.Copy(remoteConn, localConn) }()
+	io.Copy(localConn, remoteConn)
+}
+
+// forwardDynamic serves a SOCKS5 proxy on a local address, like the -D option of SSH.
+//
+// Parameters:
+//   - ctx: context.Context - Closes the listener once done.
+//   - server: *socks5.Server - The SOCKS5 server connecting through the MASQUE tunnel.
+//   - addr: string - The local address to listen on.
+//   - conns: *connCounter - Counts the proxied connections, may be nil.
+//
+// Returns:
+//   - error: An error if listening or serving fails.
+func forwardDynamic(ctx context.Context, server *socks5.Server, addr string, conns *connCounter) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	listener = conns.trackListener(listener)
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Dynamic forwarding: SOCKS proxy listening on %s", addr)
+	if err := server.Serve(listener); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// forwardUDPPort relays UDP datagrams between a local socket and the MASQUE tunnel.
+//
+// Every client address gets its own flow with a socket connected to the remote endpoint, so replies
+// reach the client that sent the request. Flows without traffic for idleTimeout are closed.
+// Datagrams of clients not allowed by the mapping, or opening a flow beyond its limit, are dropped.
+//
+// Parameters:
+//   - ctx: context.Context - Stops the forwarding and closes all flows once done.
+//   - netstackNet: *netstack.Net - The network stack of the MASQUE tunnel.
+//   - pm: internal.PortMapping - The port mapping configuration containing bind address, local port, remote IP, and remote port.
+//   - isRemote: bool - Indicates whether the forwarding is remote (true) or local (false).
+//   - idleTimeout: time.Duration - How long a flow may stay idle before it is closed.
+//   - conns: *connCounter - Counts the active flows, may be nil.
+//
+// Returns:
+//   - error: An error if the socket can't be opened or reading from it fails; nil once ctx is done.
+func forwardUDPPort(ctx context.Context, netstackNet *netstack.Net, pm internal.PortMapping, isRemote bool, idleTimeout time.Duration, conns *connCounter) error {
+	localAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", pm.BindAddress, pm.LocalPort))
+	if err != nil {
+		return fmt.Errorf("invalid local address: %w", err)
+	}
 	remoteAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", pm.RemoteIP, pm.RemotePort))
 	if err != nil {
-		log.Printf("Invalid remote address: %v", err)
-		return
+		return fmt.Errorf("invalid remote address: %w", err)
 	}
 
-	var remoteConn net.Conn
+	var listener net.PacketConn
+	var dial func() (net.Conn, error)
 	if isRemote {
-		// Remote forwarding: Connect to the external remote host
-		remoteConn, err = net.Dial("tcp", remoteAddrPort.String())
+		// Remote forwarding: Listen inside the MASQUE tunnel, relay to the local network
+		listener, err = netstackNet.ListenUDPAddrPort(localAddrPort)
+		dial = func() (net.Conn, error) {
+			return net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(remoteAddrPort))
+		}
+		log.Printf("Remote forwarding: Listening on MASQUE network udp %s, forwarding to local %s", localAddrPort, remoteAddrPort)
 	} else {
-		// Local forwarding: Connect inside the tunnel network
-		remoteConn, err = tunNet.DialContext(context.Background(), "tcp", remoteAddrPort.String())
+		// Local forwarding: Listen on local machine, relay into the MASQUE tunnel
+		listener, err = net.ListenUDP("udp", net.UDPAddrFromAddrPort(localAddrPort))
+		dial = func() (net.Conn, error) {
+			return netstackNet.DialUDPAddrPort(netip.AddrPort{}, remoteAddrPort)
+		}
+		log.Printf("Local forwarding: Listening on udp %s, forwarding to remote %s", localAddrPort, remoteAddrPort)
 	}
-
 	if err != nil {
-		log.Printf("Failed to connect to remote %s: %v", remoteAddrPort, err)
-		return
+		return fmt.Errorf("failed to listen on udp %s: %w", localAddrPort, err)
 	}
-	defer remoteConn.Close()
+	defer listener.Close()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	var mu sync.Mutex
+	flows := make(map[string]*udpFlow)
+	defer func() {
+		mu.Lock()
+		for _, flow := range flows {
+			flow.conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := listener.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from udp %s: %w", localAddrPort, err)
+		}
+		if !pm.AllowsSource(client) {
+			continue
+		}
 
-	go func() { io.Copy(remoteConn, localConn) }()
-	io.Copy(localConn, remoteConn)
+		mu.Lock()
+		flow, ok := flows[client.String()]
+		if !ok {
+			if pm.MaxConnections > 0 && len(flows) >= pm.MaxConnections {
+				mu.Unlock()
+				continue
+			}
+			conn, err := dial()
+			if err != nil {
+				mu.Unlock()
+				log.Printf("Failed to connect to remote udp %s: %v", remoteAddrPort, err)
+				continue
+			}
+			flow = &udpFlow{conn: conn}
+			flow.touch()
+			flows[client.String()] = flow
+			done := conns.track()
+			go func() {
+				defer done()
+				flow.relay(listener, client, idleTimeout)
+				mu.Lock()
+				if flows[client.String()] == flow {
+					delete(flows, client.String())
+				}
+				mu.Unlock()
+			}()
+		} else {
+			flow.touch()
+		}
+		mu.Unlock()
+
+		if _, err := flow.conn.Write(buf[:n]); err != nil {
+			log.Printf("Failed to forward datagram to udp %s: %v", remoteAddrPort, err)
+		}
+	}
+}
+
+// udpFlow is the socket relaying the datagrams of a single client of a UDP port mapping.
+type udpFlow struct {
+	conn       net.Conn
+	lastActive atomic.Int64
+}
+
+// touch records that a datagram was relayed.
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+// relay returns the replies of the remote endpoint to the client until the flow was idle for idleTimeout.
+func (f *udpFlow) relay(listener net.PacketConn, client net.Addr, idleTimeout time.Duration) {
+	defer f.conn.Close()
+
+	buf := make([]byte, 65535)
+	for {
+		deadline := time.Unix(0, f.lastActive.Load()).Add(idleTimeout)
+		if !time.Now().Before(deadline) {
+			return
+		}
+		f.conn.SetReadDeadline(deadline)
+
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return
+		}
+		f.touch()
+		if _, err := listener.WriteTo(buf[:n], client); err != nil {
+			return
+		}
+	}
 }
 
 func init() {
-	portFwCmd.Flags().StringArrayP("local-ports", "L", []string{}, "List of port mappings to forward (SSH like e.g. localhost:8080:100.96.0.2:8080)")
-	portFwCmd.Flags().StringArrayP("remote-ports", "R", []string{}, "List of port mappings to forward (SSH like e.g. 100.96.0.3:8080:localhost:8080)")
-	portFwCmd.Flags().IntP("connect-port", "P", 443, "Used port for MASQUE connection")
+	portFwCmd.Flags().StringArrayP("local-ports", "L", []string{}, "List of port mappings to forward (SSH like e.g. localhost:8080:100.96.0.2:8080 or unix:/run/app.sock:100.96.0.2:8080, prefix with udp/ for UDP)")
+	portFwCmd.Flags().StringArrayP("remote-ports", "R", []string{}, "List of port mappings to forward (SSH like e.g. 100.96.0.3:8080:localhost:8080 or 100.96.0.3:8080:unix:/run/app.sock, prefix with udp/ for UDP)")
+	portFwCmd.Flags().String("rules", "", "YAML or JSON file with port forwarding rules, applied in addition to -L and -R")
+	portFwCmd.Flags().Duration("rules-interval", 2*time.Second, "How often to check the rules file for changes (0 = never reload)")
+	portFwCmd.Flags().StringArrayP("dynamic-ports", "D", []string{}, "List of addresses to serve a SOCKS5 proxy through the tunnel on (SSH like e.g. localhost:1080)")
+	portFwCmd.Flags().Duration("dns-timeout", 2*time.Second, "Timeout for DNS queries of the SOCKS5 proxies")
+	portFwCmd.Flags().Duration("udp-timeout", 2*time.Minute, "Close forwarded UDP flows and SOCKS5 UDP associations after this long without traffic")
 	portFwCmd.Flags().StringArrayP("dns", "d", []string{"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9"}, "DNS servers to use inside the MASQUE tunnel")
-	portFwCmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 for MASQUE connection")
 	portFwCmd.Flags().BoolP("no-tunnel-ipv4", "F", false, "Disable IPv4 inside the MASQUE tunnel")
 	portFwCmd.Flags().BoolP("no-tunnel-ipv6", "S", false, "Disable IPv6 inside the MASQUE tunnel")
 	portFwCmd.Flags().StringP("sni-address", "s", internal.ConnectSNI, "SNI address to use for MASQUE connection")
 	portFwCmd.Flags().DurationP("keepalive-period", "k", 30*time.Second, "Keepalive period for MASQUE connection")
 	portFwCmd.Flags().IntP("mtu", "m", 1280, "MTU for MASQUE connection")
 	portFwCmd.Flags().Uint16P("initial-packet-size", "i", 1242, "Initial packet size for MASQUE connection")
-	portFwCmd.Flags().DurationP("reconnect-delay", "r", 1*time.Second, "Delay between reconnect attempts")
+	addEndpointFlags(portFwCmd)
+	addReconnectFlags(portFwCmd)
+	addStatsFlags(portFwCmd)
+	addMetricsFlags(portFwCmd)
+	addControlFlags(portFwCmd)
+	addCaptureFlags(portFwCmd)
+	addTraceFlags(portFwCmd)
 	rootCmd.AddCommand(portFwCmd)
 }
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"github.com/Diniboy1123/usque/config"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage the profiles of the config file",
+	Long: "A config file can hold multiple named profiles, e.g. a consumer account and several Zero Trust registrations." +
+		" Every command uses the profile selected with --profile, or the default one." +
+		" New profiles are created with register --profile <name> or copied from another config file with profile add.",
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+
+		store, err := config.LoadStore(configPath)
+		if err != nil {
+			cmd.Printf("Failed to load profiles: %v\n", err)
+			return
+		}
+
+		for _, name := range store.Names() {
+			profile := store.Profiles[name]
+			marker := " "
+			if name == store.Default {
+				marker = "*"
+			}
+			encrypted := ""
+			if profile.Encrypted != nil {
+				encrypted = "(encrypted)"
+			}
+			cmd.Printf("%s %-16s %-15s %-39s %-11s %s\n", marker, name, profile.IPv4, profile.IPv6, encrypted, profile.SNI)
+		}
+	},
+}
+
+var profileAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a profile copied from another config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+		from, err := cmd.Flags().GetString("from")
+		if err != nil {
+			cmd.Printf("Failed to get source config: %v\n", err)
+			return
+		}
+		fromProfile, err := cmd.Flags().GetString("from-profile")
+		if err != nil {
+			cmd.Printf("Failed to get source profile: %v\n", err)
+			return
+		}
+		sni, err := cmd.Flags().GetString("sni")
+		if err != nil {
+			cmd.Printf("Failed to get SNI: %v\n", err)
+			return
+		}
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			cmd.Printf("Failed to get force flag: %v\n", err)
+			return
+		}
+
+		source, err := config.LoadStore(from)
+		if err != nil {
+			cmd.Printf("Failed to load source config: %v\n", err)
+			return
+		}
+		profile, err := source.Get(fromProfile)
+		if err != nil {
+			cmd.Printf("Failed to load source config: %v\n", err)
+			return
+		}
+		if sni != "" {
+			profile.SNI = sni
+		}
+
+		store, err := config.LoadOrNewStore(configPath)
+		if err != nil {
+			cmd.Printf("Failed to load profiles: %v\n", err)
+			return
+		}
+		if _, exists := store.Profiles[args[0]]; exists && !force {
+			cmd.Printf("Profile %s already exists, use --force to replace it\n", args[0])
+			return
+		}
+
+		if profile.Encrypted != nil {
+			// copied as is, it stays encrypted with the passphrase of the source
+			store.Set(args[0], profile)
+			err = store.Save(configPath)
+		} else {
+			var passphrase config.Passphrase
+			passphrase, err = savePassphrase(cmd, configPath, args[0])
+			if err == nil {
+				err = profile.SaveConfig(configPath, args[0], passphrase)
+			}
+		}
+		if err != nil {
+			cmd.Printf("Failed to save profiles: %v\n", err)
+			return
+		}
+		cmd.Printf("Added profile %s to %s\n", args[0], configPath)
+	},
+}
+
+var profileRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+
+		store, err := config.LoadStore(configPath)
+		if err != nil {
+			cmd.Printf("Failed to load profiles: %v\n", err)
+			return
+		}
+		if err := store.Remove(args[0]); err != nil {
+			cmd.Printf("Failed to remove profile: %v\n", err)
+			return
+		}
+		if err := store.Save(configPath); err != nil {
+			cmd.Printf("Failed to save profiles: %v\n", err)
+			return
+		}
+		cmd.Printf("Removed profile %s\n", args[0])
+	},
+}
+
+var profileDefaultCmd = &cobra.Command{
+	Use:   "default <name>",
+	Short: "Select the profile used when none is given",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+
+		store, err := config.LoadStore(configPath)
+		if err != nil {
+			cmd.Printf("Failed to load profiles: %v\n", err)
+			return
+		}
+		if err := store.SetDefault(args[0]); err != nil {
+			cmd.Printf("Failed to select default profile: %v\n", err)
+			return
+		}
+		if err := store.Save(configPath); err != nil {
+			cmd.Printf("Failed to save profiles: %v\n", err)
+			return
+		}
+		cmd.Printf("Default profile is now %s\n", args[0])
+	},
+}
+
+var profileEncryptCmd = &cobra.Command{
+	Use:   "encrypt <name>",
+	Short: "Encrypt the private key, access token and license of a profile",
+	Long: "Encrypts the sensitive fields of a profile with a passphrase taken from $USQUE_PASSPHRASE," +
+		" the file given with --passphrase-file or a prompt. Every command using the profile then needs the passphrase.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+
+		store, err := config.LoadStore(configPath)
+		if err != nil {
+			cmd.Printf("Failed to load profiles: %v\n", err)
+			return
+		}
+		profile, err := store.Get(args[0])
+		if err != nil {
+			cmd.Printf("Failed to load profile: %v\n", err)
+			return
+		}
+		if profile.Encrypted != nil {
+			cmd.Printf("Profile %s is already encrypted\n", args[0])
+			return
+		}
+
+		if err := profile.SaveConfig(configPath, args[0], configPassphrase(cmd, true)); err != nil {
+			cmd.Printf("Failed to encrypt profile: %v\n", err)
+			return
+		}
+		cmd.Printf("Encrypted profile %s\n", args[0])
+	},
+}
+
+var profileDecryptCmd = &cobra.Command{
+	Use:   "decrypt <name>",
+	Short: "Store the private key, access token and license of a profile in plaintext again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			cmd.Printf("Failed to get config path: %v\n", err)
+			return
+		}
+
+		profile, err := config.LoadConfig(configPath, args[0], configPassphrase(cmd, false))
+		if err != nil {
+			cmd.Printf("Failed to load profile: %v\n", err)
+			return
+		}
+		if err := profile.SaveConfig(configPath, args[0], nil); err != nil {
+			cmd.Printf("Failed to save profile: %v\n", err)
+			return
+		}
+		cmd.Printf("Decrypted profile %s\n", args[0])
+	},
+}
+
+func init() {
+	profileAddCmd.Flags().String("from", "", "Config file to copy the profile from")
+	profileAddCmd.Flags().String("from-profile", "", "Profile of the source config file (default is its default profile)")
+	profileAddCmd.Flags().String("sni", "", "Preferred SNI for the MASQUE connection of this profile")
+	profileAddCmd.Flags().BoolP("force", "f", false, "Replace the profile if it already exists")
+	profileAddCmd.MarkFlagRequired("from")
+	profileCmd.AddCommand(profileListCmd, profileAddCmd, profileRemoveCmd, profileDefaultCmd, profileEncryptCmd, profileDecryptCmd)
+	rootCmd.AddCommand(profileCmd)
+}
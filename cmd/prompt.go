@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// addYesFlag registers the flag answering all questions of a command with yes.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flag on.
+func addYesFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolP("yes", "y", false, "Answer yes to all questions, e.g. for provisioning scripts")
+}
+
+// confirm asks a yes/no question on stdin unless it was already answered with yes by a flag.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - question: string - The question, without the (y/n) suffix.
+//   - flag: string - The flag answering this question, checked in addition to the one registered by addYesFlag.
+//
+// Returns:
+//   - bool: True if the answer is yes.
+//   - error: An error if a flag can't be read or no answer can be read, e.g. because stdin is closed.
+func confirm(cmd *cobra.Command, question, flag string) (bool, error) {
+	for _, name := range []string{flag, "yes"} {
+		if cmd.Flags().Lookup(name) == nil {
+			continue
+		}
+		yes, err := cmd.Flags().GetBool(name)
+		if err != nil {
+			return false, fmt.Errorf("failed to get %s flag: %v", name, err)
+		}
+		if yes {
+			return true, nil
+		}
+	}
+
+	fmt.Printf("%s (y/n) ", question)
+	var response string
+	if _, err := fmt.Scanln(&response); err != nil {
+		return false, fmt.Errorf("failed to read response, pass --%s to answer non-interactively: %v", flag, err)
+	}
+	return response == "y", nil
+}
@@ -2,10 +2,8 @@ package cmd
 
 import (
 	"encoding/base64"
-	"fmt"
 	"log"
 
-	"github.com/Diniboy1123/usque/api"
 	"github.com/Diniboy1123/usque/config"
 	"github.com/Diniboy1123/usque/internal"
 	"github.com/spf13/cobra"
@@ -17,17 +15,6 @@ var registerCmd = &cobra.Command{
 	Long: "Registers a new account and enrolls a device key. Also makes sure that it switches to" +
 		" MASQUE mode. Saves the config to a file.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if config.ConfigLoaded {
-			fmt.Printf("You already have a config. Do you want to overwrite it? (y/n) ")
-			var response string
-			if _, err := fmt.Scanln(&response); err != nil {
-				log.Fatalf("Failed to read response: %v", err)
-			}
-			if response != "y" {
-				return
-			}
-		}
-
 		configPath, err := cmd.Flags().GetString("config")
 		if err != nil {
 			log.Fatalf("Failed to get config path: %v", err)
@@ -36,6 +23,24 @@ var registerCmd = &cobra.Command{
 			log.Fatalf("Config path is required")
 		}
 
+		profile, err := cmd.Flags().GetString("profile")
+		if err != nil {
+			log.Fatalf("Failed to get profile: %v", err)
+		}
+
+		// the profile is only looked up, an encrypted one doesn't need to be decrypted
+		if store, err := config.LoadStore(configPath); err == nil {
+			if _, err := store.Get(profile); err == nil {
+				overwrite, err := confirm(cmd, "You already have a config. Do you want to overwrite it?", "force")
+				if err != nil {
+					log.Fatalf("Failed to confirm overwrite: %v", err)
+				}
+				if !overwrite {
+					return
+				}
+			}
+		}
+
 		deviceName, err := cmd.Flags().GetString("name")
 		if err != nil {
 			log.Fatalf("Failed to get device name: %v", err)
@@ -62,12 +67,17 @@ var registerCmd = &cobra.Command{
 			log.Printf("Registering with locale %s and model %s", locale, model)
 		}
 
-		acceptTos, err := cmd.Flags().GetBool("accept-tos")
+		acceptTos, err := confirm(cmd, "You must accept the Terms of Service (https://www.cloudflare.com/application/terms/) to register. Do you agree?", "accept-tos")
 		if err != nil {
-			log.Fatalf("Failed to get accept-tos flag: %v", err)
+			log.Fatalf("Failed to confirm Terms of Service: %v", err)
 		}
 
-		accountData, err := api.Register(model, locale, jwt, acceptTos)
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			log.Fatalf("Failed to configure API client: %v", err)
+		}
+
+		accountData, err := client.Register(model, locale, jwt, acceptTos)
 		if err != nil {
 			log.Fatalf("Failed to register: %v", err)
 		}
@@ -79,7 +89,7 @@ var registerCmd = &cobra.Command{
 
 		log.Printf("Enrolling device key...")
 
-		updatedAccountData, apiErr, err := api.EnrollKey(accountData, pubKey, deviceName)
+		updatedAccountData, apiErr, err := client.EnrollKey(accountData, pubKey, deviceName)
 		if err != nil {
 			if apiErr != nil {
 				log.Fatalf("Failed to enroll key: %v (API errors: %s)", err, apiErr.ErrorsAsString("; "))
@@ -90,22 +100,31 @@ var registerCmd = &cobra.Command{
 
 		log.Printf("Successful registration. Saving config...")
 
-		config.AppConfig = config.Config{
-			PrivateKey: base64.StdEncoding.EncodeToString(privKey),
-			// TODO: proper endpoint parsing in utils
-			// strip :0
-			EndpointV4: updatedAccountData.Config.Peers[0].Endpoint.V4[:len(updatedAccountData.Config.Peers[0].Endpoint.V4)-2],
-			// strip [ from beginning and ]:0 from end
-			EndpointV6:     updatedAccountData.Config.Peers[0].Endpoint.V6[1 : len(updatedAccountData.Config.Peers[0].Endpoint.V6)-3],
-			EndpointPubKey: updatedAccountData.Config.Peers[0].PublicKey,
-			License:        updatedAccountData.Account.License,
-			ID:             updatedAccountData.ID,
-			AccessToken:    accountData.Token,
-			IPv4:           updatedAccountData.Config.Interface.Addresses.V4,
-			IPv6:           updatedAccountData.Config.Interface.Addresses.V6,
+		cfg := config.Config{
+			PrivateKey:  base64.StdEncoding.EncodeToString(privKey),
+			License:     updatedAccountData.Account.License,
+			ID:          updatedAccountData.ID,
+			AccessToken: accountData.Token,
+			IPv4:        updatedAccountData.Config.Interface.Addresses.V4,
+			IPv6:        updatedAccountData.Config.Interface.Addresses.V6,
+		}
+
+		skipped, err := cfg.SetPeers(updatedAccountData.Config.Peers)
+		if err != nil {
+			log.Fatalf("Failed to parse endpoints: %v", err)
+		}
+		for _, err := range skipped {
+			log.Printf("Warning: skipping %v", err)
+		}
+
+		passphrase, err := savePassphrase(cmd, configPath, profile)
+		if err != nil {
+			log.Fatalf("Failed to get passphrase: %v", err)
 		}
 
-		config.AppConfig.SaveConfig(configPath)
+		if err := cfg.SaveConfig(configPath, profile, passphrase); err != nil {
+			log.Fatalf("Failed to save config: %v", err)
+		}
 
 		log.Printf("Config saved to %s", configPath)
 	},
@@ -117,5 +136,8 @@ func init() {
 	registerCmd.Flags().StringP("name", "n", "", "device name")
 	registerCmd.Flags().String("jwt", "", "team token")
 	registerCmd.Flags().BoolP("accept-tos", "a", false, "accept Cloudflare TOS (not interactive setup)")
+	registerCmd.Flags().BoolP("force", "f", false, "Overwrite an existing config without asking")
+	addYesFlag(registerCmd)
+	addAPIFlags(registerCmd)
 	rootCmd.AddCommand(registerCmd)
 }
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/Diniboy1123/usque/config"
@@ -12,16 +13,8 @@ var rootCmd = &cobra.Command{
 	Short: "Usque Warp CLI",
 	Long:  "An unofficial Cloudflare Warp CLI that uses the MASQUE protocol and exposes the tunnel as various different services.",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		configPath, err := cmd.Flags().GetString("config")
-		if err != nil {
-			log.Fatalf("Failed to get config path: %v", err)
-		}
-
-		if configPath != "" {
-			if err := config.LoadConfig(configPath); err != nil {
-				log.Printf("Config file not found: %v", err)
-				log.Printf("You may only use the register command to generate one.")
-			}
+		if err := applyRuntimeSettings(cmd); err != nil {
+			log.Fatalf("Invalid runtime settings: %v", err)
 		}
 	},
 }
@@ -30,6 +23,46 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+// loadConfig loads the profile selected by the --config and --profile flags. The SNI preference
+// of the profile becomes the value of --sni-address if the command has it and it isn't set otherwise.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//
+// Returns:
+//   - *config.Config: The loaded configuration.
+//   - error: An error if the flags can't be read or the profile can't be loaded.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config path: %v", err)
+	}
+	if configPath == "" {
+		return nil, fmt.Errorf("config path is required")
+	}
+	profile, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %v", err)
+	}
+
+	cfg, err := config.LoadConfig(configPath, profile, configPassphrase(cmd, false))
+	if err != nil {
+		return nil, err
+	}
+
+	if flag := cmd.Flags().Lookup("sni-address"); flag != nil && !flag.Changed && cfg.SNI != "" {
+		if err := cmd.Flags().Set("sni-address", cfg.SNI); err != nil {
+			return nil, fmt.Errorf("invalid SNI of profile: %v", err)
+		}
+	}
+
+	return cfg, nil
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringP("config", "c", "config.json", "config file (default is config.json)")
+	rootCmd.PersistentFlags().String("profile", "", "Profile of the config file to use (default is the file's default profile)")
+	rootCmd.PersistentFlags().String("passphrase-file", "", "File holding the passphrase of encrypted configs (default is $USQUE_PASSPHRASE or a prompt)")
+	rootCmd.PersistentFlags().Bool("encrypt", false, "Encrypt the private key, access token and license when saving the config")
+	rootCmd.PersistentFlags().String("runtime-config", "", "YAML file with settings for the commands, used for flags not given on the command line or as USQUE_* environment variables")
 }
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Diniboy1123/usque/internal"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// forwardRules runs the port mappings of a rules file and applies changes of the file while running.
+// Mappings that didn't change keep their listeners and connections.
+type forwardRules struct {
+	path       string
+	tunNet     *netstack.Net
+	udpTimeout time.Duration
+	conns      *connCounter
+
+	mu      sync.Mutex
+	running map[string]*runningForward
+}
+
+// runningForward is a port mapping started by forwardRules.
+type runningForward struct {
+	run    func(context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ruleKey identifies a port mapping, equal keys mean nothing changed.
+func ruleKey(pm internal.PortMapping, isRemote bool) string {
+	key, _ := json.Marshal(struct {
+		internal.PortMapping
+		IsRemote bool
+	}{pm, isRemote})
+	return string(key)
+}
+
+// apply starts the given mappings and stops running ones that are no longer present.
+//
+// Parameters:
+//   - ctx: context.Context - Stops all mappings once done.
+//   - local: []internal.PortMapping - The mappings forwarding into the tunnel.
+//   - remote: []internal.PortMapping - The mappings forwarding out of the tunnel.
+func (r *forwardRules) apply(ctx context.Context, local, remote []internal.PortMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]func(context.Context))
+	for _, mappings := range []struct {
+		pms      []internal.PortMapping
+		isRemote bool
+	}{{local, false}, {remote, true}} {
+		for _, pm := range mappings.pms {
+			isRemote := mappings.isRemote
+			wanted[ruleKey(pm, isRemote)] = func(ctx context.Context) {
+				runForward(ctx, r.tunNet, pm, isRemote, r.udpTimeout, r.conns)
+			}
+		}
+	}
+
+	if r.running == nil {
+		r.running = make(map[string]*runningForward)
+	}
+
+	// stop removed mappings first, so changed ones can bind the same address again
+	var stopped int
+	for key, forward := range r.running {
+		select {
+		case <-forward.done:
+			// failed before, e.g. the address was in use, so try again
+			delete(r.running, key)
+			continue
+		default:
+		}
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		forward.cancel()
+		<-forward.done
+		delete(r.running, key)
+		stopped++
+	}
+
+	var started int
+	for key, run := range wanted {
+		if _, ok := r.running[key]; ok {
+			continue
+		}
+		r.start(ctx, key, run)
+		started++
+	}
+
+	log.Printf("Applied rules from %s: %d started, %d stopped, %d unchanged", r.path, started, stopped, len(r.running)-started)
+}
+
+// retryFailed starts the mappings again that stopped on their own, e.g. because their address was in use.
+//
+// Parameters:
+//   - ctx: context.Context - Stops the restarted mappings once done.
+func (r *forwardRules) retryFailed(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, forward := range r.running {
+		select {
+		case <-forward.done:
+			forward.cancel()
+			r.start(ctx, key, forward.run)
+		default:
+		}
+	}
+}
+
+// start runs a port mapping in the background until ctx is done or it fails. r.mu must be held.
+func (r *forwardRules) start(ctx context.Context, key string, run func(context.Context)) {
+	forwardCtx, cancel := context.WithCancel(ctx)
+	forward := &runningForward{run: run, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(forward.done)
+		run(forwardCtx)
+	}()
+	r.running[key] = forward
+}
+
+// watch reloads the rules file whenever its content changes, until ctx is done.
+// Invalid files are reported and the running mappings are kept. Mappings that failed,
+// e.g. because their address was in use, are retried on every check.
+//
+// Parameters:
+//   - ctx: context.Context - Stops watching once done.
+//   - interval: time.Duration - How often to check the file for changes and retry failed mappings.
+func (r *forwardRules) watch(ctx context.Context, interval time.Duration) {
+	last, _ := os.ReadFile(r.path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(r.path)
+		if err == nil && !bytes.Equal(data, last) {
+			last = data
+
+			local, remote, err := internal.ParseForwardRules(data)
+			if err == nil {
+				r.apply(ctx, local, remote)
+				continue
+			}
+			log.Printf("Failed to reload rules, keeping the current ones: %v", err)
+		}
+
+		r.retryFailed(ctx)
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Diniboy1123/usque/internal"
+)
+
+func TestForwardRulesRetryFailed(t *testing.T) {
+	// keep the port busy, so the rule fails to start
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := busy.Addr().String()
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	data := []byte(fmt.Sprintf("rules:\n  - bind: %s\n    remote: 100.96.0.2:80\n", addr))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+	local, remote, err := internal.ParseForwardRules(data)
+	if err != nil {
+		t.Fatalf("ParseForwardRules failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rules := &forwardRules{path: path}
+	rules.apply(ctx, local, remote)
+
+	rules.mu.Lock()
+	for _, forward := range rules.running {
+		select {
+		case <-forward.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("rule didn't fail on a busy address")
+		}
+	}
+	rules.mu.Unlock()
+
+	// the file doesn't change, the rule must be retried anyway once the port is free
+	go rules.watch(ctx, 20*time.Millisecond)
+	busy.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			break // taken by the retried rule
+		}
+		ln.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("failed rule wasn't retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/Diniboy1123/usque/config"
+	"github.com/Diniboy1123/usque/internal"
+	"github.com/spf13/cobra"
+)
+
+// scanResultJSON is a scan result as printed by scan --json.
+type scanResultJSON struct {
+	Endpoint   string  `json:"endpoint"`
+	OK         bool    `json:"ok"`
+	Reachable  bool    `json:"reachable"`
+	LatencyMs  float64 `json:"latency_ms"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [target...]",
+	Short: "Find reachable MASQUE endpoints and ports",
+	Long: "Probes endpoints with a real QUIC and CONNECT-IP handshake and ranks them by handshake latency." +
+		" Targets are addresses or CIDR prefixes, optionally with a port (e.g. 162.159.198.0/24 or [2606:4700:103::1]:4500)." +
+		" Without targets, the endpoints from the config are probed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
+			return
+		}
+
+		sni, err := cmd.Flags().GetString("sni-address")
+		if err != nil {
+			cmd.Printf("Failed to get SNI address: %v\n", err)
+			return
+		}
+
+		privKey, err := cfg.GetEcPrivateKey()
+		if err != nil {
+			cmd.Printf("Failed to get private key: %v\n", err)
+			return
+		}
+		peerPubKey, err := cfg.GetEcEndpointPublicKey()
+		if err != nil {
+			cmd.Printf("Failed to get public key: %v\n", err)
+			return
+		}
+
+		cert, err := internal.GenerateCert(privKey, &privKey.PublicKey)
+		if err != nil {
+			cmd.Printf("Failed to generate cert: %v\n", err)
+			return
+		}
+
+		keyLog, quicTracer, err := getTraceSettings(cmd)
+		if err != nil {
+			cmd.Printf("Invalid trace settings: %v\n", err)
+			return
+		}
+
+		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, keyLog)
+		if err != nil {
+			cmd.Printf("Failed to prepare TLS config: %v\n", err)
+			return
+		}
+
+		initialPacketSize, err := cmd.Flags().GetUint16("initial-packet-size")
+		if err != nil {
+			cmd.Printf("Failed to get initial packet size: %v\n", err)
+			return
+		}
+
+		ports, err := cmd.Flags().GetIntSlice("ports")
+		if err != nil {
+			cmd.Printf("Failed to get ports: %v\n", err)
+			return
+		}
+
+		targetsFile, err := cmd.Flags().GetString("file")
+		if err != nil {
+			cmd.Printf("Failed to get targets file: %v\n", err)
+			return
+		}
+
+		concurrency, err := cmd.Flags().GetInt("concurrency")
+		if err != nil {
+			cmd.Printf("Failed to get concurrency: %v\n", err)
+			return
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			cmd.Printf("Failed to get timeout: %v\n", err)
+			return
+		}
+
+		maxEndpoints, err := cmd.Flags().GetInt("max-endpoints")
+		if err != nil {
+			cmd.Printf("Failed to get max endpoints: %v\n", err)
+			return
+		}
+
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			cmd.Printf("Failed to get json flag: %v\n", err)
+			return
+		}
+
+		save, err := cmd.Flags().GetBool("save")
+		if err != nil {
+			cmd.Printf("Failed to get save flag: %v\n", err)
+			return
+		}
+
+		targets := args
+		if targetsFile != "" {
+			fileTargets, err := readScanTargets(targetsFile)
+			if err != nil {
+				cmd.Printf("Failed to read targets: %v\n", err)
+				return
+			}
+			targets = append(targets, fileTargets...)
+		}
+		if len(targets) == 0 {
+			for _, endpoint := range []string{cfg.EndpointV4, cfg.EndpointV6} {
+				if endpoint != "" {
+					targets = append(targets, endpoint)
+				}
+			}
+		}
+
+		endpoints, err := internal.ExpandScanTargets(targets, ports, maxEndpoints)
+		if err != nil {
+			cmd.Printf("Invalid targets: %v\n", err)
+			return
+		}
+		if len(endpoints) == 0 {
+			cmd.Println("Nothing to scan")
+			return
+		}
+
+		ctx, cancel := newShutdownContext()
+		defer cancel()
+
+		quicConfig := internal.DefaultQuicConfig(30*time.Second, initialPacketSize)
+		quicConfig.Tracer = quicTracer
+
+		log.Printf("Scanning %d endpoints", len(endpoints))
+		results := api.ScanEndpoints(
+			ctx,
+			tlsConfig,
+			quicConfig,
+			internal.ConnectURI,
+			endpoints,
+			concurrency,
+			timeout,
+			func(result api.ScanResult) {
+				if !asJSON && result.OK {
+					log.Printf("%s works (%s)", result.Endpoint, result.Latency.Round(time.Millisecond))
+				}
+			},
+		)
+
+		if asJSON {
+			out := make([]scanResultJSON, 0, len(results))
+			for _, result := range results {
+				entry := scanResultJSON{
+					Endpoint:   result.Endpoint.String(),
+					OK:         result.OK,
+					Reachable:  result.Reachable,
+					LatencyMs:  float64(result.Latency.Microseconds()) / 1000,
+					StatusCode: result.StatusCode,
+				}
+				if result.Err != nil {
+					entry.Error = result.Err.Error()
+				}
+				out = append(out, entry)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(out); err != nil {
+				cmd.Printf("Failed to encode results: %v\n", err)
+				return
+			}
+		} else {
+			printScanResults(results)
+		}
+
+		if save {
+			if err := saveScanResults(cmd, cfg, results); err != nil {
+				cmd.Printf("Failed to save config: %v\n", err)
+				return
+			}
+		}
+	},
+}
+
+// readScanTargets reads scan targets from a file, one per line. Empty lines and lines starting with # are skipped.
+//
+// Parameters:
+//   - path: string - The file to read.
+//
+// Returns:
+//   - []string: The targets.
+//   - error: An error if the file can't be read.
+func readScanTargets(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open targets file: %v", err)
+	}
+	defer file.Close()
+
+	var targets []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read targets file: %v", err)
+	}
+
+	return targets, nil
+}
+
+// printScanResults prints the ranked scan results as a table, followed by the flags
+// to use the working endpoints with the tunnel commands.
+//
+// Parameters:
+//   - results: []api.ScanResult - The ranked results.
+func printScanResults(results []api.ScanResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tENDPOINT\tRESULT\tLATENCY\tDETAILS")
+
+	var working []string
+	for i, result := range results {
+		state, details := "ok", ""
+		switch {
+		case result.OK:
+			working = append(working, "--endpoint "+result.Endpoint.String())
+		case result.Reachable:
+			state, details = "rejected", result.Err.Error()
+		default:
+			state, details = "failed", result.Err.Error()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, result.Endpoint, state, result.Latency.Round(time.Millisecond), details)
+	}
+	w.Flush()
+
+	if len(working) == 0 {
+		fmt.Println("\nNo working endpoint found")
+		return
+	}
+	fmt.Printf("\n%d working endpoints, use them with: %s\n", len(working), strings.Join(working, " "))
+}
+
+// saveScanResults stores the fastest working IPv4 and IPv6 endpoints in the config.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the config path from.
+//   - cfg: *config.Config - The loaded configuration to update.
+//   - results: []api.ScanResult - The ranked results.
+//
+// Returns:
+//   - error: An error if no endpoint works or the config can't be saved.
+func saveScanResults(cmd *cobra.Command, cfg *config.Config, results []api.ScanResult) error {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %v", err)
+	}
+	profile, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %v", err)
+	}
+
+	var v4, v6 *net.UDPAddr
+	for _, result := range results {
+		if !result.OK {
+			continue
+		}
+		if result.Endpoint.IP.To4() != nil {
+			if v4 == nil {
+				v4 = result.Endpoint
+			}
+		} else if v6 == nil {
+			v6 = result.Endpoint
+		}
+	}
+	if v4 == nil && v6 == nil {
+		return fmt.Errorf("no working endpoint to save")
+	}
+
+	for _, endpoint := range []*net.UDPAddr{v4, v6} {
+		if endpoint == nil {
+			continue
+		}
+		if endpoint.IP.To4() != nil {
+			cfg.EndpointV4 = endpoint.IP.String()
+		} else {
+			cfg.EndpointV6 = endpoint.IP.String()
+		}
+		if endpoint.Port != 443 {
+			// the config only holds addresses
+			log.Printf("Note: %s works on port %d, connect with -P %d", endpoint.IP, endpoint.Port, endpoint.Port)
+		}
+	}
+	passphrase, err := savePassphrase(cmd, configPath, profile)
+	if err != nil {
+		return err
+	}
+	if err := cfg.SaveConfig(configPath, profile, passphrase); err != nil {
+		return err
+	}
+
+	log.Printf("Saved endpoints to config: IPv4 %s, IPv6 %s", cfg.EndpointV4, cfg.EndpointV6)
+	return nil
+}
+
+func init() {
+	scanCmd.Flags().IntSliceP("ports", "P", internal.DefaultScanPorts, "Ports to probe targets without a port on")
+	scanCmd.Flags().StringP("file", "f", "", "File with one target per line")
+	scanCmd.Flags().StringP("sni-address", "s", internal.ConnectSNI, "SNI address to use for MASQUE connection")
+	scanCmd.Flags().Uint16P("initial-packet-size", "i", 1242, "Initial packet size for MASQUE connection")
+	scanCmd.Flags().IntP("concurrency", "C", 16, "Number of endpoints to probe at the same time")
+	scanCmd.Flags().DurationP("timeout", "t", 5*time.Second, "Timeout for probing a single endpoint")
+	scanCmd.Flags().Int("max-endpoints", 4096, "Refuse to scan more endpoints than this")
+	scanCmd.Flags().BoolP("json", "j", false, "Print the results as JSON")
+	scanCmd.Flags().Bool("save", false, "Store the fastest working IPv4 and IPv6 endpoints in the config")
+	addTraceFlags(scanCmd)
+	rootCmd.AddCommand(scanCmd)
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Diniboy1123/usque/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of the environment variables setting flags, e.g. USQUE_SNI_ADDRESS for --sni-address.
+const envPrefix = "USQUE_"
+
+// applyRuntimeSettings fills the flags not given on the command line from the environment and the runtime config file.
+// The precedence is flags > environment variables > the command's section of the file > its common section > defaults.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command about to run.
+//
+// Returns:
+//   - error: An error if a setting is invalid or the runtime config file can't be loaded.
+func applyRuntimeSettings(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnvName(flag.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if isListFlag(flag) {
+			values = strings.Split(value, ",")
+		}
+		if setErr := setFlag(cmd, flag, values); setErr != nil {
+			err = fmt.Errorf("invalid %s: %v", flagEnvName(flag.Name), setErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	path, err := cmd.Flags().GetString("runtime-config")
+	if err != nil || path == "" {
+		return nil
+	}
+	runtime, err := config.LoadRuntimeConfig(path)
+	if err != nil {
+		return err
+	}
+
+	// the command's own section goes first, flags set from it are skipped by the common section
+	section := commandSection(cmd)
+	for _, name := range []string{section, config.CommonSection} {
+		for key, value := range runtime[name] {
+			flag := cmd.Flags().Lookup(key)
+			if flag == nil {
+				if name == config.CommonSection {
+					// common settings only apply to the commands having the flag
+					continue
+				}
+				return fmt.Errorf("unknown setting %q in section %s", key, name)
+			}
+			if flag.Changed || value == nil {
+				continue
+			}
+
+			values, err := settingValues(value)
+			if err != nil {
+				return fmt.Errorf("invalid setting %s.%s: %v", name, key, err)
+			}
+			if len(values) > 1 && !isListFlag(flag) {
+				return fmt.Errorf("invalid setting %s.%s: expected a single value", name, key)
+			}
+			if err := setFlag(cmd, flag, values); err != nil {
+				return fmt.Errorf("invalid setting %s.%s: %v", name, key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagEnvName returns the environment variable setting a flag.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// commandSection returns the runtime config section of a command, the name of its top-level command.
+func commandSection(cmd *cobra.Command) string {
+	for cmd.HasParent() && cmd.Parent().HasParent() {
+		cmd = cmd.Parent()
+	}
+	return cmd.Name()
+}
+
+// isListFlag checks whether a flag takes multiple values, like --dns or --connect-port.
+func isListFlag(flag *pflag.Flag) bool {
+	kind := flag.Value.Type()
+	return strings.HasSuffix(kind, "Slice") || strings.HasSuffix(kind, "Array")
+}
+
+// setFlag sets a flag to the given values, replacing its default.
+func setFlag(cmd *cobra.Command, flag *pflag.Flag, values []string) error {
+	for _, value := range values {
+		if err := cmd.Flags().Set(flag.Name, strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settingValues converts a value of the runtime config file to flag values.
+func settingValues(value any) ([]string, error) {
+	switch value := value.(type) {
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			switch item.(type) {
+			case []any, map[string]any, nil:
+				return nil, fmt.Errorf("lists may only contain plain values")
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]any:
+		return nil, fmt.Errorf("expected a value or a list of values")
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
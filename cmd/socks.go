@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/Diniboy1123/usque/api"
-	"github.com/Diniboy1123/usque/config"
 	"github.com/Diniboy1123/usque/internal"
 	"github.com/spf13/cobra"
 	"github.com/things-go/go-socks5"
@@ -19,10 +18,11 @@ import (
 var socksCmd = &cobra.Command{
 	Use:   "socks",
 	Short: "Expose Warp as a SOCKS5 proxy",
-	Long:  "Dual-stack SOCKS5 proxy with optional authentication. Doesn't require elevated privileges.",
+	Long:  "Dual-stack SOCKS5 proxy with optional authentication and UDP support. Doesn't require elevated privileges.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !config.ConfigLoaded {
-			cmd.Println("Config not loaded. Please register first.")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			cmd.Printf("Config not loaded, please register first: %v\n", err)
 			return
 		}
 
@@ -32,12 +32,12 @@ var socksCmd = &cobra.Command{
 			return
 		}
 
-		privKey, err := config.AppConfig.GetEcPrivateKey()
+		privKey, err := cfg.GetEcPrivateKey()
 		if err != nil {
 			cmd.Printf("Failed to get private key: %v\n", err)
 			return
 		}
-		peerPubKey, err := config.AppConfig.GetEcEndpointPublicKey()
+		peerPubKey, err := cfg.GetEcEndpointPublicKey()
 		if err != nil {
 			cmd.Printf("Failed to get public key: %v\n", err)
 			return
@@ -49,7 +49,13 @@ var socksCmd = &cobra.Command{
 			return
 		}
 
-		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni)
+		keyLog, quicTracer, err := getTraceSettings(cmd)
+		if err != nil {
+			cmd.Printf("Invalid trace settings: %v\n", err)
+			return
+		}
+
+		tlsConfig, err := api.PrepareTlsConfig(privKey, peerPubKey, cert, sni, keyLog)
 		if err != nil {
 			cmd.Printf("Failed to prepare TLS config: %v\n", err)
 			return
@@ -78,25 +84,12 @@ var socksCmd = &cobra.Command{
 			return
 		}
 
-		connectPort, err := cmd.Flags().GetInt("connect-port")
+		endpoints, attemptDelay, err := getEndpoints(cmd, cfg)
 		if err != nil {
-			cmd.Printf("Failed to get connect port: %v\n", err)
+			cmd.Printf("Invalid endpoint settings: %v\n", err)
 			return
 		}
 
-		var endpoint *net.UDPAddr
-		if ipv6, err := cmd.Flags().GetBool("ipv6"); err == nil && !ipv6 {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV4),
-				Port: connectPort,
-			}
-		} else {
-			endpoint = &net.UDPAddr{
-				IP:   net.ParseIP(config.AppConfig.EndpointV6),
-				Port: connectPort,
-			}
-		}
-
 		tunnelIPv4, err := cmd.Flags().GetBool("no-tunnel-ipv4")
 		if err != nil {
 			cmd.Printf("Failed to get no tunnel IPv4: %v\n", err)
@@ -111,7 +104,7 @@ var socksCmd = &cobra.Command{
 
 		var localAddresses []netip.Addr
 		if !tunnelIPv4 {
-			v4, err := netip.ParseAddr(config.AppConfig.IPv4)
+			v4, err := netip.ParseAddr(cfg.IPv4)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv4 address: %v\n", err)
 				return
@@ -119,7 +112,7 @@ var socksCmd = &cobra.Command{
 			localAddresses = append(localAddresses, v4)
 		}
 		if !tunnelIPv6 {
-			v6, err := netip.ParseAddr(config.AppConfig.IPv6)
+			v6, err := netip.ParseAddr(cfg.IPv6)
 			if err != nil {
 				cmd.Printf("Failed to parse IPv6 address: %v\n", err)
 				return
@@ -173,9 +166,39 @@ var socksCmd = &cobra.Command{
 			password = p
 		}
 
-		reconnectDelay, err := cmd.Flags().GetDuration("reconnect-delay")
+		udpTimeout, err := cmd.Flags().GetDuration("udp-timeout")
+		if err != nil {
+			cmd.Printf("Failed to get UDP timeout: %v\n", err)
+			return
+		}
+
+		reconnectPolicy, err := getReconnectPolicy(cmd)
+		if err != nil {
+			cmd.Printf("Invalid reconnect settings: %v\n", err)
+			return
+		}
+
+		statsInterval, err := cmd.Flags().GetDuration("stats-interval")
+		if err != nil {
+			cmd.Printf("Failed to get stats interval: %v\n", err)
+			return
+		}
+
+		metricsListen, err := cmd.Flags().GetString("metrics-listen")
+		if err != nil {
+			cmd.Printf("Failed to get metrics listen address: %v\n", err)
+			return
+		}
+
+		controlListen, err := cmd.Flags().GetString("control-listen")
+		if err != nil {
+			cmd.Printf("Failed to get control listen address: %v\n", err)
+			return
+		}
+
+		controlToken, err := cmd.Flags().GetString("control-token")
 		if err != nil {
-			cmd.Printf("Failed to get reconnect delay: %v\n", err)
+			cmd.Printf("Failed to get control token: %v\n", err)
 			return
 		}
 
@@ -186,67 +209,143 @@ var socksCmd = &cobra.Command{
 		}
 		defer tunDev.Close()
 
-		go api.MaintainTunnel(context.Background(), tlsConfig, keepalivePeriod, initialPacketSize, endpoint, api.NewNetstackAdapter(tunDev), mtu, reconnectDelay)
+		ctx, cancel := newShutdownContext()
+		defer cancel()
+
+		tunnel := api.NewTunnel(api.TunnelConfig{
+			TLSConfig:         tlsConfig,
+			KeepalivePeriod:   keepalivePeriod,
+			InitialPacketSize: initialPacketSize,
+			Endpoints:         endpoints,
+			AttemptDelay:      attemptDelay,
+			MTU:               mtu,
+			ReconnectPolicy:   reconnectPolicy,
+			QuicTracer:        quicTracer,
+		})
+		metrics := &tunnelMetrics{tunnel: tunnel, dns: &internal.DNSStats{}, conns: &connCounter{}}
+		if metricsListen != "" {
+			if err := startMetricsServer(ctx, metricsListen, metrics); err != nil {
+				cmd.Printf("Failed to start metrics server: %v\n", err)
+				return
+			}
+		}
+		if controlListen != "" {
+			control := &controlServer{
+				tunnel:  tunnel,
+				stop:    cancel,
+				token:   controlToken,
+				ipv4:    cfg.IPv4,
+				ipv6:    cfg.IPv6,
+				started: time.Now(),
+			}
+			if err := startControlServer(ctx, controlListen, control); err != nil {
+				cmd.Printf("Failed to start control server: %v\n", err)
+				return
+			}
+		}
+		device, closeCapture, err := startCapture(cmd, api.NewNetstackAdapter(tunDev))
+		if err != nil {
+			cmd.Printf("Failed to start packet capture: %v\n", err)
+			return
+		}
+		defer closeCapture()
+		tunnelDone := runTunnel(ctx, cancel, tunnel, device)
+		if statsInterval > 0 {
+			go logStats(ctx, tunnel, statsInterval)
+		}
 
 		var resolver socks5.NameResolver
 		if localDNS {
-			resolver = internal.TunnelDNSResolver{TunNet: nil, DNSAddrs: dnsAddrs, Timeout: dnsTimeout}
-		} else {
-			resolver = internal.TunnelDNSResolver{TunNet: tunNet, DNSAddrs: dnsAddrs, Timeout: dnsTimeout}
-		}
-
-		var server *socks5.Server
-		if username == "" || password == "" {
-			server = socks5.NewServer(
-				socks5.WithLogger(socks5.NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags))),
-				socks5.WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return tunNet.DialContext(ctx, network, addr)
-				}),
-				socks5.WithResolver(resolver),
-			)
+			resolver = internal.TunnelDNSResolver{TunNet: nil, DNSAddrs: dnsAddrs, Timeout: dnsTimeout, Stats: metrics.dns}
 		} else {
-			server = socks5.NewServer(
-				socks5.WithLogger(socks5.NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags))),
-				socks5.WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return tunNet.DialContext(ctx, network, addr)
-				}),
-				socks5.WithResolver(resolver),
-				socks5.WithAuthMethods(
-					[]socks5.Authenticator{
-						socks5.UserPassAuthenticator{
-							Credentials: socks5.StaticCredentials{
-								username: password,
-							},
-						},
-					},
-				),
-			)
+			resolver = internal.TunnelDNSResolver{TunNet: tunNet, DNSAddrs: dnsAddrs, Timeout: dnsTimeout, Stats: metrics.dns}
 		}
 
+		server := newSocksServer(tunNet, localAddresses, resolver, udpTimeout, username, password)
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, port))
+		if err != nil {
+			cmd.Printf("Failed to start SOCKS proxy: %v\n", err)
+			return
+		}
+		listener = metrics.conns.trackListener(listener)
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+
 		log.Printf("SOCKS proxy listening on %s:%s", bindAddress, port)
-		if err := server.ListenAndServe("tcp", net.JoinHostPort(bindAddress, port)); err != nil {
+		if err := server.Serve(listener); err != nil && ctx.Err() == nil {
 			cmd.Printf("Failed to start SOCKS proxy: %v\n", err)
 			return
 		}
+
+		waitTunnel(tunnelDone)
 	},
 }
 
+// newSocksServer creates a SOCKS5 server connecting and relaying UDP through the tunnel.
+//
+// Parameters:
+//   - tunNet: *netstack.Net - The network stack of the tunnel.
+//   - localAddresses: []netip.Addr - The addresses of the tunnel.
+//   - resolver: socks5.NameResolver - Resolves the domain names requested by clients.
+//   - udpTimeout: time.Duration - How long UDP associations may stay idle.
+//   - username: string - The username clients must authenticate with, empty to disable authentication.
+//   - password: string - The password clients must authenticate with, empty to disable authentication.
+//
+// Returns:
+//   - *socks5.Server: The SOCKS5 server, ready to serve a listener.
+func newSocksServer(tunNet *netstack.Net, localAddresses []netip.Addr, resolver socks5.NameResolver, udpTimeout time.Duration, username, password string) *socks5.Server {
+	udpAssociate := &internal.SocksUDPAssociate{
+		TunNet:         tunNet,
+		LocalAddresses: localAddresses,
+		Resolver:       resolver,
+		IdleTimeout:    udpTimeout,
+	}
+	options := []socks5.Option{
+		socks5.WithLogger(socks5.NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags))),
+		socks5.WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return tunNet.DialContext(ctx, network, addr)
+		}),
+		socks5.WithResolver(resolver),
+		socks5.WithAssociateHandle(udpAssociate.Handle),
+	}
+	if username != "" && password != "" {
+		options = append(options, socks5.WithAuthMethods(
+			[]socks5.Authenticator{
+				socks5.UserPassAuthenticator{
+					Credentials: socks5.StaticCredentials{
+						username: password,
+					},
+				},
+			},
+		))
+	}
+	return socks5.NewServer(options...)
+}
+
 func init() {
 	socksCmd.Flags().StringP("bind", "b", "0.0.0.0", "Address to bind the SOCKS proxy to")
 	socksCmd.Flags().StringP("port", "p", "1080", "Port to listen on for SOCKS proxy")
 	socksCmd.Flags().StringP("username", "u", "", "Username for proxy authentication (specify both username and password to enable)")
 	socksCmd.Flags().StringP("password", "w", "", "Password for proxy authentication (specify both username and password to enable)")
-	socksCmd.Flags().IntP("connect-port", "P", 443, "Used port for MASQUE connection")
 	socksCmd.Flags().StringArrayP("dns", "d", []string{"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9"}, "DNS servers to use")
 	socksCmd.Flags().DurationP("dns-timeout", "t", 2*time.Second, "Timeout for DNS queries")
-	socksCmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 for MASQUE connection")
 	socksCmd.Flags().BoolP("no-tunnel-ipv4", "F", false, "Disable IPv4 inside the MASQUE tunnel")
 	socksCmd.Flags().BoolP("no-tunnel-ipv6", "S", false, "Disable IPv6 inside the MASQUE tunnel")
 	socksCmd.Flags().StringP("sni-address", "s", internal.ConnectSNI, "SNI address to use for MASQUE connection")
 	socksCmd.Flags().DurationP("keepalive-period", "k", 30*time.Second, "Keepalive period for MASQUE connection")
 	socksCmd.Flags().IntP("mtu", "m", 1280, "MTU for MASQUE connection")
 	socksCmd.Flags().Uint16P("initial-packet-size", "i", 1242, "Initial packet size for MASQUE connection")
-	socksCmd.Flags().DurationP("reconnect-delay", "r", 1*time.Second, "Delay between reconnect attempts")
+	addEndpointFlags(socksCmd)
+	addReconnectFlags(socksCmd)
+	addStatsFlags(socksCmd)
+	addMetricsFlags(socksCmd)
+	addControlFlags(socksCmd)
+	addCaptureFlags(socksCmd)
+	addTraceFlags(socksCmd)
 	socksCmd.Flags().BoolP("local-dns", "l", false, "Don't use the tunnel for DNS queries")
+	socksCmd.Flags().Duration("udp-timeout", 2*time.Minute, "Close UDP associations after this long without traffic")
 	rootCmd.AddCommand(socksCmd)
 }
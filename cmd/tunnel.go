@@ -0,0 +1,387 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Diniboy1123/usque/api"
+	"github.com/Diniboy1123/usque/config"
+	"github.com/spf13/cobra"
+)
+
+// addEndpointFlags registers the flags selecting the MASQUE endpoints of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addEndpointFlags(cmd *cobra.Command) {
+	cmd.Flags().IntSliceP("connect-port", "P", []int{443}, "Used port(s) for MASQUE connection, e.g. 443,4500. Multiple ports are raced. If not given, 443 and the ports of the registration are raced")
+	cmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 for MASQUE connection")
+	cmd.Flags().Bool("dual-stack", false, "Race the IPv4 and IPv6 endpoints (Happy Eyeballs), starting with the family chosen by --ipv6")
+	cmd.Flags().StringArray("endpoint", nil, "Additional endpoint as ip or ip:port, tried before the ones from the config (can be repeated)")
+	cmd.Flags().Duration("attempt-delay", api.DefaultAttemptDelay, "Delay before racing the next candidate endpoint")
+}
+
+// getEndpoints builds the candidate endpoints from the config and the flags registered by addEndpointFlags.
+// Endpoints given with --endpoint come first, then the ones from the config for every port and
+// then the other peers of the registration, the address families interleaved as described in RFC 8305.
+// Without --connect-port, every peer is tried on 443 and the ports stored with it.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//   - cfg: *config.Config - The configuration holding the default endpoints.
+//
+// Returns:
+//   - []*net.UDPAddr: The candidate endpoints in order of preference.
+//   - time.Duration: The delay between starting attempts to two candidates.
+//   - error: An error if a flag can't be read or no valid endpoint is left.
+func getEndpoints(cmd *cobra.Command, cfg *config.Config) ([]*net.UDPAddr, time.Duration, error) {
+	ports, err := cmd.Flags().GetIntSlice("connect-port")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get connect port: %v", err)
+	}
+	portsGiven := cmd.Flags().Changed("connect-port")
+	if len(ports) == 0 {
+		return nil, 0, fmt.Errorf("at least one connect port is required")
+	}
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, 0, fmt.Errorf("invalid connect port: %d", port)
+		}
+	}
+
+	ipv6, err := cmd.Flags().GetBool("ipv6")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get ipv6 flag: %v", err)
+	}
+	dualStack, err := cmd.Flags().GetBool("dual-stack")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get dual-stack flag: %v", err)
+	}
+	customEndpoints, err := cmd.Flags().GetStringArray("endpoint")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get endpoints: %v", err)
+	}
+	attemptDelay, err := cmd.Flags().GetDuration("attempt-delay")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get attempt delay: %v", err)
+	}
+
+	var endpoints []*net.UDPAddr
+	for _, custom := range customEndpoints {
+		if _, _, err := net.SplitHostPort(custom); err == nil {
+			endpoint, err := parseEndpoint(custom, 0)
+			if err != nil {
+				return nil, 0, err
+			}
+			endpoints = append(endpoints, endpoint)
+			continue
+		}
+		for _, port := range ports {
+			endpoint, err := parseEndpoint(custom, port)
+			if err != nil {
+				return nil, 0, err
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	// peerPorts returns the ports to try a peer of the registration on
+	peerPorts := func(peer *config.Peer) []int {
+		if portsGiven || peer == nil {
+			return ports
+		}
+		peerPorts := slices.Clone(ports)
+		for _, port := range peer.Ports {
+			if !slices.Contains(peerPorts, port) {
+				peerPorts = append(peerPorts, port)
+			}
+		}
+		return peerPorts
+	}
+	// addPeer adds the addresses of a peer, required reports a missing preferred family as an error
+	addPeer := func(v4, v6 string, ports []int, required bool) error {
+		families := []string{v4, v6}
+		if ipv6 {
+			families[0], families[1] = families[1], families[0]
+		}
+		if !dualStack {
+			families = families[:1]
+		}
+		for _, port := range ports {
+			for i, host := range families {
+				ip := net.ParseIP(host)
+				if ip == nil {
+					// the other family is optional when racing both
+					if i > 0 || !required {
+						continue
+					}
+					return fmt.Errorf("invalid endpoint address in config: %q", host)
+				}
+				endpoint := &net.UDPAddr{IP: ip, Port: port}
+				if !slices.ContainsFunc(endpoints, func(e *net.UDPAddr) bool { return e.IP.Equal(ip) && e.Port == port }) {
+					endpoints = append(endpoints, endpoint)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := addPeer(cfg.EndpointV4, cfg.EndpointV6, peerPorts(cfg.EndpointPeer()), len(customEndpoints) == 0); err != nil {
+		return nil, 0, err
+	}
+	for _, peer := range cfg.AlternatePeers() {
+		addPeer(peer.EndpointV4, peer.EndpointV6, peerPorts(&peer), false)
+	}
+	if len(endpoints) == 0 {
+		return nil, 0, fmt.Errorf("no endpoint of the chosen address family in config")
+	}
+
+	return api.InterleaveEndpoints(endpoints), attemptDelay, nil
+}
+
+// parseEndpoint parses an endpoint given as ip, ip:port or [ipv6]:port.
+//
+// Parameters:
+//   - value: string - The endpoint to parse.
+//   - defaultPort: int - The port to use if value has none.
+//
+// Returns:
+//   - *net.UDPAddr: The parsed endpoint.
+//   - error: An error if value isn't a valid endpoint.
+func parseEndpoint(value string, defaultPort int) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(value)
+	if err != nil {
+		host, portStr = strings.Trim(value, "[]"), strconv.Itoa(defaultPort)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid endpoint IP: %q", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid endpoint port: %q", portStr)
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// addReconnectFlags registers the flags controlling the reconnect policy of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addReconnectFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationP("reconnect-delay", "r", 1*time.Second, "Initial delay between reconnect attempts")
+	cmd.Flags().Duration("reconnect-max-delay", 1*time.Minute, "Maximum delay between reconnect attempts, the delay doubles after every failure")
+	cmd.Flags().Int("reconnect-max-attempts", 0, "Give up after this many consecutive failed attempts (0 = never give up)")
+}
+
+// getReconnectPolicy builds the reconnect policy from the flags registered by addReconnectFlags.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//
+// Returns:
+//   - api.ReconnectPolicy: The configured exponential backoff policy.
+//   - error: An error if a flag can't be read or is invalid.
+func getReconnectPolicy(cmd *cobra.Command) (api.ReconnectPolicy, error) {
+	reconnectDelay, err := cmd.Flags().GetDuration("reconnect-delay")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reconnect delay: %v", err)
+	}
+
+	maxDelay, err := cmd.Flags().GetDuration("reconnect-max-delay")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reconnect max delay: %v", err)
+	}
+
+	maxAttempts, err := cmd.Flags().GetInt("reconnect-max-attempts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reconnect max attempts: %v", err)
+	}
+	if maxAttempts < 0 {
+		return nil, fmt.Errorf("reconnect max attempts must not be negative")
+	}
+
+	if maxDelay < reconnectDelay {
+		maxDelay = reconnectDelay
+	}
+
+	return api.NewBackoffPolicy(reconnectDelay, maxDelay, maxAttempts), nil
+}
+
+// newShutdownContext returns a context that is cancelled once the process receives SIGINT or SIGTERM.
+//
+// Returns:
+//   - context.Context: The context to run the tunnel and its services with.
+//   - context.CancelFunc: Cancels the context manually, e.g. when a service fails.
+func newShutdownContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runTunnel runs the tunnel in the background. Once it stops, cancel is called
+// so the services of the command shut down as well.
+//
+// Parameters:
+//   - ctx: context.Context - The context to run the tunnel with.
+//   - cancel: context.CancelFunc - Cancels ctx, called when the tunnel stops.
+//   - tunnel: *api.Tunnel - The tunnel to run.
+//   - device: api.TunnelDevice - The device to forward packets to and from.
+//
+// Returns:
+//   - <-chan error: Receives the reason the tunnel stopped.
+func runTunnel(ctx context.Context, cancel context.CancelFunc, tunnel *api.Tunnel, device api.TunnelDevice) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		err := tunnel.Run(ctx, device)
+		cancel()
+		done <- err
+	}()
+	return done
+}
+
+// connectedSignal returns a channel that is closed the first time the tunnel connects.
+// It must be called before the tunnel is started.
+//
+// Parameters:
+//   - tunnel: *api.Tunnel - The tunnel to watch.
+//
+// Returns:
+//   - <-chan struct{}: Closed once the tunnel is connected.
+func connectedSignal(tunnel *api.Tunnel) <-chan struct{} {
+	connected := make(chan struct{})
+	var once sync.Once
+	tunnel.Subscribe(func(event api.TunnelEvent) {
+		if event.State == api.TunnelConnected {
+			once.Do(func() { close(connected) })
+		}
+	})
+	return connected
+}
+
+// waitTunnel waits for a tunnel started by runTunnel to stop. It exits the process
+// with an error unless the tunnel was stopped on purpose.
+//
+// Parameters:
+//   - done: <-chan error - The channel returned by runTunnel.
+func waitTunnel(done <-chan error) {
+	err := <-done
+	if errors.Is(err, context.Canceled) {
+		log.Println("Tunnel stopped, shutting down")
+		return
+	}
+	log.Fatalf("Tunnel stopped: %v", err)
+}
+
+// addTraceFlags registers the flags enabling TLS key logging and qlog tracing of the MASQUE connection.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addTraceFlags(cmd *cobra.Command) {
+	cmd.Flags().String("keylog-file", os.Getenv("SSLKEYLOGFILE"), "Append the TLS secrets of the MASQUE connection to this file for Wireshark (default $SSLKEYLOGFILE)")
+	cmd.Flags().String("qlog-dir", os.Getenv("QLOGDIR"), "Write a qlog trace of every QUIC connection into this directory (default $QLOGDIR)")
+}
+
+// getTraceSettings opens the key log file and creates the qlog tracer requested by the flags registered by addTraceFlags.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to read the flags from.
+//
+// Returns:
+//   - io.Writer: The key log to pass to api.PrepareTlsConfig, nil if disabled.
+//   - api.QuicTracer: The tracer for api.TunnelConfig, nil if disabled.
+//   - error: An error if a flag can't be read, the key log can't be opened or the qlog directory can't be created.
+func getTraceSettings(cmd *cobra.Command) (io.Writer, api.QuicTracer, error) {
+	keyLogPath, err := cmd.Flags().GetString("keylog-file")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get key log file: %v", err)
+	}
+	qlogDir, err := cmd.Flags().GetString("qlog-dir")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get qlog directory: %v", err)
+	}
+
+	var keyLog io.Writer
+	if keyLogPath != "" {
+		file, err := api.OpenKeyLogFile(keyLogPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Warning: writing TLS secrets to %s, anyone with this file can decrypt the MASQUE connection", keyLogPath)
+		keyLog = file
+	}
+
+	var tracer api.QuicTracer
+	if qlogDir != "" {
+		tracer, err = api.NewQlogTracer(qlogDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Writing qlog traces to %s", qlogDir)
+	}
+
+	return keyLog, tracer, nil
+}
+
+// addStatsFlags registers the flags controlling periodic statistics logging of a tunnel command.
+//
+// Parameters:
+//   - cmd: *cobra.Command - The command to register the flags on.
+func addStatsFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("stats-interval", 0, "Log tunnel traffic statistics at this interval (0 = disabled)")
+}
+
+// logStats logs the statistics of the tunnel at the given interval until ctx is done.
+//
+// Parameters:
+//   - ctx: context.Context - Stops logging once done.
+//   - tunnel: *api.Tunnel - The tunnel to report on.
+//   - interval: time.Duration - The time between two log lines.
+func logStats(ctx context.Context, tunnel *api.Tunnel, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("Tunnel stats: %s", formatStats(tunnel.Stats()))
+		}
+	}
+}
+
+// formatStats renders tunnel statistics as a single human readable line.
+//
+// Parameters:
+//   - stats: api.TunnelStats - The statistics to render.
+//
+// Returns:
+//   - string: The rendered statistics.
+func formatStats(stats api.TunnelStats) string {
+	line := fmt.Sprintf("tx %d pkts/%d B, rx %d pkts/%d B, dropped %d, write errors %d, icmp %d, reconnects %d, failed attempts %d",
+		stats.PacketsSent, stats.BytesSent, stats.PacketsReceived, stats.BytesReceived,
+		stats.DroppedPackets, stats.WriteErrors, stats.ICMPReplies, stats.Reconnects, stats.FailedAttempts)
+	if !stats.Connected {
+		return line + ", not connected"
+	}
+
+	line += fmt.Sprintf(", up %s via %s", stats.SessionUptime.Round(time.Second), stats.Endpoint)
+	if stats.QUIC != nil {
+		line += fmt.Sprintf(", rtt %s (min %s), lost %d/%d pkts",
+			stats.QUIC.SmoothedRTT.Round(time.Millisecond), stats.QUIC.MinRTT.Round(time.Millisecond),
+			stats.QUIC.PacketsLost, stats.QUIC.PacketsSent)
+	}
+	return line
+}
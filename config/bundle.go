@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// BundleFormat identifies a connection bundle.
+const BundleFormat = "usque-bundle"
+
+// BundleVersion is the version of the connection bundles written by this version.
+const BundleVersion = 1
+
+// ErrNotBundle is returned when parsing data that isn't a connection bundle.
+var ErrNotBundle = errors.New("not a usque connection bundle")
+
+// Bundle is a portable copy of a registration, e.g. to move it to another machine or the Android app.
+// It holds the private key and access token in plaintext.
+type Bundle struct {
+	Format  string `json:"format"`  // Always BundleFormat
+	Version int    `json:"version"` // Version of the bundle format
+	Config  Config `json:"config"`  // The registration
+}
+
+// Bundle renders the configuration as a connection bundle.
+//
+// Returns:
+//   - []byte: The prettified JSON of the bundle.
+//   - error: An error if the configuration is encrypted or cannot be marshalled.
+func (c *Config) Bundle() ([]byte, error) {
+	if c.Encrypted != nil {
+		return nil, errors.New("the config has to be decrypted first")
+	}
+
+	data, err := json.MarshalIndent(Bundle{Format: BundleFormat, Version: BundleVersion, Config: *c}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bundle: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseBundle reads the configuration of a connection bundle.
+//
+// Parameters:
+//   - data: []byte - The JSON of the bundle.
+//
+// Returns:
+//   - *Config: The configuration of the bundle.
+//   - error: ErrNotBundle if the data isn't a bundle, or an error if it is invalid or of a newer version.
+func ParseBundle(data []byte) (*Config, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil || bundle.Format != BundleFormat {
+		return nil, ErrNotBundle
+	}
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+
+	config := bundle.Config
+	if config.Encrypted != nil {
+		return nil, errors.New("the bundle holds an encrypted config")
+	}
+	if config.PrivateKey == "" || config.EndpointPubKey == "" || config.ID == "" || config.AccessToken == "" {
+		return nil, errors.New("the bundle is missing the private key, endpoint public key, device ID or access token")
+	}
+	if _, err := config.GetEcPrivateKey(); err != nil {
+		return nil, err
+	}
+	if _, err := config.GetEcEndpointPublicKey(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// LoadBundle reads the configuration of a connection bundle file.
+//
+// Parameters:
+//   - path: string - The path to the bundle file.
+//
+// Returns:
+//   - *Config: The configuration of the bundle.
+//   - error: ErrNotBundle if the file isn't a bundle, or an error if it can't be read or is invalid.
+func LoadBundle(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return ParseBundle(data)
+}
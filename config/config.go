@@ -4,76 +4,93 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"os"
 )
 
 // Config represents the application configuration structure, containing essential details such as keys, endpoints, and access tokens.
 type Config struct {
-	PrivateKey     string `json:"private_key"`      // Base64-encoded ECDSA private key
-	EndpointV4     string `json:"endpoint_v4"`      // IPv4 address of the endpoint
-	EndpointV6     string `json:"endpoint_v6"`      // IPv6 address of the endpoint
-	EndpointPubKey string `json:"endpoint_pub_key"` // PEM-encoded ECDSA public key of the endpoint to verify against
-	License        string `json:"license"`          // Application license key
-	ID             string `json:"id"`               // Device unique identifier
-	AccessToken    string `json:"access_token"`     // Authentication token for API access
-	IPv4           string `json:"ipv4"`             // Assigned IPv4 address
-	IPv6           string `json:"ipv6"`             // Assigned IPv6 address
+	PrivateKey     string            `json:"private_key,omitempty"`  // Base64-encoded ECDSA private key
+	EndpointV4     string            `json:"endpoint_v4"`            // IPv4 address of the endpoint
+	EndpointV6     string            `json:"endpoint_v6"`            // IPv6 address of the endpoint
+	EndpointPubKey string            `json:"endpoint_pub_key"`       // PEM-encoded ECDSA public key of the endpoint to verify against
+	Peers          []Peer            `json:"peers,omitempty"`        // All peers of the registration, the endpoint fields above are set from the first one
+	License        string            `json:"license,omitempty"`      // Application license key
+	ID             string            `json:"id"`                     // Device unique identifier
+	AccessToken    string            `json:"access_token,omitempty"` // Authentication token for API access
+	IPv4           string            `json:"ipv4"`                   // Assigned IPv4 address
+	IPv6           string            `json:"ipv6"`                   // Assigned IPv6 address
+	SNI            string            `json:"sni,omitempty"`          // Preferred SNI for the MASQUE connection, used if not given as a setting
+	Encrypted      *EncryptedSecrets `json:"encrypted,omitempty"`    // Private key, access token and license if stored encrypted
 }
 
-// AppConfig holds the global application configuration.
-var AppConfig Config
-
-// ConfigLoaded indicates whether the configuration has been successfully loaded.
-var ConfigLoaded bool
-
-// LoadConfig loads the application configuration from a JSON file.
+// LoadConfig loads the application configuration from a profile of a JSON file.
 //
 // Parameters:
 //   - configPath: string - The path to the configuration JSON file.
+//   - profile: string - The profile to load, the default profile of the file if empty.
+//   - passphrase: Passphrase - Supplies the passphrase if the profile is encrypted, may be nil.
 //
 // Returns:
-//   - error: An error if the configuration file cannot be loaded or parsed.
-func LoadConfig(configPath string) error {
-	file, err := os.Open(configPath)
+//   - *Config: The loaded configuration with its sensitive fields decrypted.
+//   - error: An error if the configuration file cannot be loaded or parsed, the profile doesn't exist or can't be decrypted.
+func LoadConfig(configPath, profile string, passphrase Passphrase) (*Config, error) {
+	store, err := LoadStore(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to open config file: %v", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&AppConfig); err != nil {
-		return fmt.Errorf("failed to decode config file: %v", err)
+	config, err := store.Get(profile)
+	if err != nil {
+		return nil, err
 	}
 
-	ConfigLoaded = true
+	if config.Encrypted != nil {
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get passphrase: %v", err)
+		}
+		if err := config.decrypt(pass); err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	return &config, nil
 }
 
-// SaveConfig writes the current application configuration to a prettified JSON file.
+// SaveConfig writes the configuration into a profile of a prettified JSON file.
+// The other profiles of the file are kept.
 //
 // Parameters:
 //   - configPath: string - The path to save the configuration JSON file.
+//   - profile: string - The profile to save to, the default profile of the file if empty.
+//   - passphrase: Passphrase - Supplies the passphrase to encrypt the sensitive fields with, nil to store them in plaintext.
 //
 // Returns:
-//   - error: An error if the configuration file cannot be written.
-func (*Config) SaveConfig(configPath string) error {
-	file, err := os.Create(configPath)
+//   - error: An error if the configuration cannot be encrypted or the file cannot be written.
+func (c *Config) SaveConfig(configPath, profile string, passphrase Passphrase) error {
+	store, err := LoadOrNewStore(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to create config file: %v", err)
+		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(AppConfig); err != nil {
-		return fmt.Errorf("failed to encode config file: %v", err)
+	config := *c
+	config.Encrypted = nil
+	if passphrase != nil {
+		pass, err := passphrase()
+		if err != nil {
+			return fmt.Errorf("failed to get passphrase: %v", err)
+		}
+		if err := config.encrypt(pass); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	store.Set(profile, config)
+	return store.Save(configPath)
 }
 
 // GetEcPrivateKey retrieves the ECDSA private key from the stored Base64-encoded string.
@@ -81,8 +98,8 @@ func (*Config) SaveConfig(configPath string) error {
 // Returns:
 //   - *ecdsa.PrivateKey: The parsed ECDSA private key.
 //   - error: An error if decoding or parsing the private key fails.
-func (*Config) GetEcPrivateKey() (*ecdsa.PrivateKey, error) {
-	privKeyB64, err := base64.StdEncoding.DecodeString(AppConfig.PrivateKey)
+func (c *Config) GetEcPrivateKey() (*ecdsa.PrivateKey, error) {
+	privKeyB64, err := base64.StdEncoding.DecodeString(c.PrivateKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode private key: %v", err)
 	}
@@ -100,8 +117,8 @@ func (*Config) GetEcPrivateKey() (*ecdsa.PrivateKey, error) {
 // Returns:
 //   - *ecdsa.PublicKey: The parsed ECDSA public key.
 //   - error: An error if decoding or parsing the public key fails.
-func (*Config) GetEcEndpointPublicKey() (*ecdsa.PublicKey, error) {
-	endpointPubKeyB64, _ := pem.Decode([]byte(AppConfig.EndpointPubKey))
+func (c *Config) GetEcEndpointPublicKey() (*ecdsa.PublicKey, error) {
+	endpointPubKeyB64, _ := pem.Decode([]byte(c.EndpointPubKey))
 	if endpointPubKeyB64 == nil {
 		return nil, fmt.Errorf("failed to decode endpoint public key")
 	}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ImportedAccount holds the registration of a device taken from the files of another client.
+type ImportedAccount struct {
+	ID          string // Device unique identifier
+	AccessToken string // Authentication token for API access
+	License     string // Application license key, not used by Zero Trust
+}
+
+// importKeys lists the names other clients store the fields of a registration under.
+var importKeys = struct {
+	id, token, license []string
+}{
+	// reg.json of the official client, conf.json of the official client, wgcf-account.toml
+	id:      []string{"registration_id", "id", "device_id"},
+	token:   []string{"api_token", "access_token", "token"},
+	license: []string{"license_key", "license", "account.license"},
+}
+
+// ImportAccount reads the registration of a device from the files of another client: reg.json and conf.json
+// of the official WARP client (e.g. /var/lib/cloudflare-warp/reg.json) or wgcf-account.toml of wgcf.
+// With several files, the fields found in earlier files take precedence.
+//
+// Parameters:
+//   - paths: ...string - The files to read.
+//
+// Returns:
+//   - ImportedAccount: The registration found in the files.
+//   - error: An error if a file can't be read or parsed, or the device ID or access token is missing.
+func ImportAccount(paths ...string) (ImportedAccount, error) {
+	var account ImportedAccount
+	for _, path := range paths {
+		fields, err := readImportFile(path)
+		if err != nil {
+			return ImportedAccount{}, err
+		}
+
+		if account.ID == "" {
+			account.ID = lookupImportField(fields, importKeys.id)
+		}
+		if account.AccessToken == "" {
+			account.AccessToken = lookupImportField(fields, importKeys.token)
+		}
+		if account.License == "" {
+			account.License = lookupImportField(fields, importKeys.license)
+		}
+	}
+
+	files := strings.Join(paths, ", ")
+	if account.ID == "" {
+		return ImportedAccount{}, fmt.Errorf("no device ID found in %s (expected registration_id in reg.json or device_id in wgcf-account.toml)", files)
+	}
+	if account.AccessToken == "" {
+		return ImportedAccount{}, fmt.Errorf("no access token found in %s (expected api_token in reg.json or access_token in wgcf-account.toml)", files)
+	}
+
+	return account, nil
+}
+
+// readImportFile reads the fields of a JSON or TOML file of another client.
+func readImportFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	if filepath.Ext(path) == ".toml" || !json.Valid(data) {
+		fields, err := parseFlatTOML(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return fields, nil
+	}
+
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	fields := make(map[string]string)
+	collectJSONFields(object, "", fields)
+	return fields, nil
+}
+
+// collectJSONFields adds the string fields of a JSON object, those of nested objects with dotted keys like "account.license".
+func collectJSONFields(object map[string]any, prefix string, fields map[string]string) {
+	for key, value := range object {
+		switch value := value.(type) {
+		case string:
+			fields[prefix+key] = value
+		case map[string]any:
+			collectJSONFields(value, prefix+key+".", fields)
+		}
+	}
+}
+
+// parseFlatTOML parses the key = "value" lines of a TOML file without tables, as written by wgcf.
+func parseFlatTOML(data []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "[") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string: %v", line, err)
+			}
+			value = unquoted
+		}
+		fields[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// lookupImportField returns the first of the given fields that is set.
+func lookupImportField(fields map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := fields[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Diniboy1123/usque/models"
+)
+
+// Peer is a MASQUE server of the registration as returned by the API.
+type Peer struct {
+	EndpointV4     string `json:"endpoint_v4,omitempty"`   // IPv4 address of the endpoint
+	EndpointV6     string `json:"endpoint_v6,omitempty"`   // IPv6 address of the endpoint
+	EndpointHost   string `json:"endpoint_host,omitempty"` // Hostname of the endpoint
+	Ports          []int  `json:"ports,omitempty"`         // Ports the endpoint accepts
+	EndpointPubKey string `json:"endpoint_pub_key"`        // PEM-encoded ECDSA public key of the endpoint
+}
+
+// SetPeers stores the peers of a registration and points the endpoint fields at the first one.
+// It is called after the registration was made, so invalid peers other than the first one are
+// skipped instead of failing it.
+//
+// Parameters:
+//   - peers: []models.Peer - The peers of the API response.
+//
+// Returns:
+//   - []error: Why each skipped peer is invalid, to be reported as warnings.
+//   - error: An error if there are no peers or the first one is invalid, the configuration is unchanged then.
+func (c *Config) SetPeers(peers []models.Peer) ([]error, error) {
+	if len(peers) == 0 {
+		return nil, errors.New("no peers in the API response")
+	}
+
+	parsed := make([]Peer, 0, len(peers))
+	var skipped []error
+	for i := range peers {
+		peer, err := parsePeer(peers[i])
+		if err != nil {
+			err = fmt.Errorf("invalid peer %d: %v", i+1, err)
+			if i == 0 {
+				return nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		parsed = append(parsed, peer)
+	}
+
+	c.Peers = parsed
+	c.EndpointV4 = parsed[0].EndpointV4
+	c.EndpointV6 = parsed[0].EndpointV6
+	c.EndpointPubKey = parsed[0].EndpointPubKey
+	return skipped, nil
+}
+
+// parsePeer converts a peer of the API response into the stored format.
+func parsePeer(apiPeer models.Peer) (Peer, error) {
+	endpoint, err := apiPeer.ParseEndpoint()
+	if err != nil {
+		return Peer{}, err
+	}
+	if apiPeer.PublicKey == "" {
+		return Peer{}, errors.New("no public key")
+	}
+
+	peer := Peer{
+		EndpointHost:   endpoint.Host,
+		Ports:          endpoint.Ports,
+		EndpointPubKey: apiPeer.PublicKey,
+	}
+	if endpoint.V4.IsValid() {
+		peer.EndpointV4 = endpoint.V4.String()
+	}
+	if endpoint.V6.IsValid() {
+		peer.EndpointV6 = endpoint.V6.String()
+	}
+	return peer, nil
+}
+
+// EndpointPeer returns the peer the endpoint fields point at, e.g. to look up its ports.
+// If they were changed to another address, e.g. by scan --save, the first peer sharing
+// the endpoint public key is returned instead.
+//
+// Returns:
+//   - *Peer: The peer of the endpoint, nil if the config holds no matching peer.
+func (c *Config) EndpointPeer() *Peer {
+	var fallback *Peer
+	for i := range c.Peers {
+		peer := &c.Peers[i]
+		if peer.EndpointPubKey != c.EndpointPubKey {
+			continue
+		}
+		if peer.EndpointV4 == c.EndpointV4 && peer.EndpointV6 == c.EndpointV6 {
+			return peer
+		}
+		if fallback == nil {
+			fallback = peer
+		}
+	}
+	return fallback
+}
+
+// AlternatePeers returns the peers other than the one of the endpoint fields that can be used
+// instead of it, i.e. the ones sharing its public key.
+//
+// Returns:
+//   - []Peer: The alternate peers in the order returned by the API.
+func (c *Config) AlternatePeers() []Peer {
+	var peers []Peer
+	for _, peer := range c.Peers {
+		if peer.EndpointPubKey != c.EndpointPubKey {
+			continue
+		}
+		if peer.EndpointV4 == c.EndpointV4 && peer.EndpointV6 == c.EndpointV6 {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Diniboy1123/usque/models"
+)
+
+// parseTestPeers decodes peers in the format of the API response.
+func parseTestPeers(t *testing.T, data string) []models.Peer {
+	t.Helper()
+
+	var peers []models.Peer
+	if err := json.Unmarshal([]byte(data), &peers); err != nil {
+		t.Fatalf("failed to decode peers: %v", err)
+	}
+	return peers
+}
+
+func TestSetPeers(t *testing.T) {
+	peers := parseTestPeers(t, `[
+		{"public_key":"key1","endpoint":{"v4":"162.159.198.1:0","v6":"[2606:4700:103::1]:0","host":"engage.cloudflareclient.com","ports":[443,500]}},
+		{"public_key":"","endpoint":{"v4":"162.159.198.2:0"}},
+		{"public_key":"key1","endpoint":{"v4":"garbage"}},
+		{"public_key":"key2","endpoint":{"v6":"[2606:4700:103::2]:8443"}}
+	]`)
+
+	var cfg Config
+	skipped, err := cfg.SetPeers(peers)
+	if err != nil {
+		t.Fatalf("SetPeers failed: %v", err)
+	}
+	if len(skipped) != 2 {
+		t.Errorf("%d peers were skipped (%v), want 2", len(skipped), skipped)
+	}
+
+	want := []Peer{
+		{EndpointV4: "162.159.198.1", EndpointV6: "2606:4700:103::1", EndpointHost: "engage.cloudflareclient.com", Ports: []int{443, 500}, EndpointPubKey: "key1"},
+		{EndpointV6: "2606:4700:103::2", Ports: []int{8443}, EndpointPubKey: "key2"},
+	}
+	if !reflect.DeepEqual(cfg.Peers, want) {
+		t.Errorf("peers are %+v, want %+v", cfg.Peers, want)
+	}
+	if cfg.EndpointV4 != "162.159.198.1" || cfg.EndpointV6 != "2606:4700:103::1" || cfg.EndpointPubKey != "key1" {
+		t.Errorf("endpoint is %q %q %q, want the first peer", cfg.EndpointV4, cfg.EndpointV6, cfg.EndpointPubKey)
+	}
+}
+
+func TestSetPeersInvalidFirstPeer(t *testing.T) {
+	tests := []struct {
+		name  string
+		peers string
+	}{
+		{"no peers", `[]`},
+		{"no public key", `[{"public_key":"","endpoint":{"v4":"162.159.198.1:0"}},{"public_key":"key","endpoint":{"v4":"162.159.198.2:0"}}]`},
+		{"no address", `[{"public_key":"key","endpoint":{}},{"public_key":"key","endpoint":{"v4":"162.159.198.2:0"}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{EndpointV4: "1.1.1.1", EndpointPubKey: "old"}
+			if _, err := cfg.SetPeers(parseTestPeers(t, tt.peers)); err == nil {
+				t.Fatalf("SetPeers succeeded, want an error")
+			}
+			if cfg.EndpointV4 != "1.1.1.1" || cfg.EndpointPubKey != "old" || cfg.Peers != nil {
+				t.Errorf("config was changed to %+v", cfg)
+			}
+		})
+	}
+}
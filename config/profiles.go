@@ -0,0 +1,241 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultProfile is the name of the profile of a config file holding a single configuration.
+const DefaultProfile = "default"
+
+// Store is a config file holding multiple named profiles, e.g. a consumer account and Zero Trust registrations.
+// Config files holding a single configuration are read as a store with DefaultProfile only.
+type Store struct {
+	Default  string            `json:"default_profile"` // The profile used if none is selected
+	Profiles map[string]Config `json:"profiles"`        // The configurations keyed by profile name
+
+	// single is set while the store is still in the single configuration format
+	single bool
+}
+
+// LoadStore loads the profiles of a config file.
+//
+// Parameters:
+//   - configPath: string - The path to the configuration JSON file.
+//
+// Returns:
+//   - *Store: The profiles of the file.
+//   - error: An error if the file cannot be read or parsed.
+func LoadStore(configPath string) (*Store, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %v", err)
+	}
+
+	if _, ok := fields["profiles"]; !ok {
+		var config Config
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to decode config file: %v", err)
+		}
+		return &Store{Default: DefaultProfile, Profiles: map[string]Config{DefaultProfile: config}, single: true}, nil
+	}
+
+	var store Store
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %v", err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = make(map[string]Config)
+	}
+	return &store, nil
+}
+
+// LoadOrNewStore loads the profiles of a config file, or returns an empty store if the file doesn't exist yet.
+//
+// Parameters:
+//   - configPath: string - The path to the configuration JSON file.
+//
+// Returns:
+//   - *Store: The profiles of the file.
+//   - error: An error if the file exists but cannot be loaded.
+func LoadOrNewStore(configPath string) (*Store, error) {
+	store, err := LoadStore(configPath)
+	if err != nil {
+		if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+			return NewStore(), nil
+		}
+		return nil, err
+	}
+	return store, nil
+}
+
+// NewStore returns an empty store, saved in the single configuration format as long as it only holds DefaultProfile.
+//
+// Returns:
+//   - *Store: The empty store.
+func NewStore() *Store {
+	return &Store{Profiles: make(map[string]Config), single: true}
+}
+
+// Names returns the names of all profiles in alphabetical order.
+//
+// Returns:
+//   - []string: The profile names.
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a profile.
+//
+// Parameters:
+//   - name: string - The name of the profile, the default profile if empty.
+//
+// Returns:
+//   - Config: The configuration of the profile.
+//   - error: An error if the profile doesn't exist.
+func (s *Store) Get(name string) (Config, error) {
+	name = s.resolve(name)
+	config, ok := s.Profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("profile %q not found", name)
+	}
+	return config, nil
+}
+
+// Set adds or replaces a profile. The first profile added to a store becomes its default.
+//
+// Parameters:
+//   - name: string - The name of the profile, the default profile if empty.
+//   - config: Config - The configuration of the profile.
+func (s *Store) Set(name string, config Config) {
+	name = s.resolve(name)
+	if s.Default == "" {
+		s.Default = name
+	}
+	s.Profiles[name] = config
+	if name != DefaultProfile {
+		s.single = false
+	}
+}
+
+// IsEncrypted checks whether the sensitive fields of a profile are stored encrypted.
+//
+// Parameters:
+//   - name: string - The name of the profile, the default profile if empty.
+//
+// Returns:
+//   - bool: True if the profile exists and is encrypted.
+func (s *Store) IsEncrypted(name string) bool {
+	config, ok := s.Profiles[s.resolve(name)]
+	return ok && config.Encrypted != nil
+}
+
+// Remove deletes a profile. The default profile can only be removed if it is the last one.
+//
+// Parameters:
+//   - name: string - The name of the profile.
+//
+// Returns:
+//   - error: An error if the profile doesn't exist or is the default of other profiles.
+func (s *Store) Remove(name string) error {
+	if _, ok := s.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	if name == s.Default && len(s.Profiles) > 1 {
+		return errors.New("can't remove the default profile, choose another default first")
+	}
+	delete(s.Profiles, name)
+	if len(s.Profiles) == 0 {
+		s.Default = ""
+	}
+	s.single = false
+	return nil
+}
+
+// SetDefault selects the profile used if none is given.
+//
+// Parameters:
+//   - name: string - The name of the profile.
+//
+// Returns:
+//   - error: An error if the profile doesn't exist.
+func (s *Store) SetDefault(name string) error {
+	if _, ok := s.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	s.Default = name
+	s.single = s.single && name == DefaultProfile
+	return nil
+}
+
+// Save writes the store to a prettified JSON file. A store only holding DefaultProfile read from
+// a single configuration file is written back in that format, so older versions can still read it.
+// The file is only readable by its owner and replaced atomically, so it is never left half written.
+//
+// Parameters:
+//   - configPath: string - The path to save the configuration JSON file.
+//
+// Returns:
+//   - error: An error if the configuration file cannot be written.
+func (s *Store) Save(configPath string) error {
+	var value any = s
+	if config, ok := s.Profiles[DefaultProfile]; s.single && ok && len(s.Profiles) == 1 {
+		value = config
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config file: %v", err)
+	}
+	data = append(data, '\n')
+
+	// CreateTemp creates the file with 0600 permissions
+	file, err := os.CreateTemp(filepath.Dir(configPath), "."+filepath.Base(configPath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+
+	if err := os.Rename(file.Name(), configPath); err != nil {
+		return fmt.Errorf("failed to replace config file: %v", err)
+	}
+
+	return nil
+}
+
+// resolve returns the name of the profile to use, the default one if name is empty.
+func (s *Store) resolve(name string) string {
+	if name != "" {
+		return name
+	}
+	if s.Default != "" {
+		return s.Default
+	}
+	return DefaultProfile
+}
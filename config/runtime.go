@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CommonSection is the section of a runtime config applying to every command.
+const CommonSection = "common"
+
+// RuntimeConfig holds the settings of the commands, so they don't have to be passed as flags.
+// It maps a section, either CommonSection or a command name like "socks", to settings keyed by flag name,
+// e.g. {"socks": {"port": 1080, "dns": ["1.1.1.1"]}}.
+type RuntimeConfig map[string]map[string]any
+
+// LoadRuntimeConfig loads a runtime configuration from a YAML (or JSON) file.
+//
+// Parameters:
+//   - path: string - The path to the runtime configuration file.
+//
+// Returns:
+//   - RuntimeConfig: The loaded runtime configuration.
+//   - error: An error if the file cannot be read or parsed.
+func LoadRuntimeConfig(path string) (RuntimeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime config file: %v", err)
+	}
+
+	var runtime RuntimeConfig
+	if err := yaml.Unmarshal(data, &runtime); err != nil {
+		return nil, fmt.Errorf("failed to decode runtime config file: %v", err)
+	}
+
+	return runtime, nil
+}
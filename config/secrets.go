@@ -0,0 +1,142 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters of newly encrypted configs, as recommended for interactive logins.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// Limits of the scrypt parameters accepted from a config, so a tampered file can't make
+// the key derivation take gigabytes of memory or hours of CPU time.
+const (
+	scryptMaxN  = 1 << 20
+	scryptMaxR  = 32
+	scryptMaxRP = 64
+)
+
+// ErrPassphraseRequired is returned when loading an encrypted profile or saving one encrypted without a passphrase.
+var ErrPassphraseRequired = errors.New("the config is encrypted, a passphrase is required")
+
+// Passphrase supplies the passphrase protecting the sensitive fields of a config. It is only called
+// if a passphrase is actually needed, so it may prompt the user.
+type Passphrase func() ([]byte, error)
+
+// EncryptedSecrets holds the sensitive fields of a config (private key, access token and license)
+// encrypted with AES-256-GCM under a key derived from a passphrase with scrypt.
+type EncryptedSecrets struct {
+	KDF        string `json:"kdf"`        // Key derivation function, always "scrypt"
+	N          int    `json:"n"`          // scrypt CPU/memory cost
+	R          int    `json:"r"`          // scrypt block size
+	P          int    `json:"p"`          // scrypt parallelization
+	Salt       []byte `json:"salt"`       // Random salt of the key derivation
+	Nonce      []byte `json:"nonce"`      // Random AES-GCM nonce
+	Ciphertext []byte `json:"ciphertext"` // Encrypted JSON of the secrets
+}
+
+// secrets are the sensitive fields of a config as stored encrypted.
+type secrets struct {
+	PrivateKey  string `json:"private_key"`
+	AccessToken string `json:"access_token"`
+	License     string `json:"license"`
+}
+
+// encrypt moves the sensitive fields of the config into Encrypted.
+//
+// Parameters:
+//   - passphrase: []byte - The passphrase to derive the key from.
+//
+// Returns:
+//   - error: An error if the fields cannot be encrypted.
+func (c *Config) encrypt(passphrase []byte) error {
+	plaintext, err := json.Marshal(secrets{PrivateKey: c.PrivateKey, AccessToken: c.AccessToken, License: c.License})
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %v", err)
+	}
+
+	enc := &EncryptedSecrets{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, plaintext, nil)
+
+	c.PrivateKey, c.AccessToken, c.License = "", "", ""
+	c.Encrypted = enc
+	return nil
+}
+
+// decrypt restores the sensitive fields of the config from Encrypted.
+//
+// Parameters:
+//   - passphrase: []byte - The passphrase the fields were encrypted with.
+//
+// Returns:
+//   - error: An error if the passphrase is wrong or the encrypted fields are corrupt.
+func (c *Config) decrypt(passphrase []byte) error {
+	enc := c.Encrypted
+	if enc.KDF != "scrypt" {
+		return fmt.Errorf("unsupported key derivation function %q", enc.KDF)
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return fmt.Errorf("invalid nonce size %d", len(enc.Nonce))
+	}
+	plaintext, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return errors.New("failed to decrypt config: wrong passphrase or corrupt data")
+	}
+
+	var s secrets
+	if err := json.Unmarshal(plaintext, &s); err != nil {
+		return fmt.Errorf("failed to decode secrets: %v", err)
+	}
+
+	c.PrivateKey, c.AccessToken, c.License = s.PrivateKey, s.AccessToken, s.License
+	c.Encrypted = nil
+	return nil
+}
+
+// aead derives the key from the passphrase and returns the AES-GCM cipher.
+func (e *EncryptedSecrets) aead(passphrase []byte) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("the passphrase is empty")
+	}
+	if e.N < 2 || e.N > scryptMaxN || e.N&(e.N-1) != 0 {
+		return nil, fmt.Errorf("invalid scrypt N %d, must be a power of 2 up to %d", e.N, scryptMaxN)
+	}
+	if e.R < 1 || e.R > scryptMaxR || e.P < 1 || e.R*e.P > scryptMaxRP {
+		return nil, fmt.Errorf("invalid scrypt r %d and p %d, r must be up to %d and r*p up to %d", e.R, e.P, scryptMaxR, scryptMaxRP)
+	}
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSecrets returns a config with all sensitive fields set.
+func testSecrets() Config {
+	return Config{PrivateKey: "private-key", AccessToken: "token", License: "license", ID: "device"}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	cfg := testSecrets()
+	if err := cfg.encrypt([]byte("passphrase")); err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if cfg.PrivateKey != "" || cfg.AccessToken != "" || cfg.License != "" {
+		t.Fatalf("sensitive fields were kept in plaintext: %+v", cfg)
+	}
+	if cfg.Encrypted == nil || cfg.Encrypted.N != scryptN || cfg.Encrypted.R != scryptR || cfg.Encrypted.P != scryptP {
+		t.Fatalf("encrypted secrets are %+v, want the default scrypt parameters", cfg.Encrypted)
+	}
+
+	if err := cfg.decrypt([]byte("passphrase")); err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if want := testSecrets(); cfg.PrivateKey != want.PrivateKey || cfg.AccessToken != want.AccessToken ||
+		cfg.License != want.License || cfg.ID != want.ID || cfg.Encrypted != nil {
+		t.Errorf("decrypted config is %+v, want %+v", cfg, want)
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	cfg := testSecrets()
+	if err := cfg.encrypt([]byte("passphrase")); err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	enc := cfg.Encrypted
+
+	if err := cfg.decrypt([]byte("wrong")); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("decrypt returned %v, want a wrong passphrase error", err)
+	}
+	if cfg.Encrypted != enc || cfg.PrivateKey != "" {
+		t.Errorf("config was changed by a failed decryption: %+v", cfg)
+	}
+	if err := cfg.decrypt(nil); err == nil {
+		t.Errorf("decrypt succeeded with an empty passphrase")
+	}
+}
+
+func TestDecryptRejectsScryptParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		n, r, p int
+	}{
+		{"N too large", scryptMaxN << 1, 8, 1},
+		{"N not a power of 2", 1<<15 + 1, 8, 1},
+		{"N zero", 0, 8, 1},
+		{"N negative", -1 << 15, 8, 1},
+		{"r too large", 1 << 10, scryptMaxR + 1, 1},
+		{"r*p too large", 1 << 10, 8, scryptMaxRP/8 + 1},
+		{"r zero", 1 << 10, 0, 1},
+		{"p zero", 1 << 10, 8, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testSecrets()
+			if err := cfg.encrypt([]byte("passphrase")); err != nil {
+				t.Fatalf("encrypt failed: %v", err)
+			}
+			cfg.Encrypted.N, cfg.Encrypted.R, cfg.Encrypted.P = tt.n, tt.r, tt.p
+
+			err := cfg.decrypt([]byte("passphrase"))
+			if err == nil || !strings.Contains(err.Error(), "invalid scrypt") {
+				t.Errorf("decrypt returned %v, want the parameters rejected", err)
+			}
+		})
+	}
+}
+
+func TestSaveLoadEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	passphrase := func(pass string) Passphrase {
+		return func() ([]byte, error) { return []byte(pass), nil }
+	}
+
+	cfg := testSecrets()
+	if err := cfg.SaveConfig(path, "", passphrase("passphrase")); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	if cfg.PrivateKey != "private-key" || cfg.Encrypted != nil {
+		t.Errorf("SaveConfig changed the saved config: %+v", cfg)
+	}
+
+	if _, err := LoadConfig(path, "", nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("LoadConfig without a passphrase returned %v, want ErrPassphraseRequired", err)
+	}
+	if _, err := LoadConfig(path, "", passphrase("wrong")); err == nil {
+		t.Errorf("LoadConfig succeeded with a wrong passphrase")
+	}
+	loaded, err := LoadConfig(path, "", passphrase("passphrase"))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if loaded.PrivateKey != cfg.PrivateKey || loaded.AccessToken != cfg.AccessToken || loaded.License != cfg.License {
+		t.Errorf("loaded config is %+v, want %+v", loaded, cfg)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"golang.zx2c4.com/wireguard/tun/netstack"
@@ -22,6 +23,41 @@ type TunnelDNSResolver struct {
 
 	// Timeout is the timeout for DNS queries on a specific server before trying the next one.
 	Timeout time.Duration
+
+	// Stats, if set, counts the lookups performed by the resolver.
+	Stats *DNSStats
+}
+
+// DNSStats counts the lookups performed by a TunnelDNSResolver.
+// It is safe for concurrent use.
+type DNSStats struct {
+	queries      atomic.Uint64
+	failures     atomic.Uint64
+	latencyNanos atomic.Uint64
+}
+
+// record adds a finished lookup to the counters.
+func (s *DNSStats) record(latency time.Duration, err error) {
+	s.queries.Add(1)
+	s.latencyNanos.Add(uint64(latency))
+	if err != nil {
+		s.failures.Add(1)
+	}
+}
+
+// Queries returns the number of lookups performed so far.
+func (s *DNSStats) Queries() uint64 {
+	return s.queries.Load()
+}
+
+// Failures returns the number of lookups that failed on every DNS server.
+func (s *DNSStats) Failures() uint64 {
+	return s.failures.Load()
+}
+
+// TotalLatency returns the total time spent in lookups, failed ones included.
+func (s *DNSStats) TotalLatency() time.Duration {
+	return time.Duration(s.latencyNanos.Load())
 }
 
 // Resolve performs a DNS lookup using the provided DNS resolvers.
@@ -37,8 +73,18 @@ type TunnelDNSResolver struct {
 //   - net.IP: The resolved IP address.
 //   - error: An error if the lookup fails.
 func (r TunnelDNSResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	start := time.Now()
+	ip, err := r.resolve(ctx, name)
+	if r.Stats != nil {
+		r.Stats.record(time.Since(start), err)
+	}
+	return ctx, ip, err
+}
+
+// resolve queries all DNS servers concurrently and returns the first address found.
+func (r TunnelDNSResolver) resolve(ctx context.Context, name string) (net.IP, error) {
 	if len(r.DNSAddrs) == 0 {
-		return ctx, nil, fmt.Errorf("no DNS servers configured")
+		return nil, fmt.Errorf("no DNS servers configured")
 	}
 
 	var queryCtx context.Context = ctx
@@ -89,12 +135,12 @@ func (r TunnelDNSResolver) Resolve(ctx context.Context, name string) (context.Co
 			if cancel != nil {
 				cancel()
 			}
-			return ctx, res.ip, nil
+			return res.ip, nil
 		}
 		lastErr = res.err
 	}
 
-	return ctx, nil, fmt.Errorf("all DNS servers failed: %v", lastErr)
+	return nil, fmt.Errorf("all DNS servers failed: %v", lastErr)
 }
 
 // NewNetstackResolver returns a *net.Resolver that uses the tunnel network stack
@@ -0,0 +1,338 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// PacketFilter decides whether an IP packet is captured.
+type PacketFilter func(pkt []byte) bool
+
+// IP protocol numbers understood by the packet filter.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// packetInfo holds the header fields of an IP packet the filter matches on.
+type packetInfo struct {
+	version  int
+	proto    uint8
+	src, dst netip.Addr
+	// ports are only set for unfragmented (or first fragment) TCP and UDP packets
+	hasPorts         bool
+	srcPort, dstPort uint16
+}
+
+// ParsePacketFilter compiles a filter expression in a subset of the tcpdump/BPF syntax.
+//
+// Supported primitives are ip, ip6, tcp, udp, icmp, icmp6, proto <number>,
+// [src|dst] host <address>, [src|dst] net <prefix> and [src|dst] port <number>.
+// They are combined with and (&&), or (||), not (!) and parentheses. Like in tcpdump,
+// a missing operator means and, so "tcp port 443" is the same as "tcp and port 443".
+//
+// Parameters:
+//   - expr: string - The filter expression.
+//
+// Returns:
+//   - PacketFilter: The compiled filter, nil if expr is empty (capture everything).
+//   - error: An error if expr is invalid.
+func ParsePacketFilter(expr string) (PacketFilter, error) {
+	p := &filterParser{tokens: tokenizeFilter(expr)}
+	if len(p.tokens) == 0 {
+		return nil, nil
+	}
+
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("invalid filter: unexpected %q", tok)
+	}
+
+	return func(pkt []byte) bool {
+		info, ok := parsePacketInfo(pkt)
+		return ok && match(&info)
+	}, nil
+}
+
+// tokenizeFilter splits a filter expression into words, parentheses and the !, && and || operators.
+func tokenizeFilter(expr string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '(' || c == ')' || c == '!':
+			flush()
+			tokens = append(tokens, string(c))
+		case (c == '&' || c == '|') && i+1 < len(expr) && expr[i+1] == c:
+			flush()
+			tokens = append(tokens, expr[i:i+2])
+			i++
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type packetMatcher func(info *packetInfo) bool
+
+// filterParser is a recursive descent parser for filter expressions.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *filterParser) next() (string, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+func (p *filterParser) parseOr() (packetMatcher, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || (tok != "or" && tok != "||") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(info *packetInfo) bool { return l(info) || right(info) }
+	}
+}
+
+func (p *filterParser) parseAnd() (packetMatcher, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok == "or" || tok == "||" || tok == ")" {
+			return left, nil
+		}
+		if tok == "and" || tok == "&&" {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(info *packetInfo) bool { return l(info) && right(info) }
+	}
+}
+
+func (p *filterParser) parseNot() (packetMatcher, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch tok {
+	case "not", "!":
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(info *packetInfo) bool { return !inner(info) }, nil
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.next(); !ok || tok != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	}
+
+	return p.parsePrimitive()
+}
+
+func (p *filterParser) parsePrimitive() (packetMatcher, error) {
+	tok, _ := p.next()
+
+	switch tok {
+	case "ip":
+		return func(info *packetInfo) bool { return info.version == 4 }, nil
+	case "ip6":
+		return func(info *packetInfo) bool { return info.version == 6 }, nil
+	case "tcp":
+		return matchProto(protoTCP), nil
+	case "udp":
+		return matchProto(protoUDP), nil
+	case "icmp":
+		return matchProto(protoICMP), nil
+	case "icmp6":
+		return matchProto(protoICMPv6), nil
+	case "proto":
+		arg, ok := p.next()
+		if !ok {
+			return nil, fmt.Errorf("proto needs a protocol number")
+		}
+		proto, err := strconv.ParseUint(arg, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol number %q", arg)
+		}
+		return matchProto(uint8(proto)), nil
+	}
+
+	src, dst := true, true
+	switch tok {
+	case "src":
+		dst = false
+		tok, _ = p.next()
+	case "dst":
+		src = false
+		tok, _ = p.next()
+	}
+
+	arg, ok := p.next()
+	if !ok && (tok == "host" || tok == "net" || tok == "port") {
+		return nil, fmt.Errorf("%s needs an argument", tok)
+	}
+
+	switch tok {
+	case "host":
+		addr, err := netip.ParseAddr(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q", arg)
+		}
+		addr = addr.Unmap()
+		return func(info *packetInfo) bool {
+			return (src && info.src == addr) || (dst && info.dst == addr)
+		}, nil
+	case "net":
+		prefix, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q", arg)
+		}
+		prefix = prefix.Masked()
+		return func(info *packetInfo) bool {
+			return (src && prefix.Contains(info.src)) || (dst && prefix.Contains(info.dst))
+		}, nil
+	case "port":
+		port, err := strconv.ParseUint(arg, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", arg)
+		}
+		return func(info *packetInfo) bool {
+			return info.hasPorts && ((src && info.srcPort == uint16(port)) || (dst && info.dstPort == uint16(port)))
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown primitive %q", tok)
+}
+
+func matchProto(proto uint8) packetMatcher {
+	return func(info *packetInfo) bool { return info.proto == proto }
+}
+
+// parsePacketInfo extracts the fields the filter matches on from an IPv4 or IPv6 packet.
+func parsePacketInfo(pkt []byte) (packetInfo, bool) {
+	var info packetInfo
+	if len(pkt) < 1 {
+		return info, false
+	}
+
+	var payload []byte
+	firstFragment := true
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return info, false
+		}
+		headerLen := int(pkt[0]&0x0f) * 4
+		if headerLen < 20 || len(pkt) < headerLen {
+			return info, false
+		}
+		info.version = 4
+		info.proto = pkt[9]
+		info.src = netip.AddrFrom4([4]byte(pkt[12:16]))
+		info.dst = netip.AddrFrom4([4]byte(pkt[16:20]))
+		firstFragment = binary.BigEndian.Uint16(pkt[6:8])&0x1fff == 0
+		payload = pkt[headerLen:]
+	case 6:
+		if len(pkt) < 40 {
+			return info, false
+		}
+		info.version = 6
+		info.src = netip.AddrFrom16([16]byte(pkt[8:24]))
+		info.dst = netip.AddrFrom16([16]byte(pkt[24:40]))
+		next, rest := pkt[6], pkt[40:]
+		// skip the extension headers that may precede the transport header
+	headers:
+		for {
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(rest) < 8 {
+					return info, false
+				}
+				headerLen := (int(rest[1]) + 1) * 8
+				if len(rest) < headerLen {
+					return info, false
+				}
+				next, rest = rest[0], rest[headerLen:]
+			case 44: // fragment
+				if len(rest) < 8 {
+					return info, false
+				}
+				firstFragment = binary.BigEndian.Uint16(rest[2:4])&0xfff8 == 0
+				next, rest = rest[0], rest[8:]
+			default:
+				break headers
+			}
+		}
+		info.proto = next
+		payload = rest
+	default:
+		return info, false
+	}
+
+	if (info.proto == protoTCP || info.proto == protoUDP) && firstFragment && len(payload) >= 4 {
+		info.hasPorts = true
+		info.srcPort = binary.BigEndian.Uint16(payload[0:2])
+		info.dstPort = binary.BigEndian.Uint16(payload[2:4])
+	}
+
+	return info, true
+}
@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// testIPv4Packet builds an IPv4 packet with a 20 byte header, fragOffset is in units of 8 bytes.
+func testIPv4Packet(proto uint8, src, dst string, fragOffset uint16, payload []byte) []byte {
+	pkt := make([]byte, 20+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[6:], fragOffset)
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], netip.MustParseAddr(src).AsSlice())
+	copy(pkt[16:20], netip.MustParseAddr(dst).AsSlice())
+	copy(pkt[20:], payload)
+	return pkt
+}
+
+// testIPv6Packet builds an IPv6 packet, payload starts with the header next refers to.
+func testIPv6Packet(next uint8, src, dst string, payload []byte) []byte {
+	pkt := make([]byte, 40+len(payload))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(payload)))
+	pkt[6] = next
+	pkt[7] = 64
+	copy(pkt[8:24], netip.MustParseAddr(src).AsSlice())
+	copy(pkt[24:40], netip.MustParseAddr(dst).AsSlice())
+	copy(pkt[40:], payload)
+	return pkt
+}
+
+// testPorts returns the start of a TCP or UDP header.
+func testPorts(src, dst uint16) []byte {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint16(hdr[0:], src)
+	binary.BigEndian.PutUint16(hdr[2:], dst)
+	return hdr
+}
+
+// testExtHeader returns an IPv6 hop-by-hop, routing or destination options header of (units+1)*8 bytes.
+func testExtHeader(next uint8, units int) []byte {
+	hdr := make([]byte, (units+1)*8)
+	hdr[0] = next
+	hdr[1] = byte(units)
+	return hdr
+}
+
+// testFragmentHeader returns an IPv6 fragment header, offset is in units of 8 bytes.
+func testFragmentHeader(next uint8, offset uint16, more bool) []byte {
+	hdr := make([]byte, 8)
+	hdr[0] = next
+	field := offset << 3
+	if more {
+		field |= 1
+	}
+	binary.BigEndian.PutUint16(hdr[2:], field)
+	binary.BigEndian.PutUint32(hdr[4:], 0x12345678)
+	return hdr
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+var (
+	tcp4Packet  = testIPv4Packet(protoTCP, "10.0.0.1", "1.1.1.1", 0, testPorts(40000, 443))
+	udp4Packet  = testIPv4Packet(protoUDP, "10.0.0.1", "8.8.8.8", 0, testPorts(40001, 53))
+	icmp4Packet = testIPv4Packet(protoICMP, "8.8.8.8", "10.0.0.1", 0, []byte{0, 0, 0, 0})
+	udp6Packet  = testIPv6Packet(protoUDP, "fd00::1", "2606:4700:4700::1111", testPorts(40002, 53))
+)
+
+func TestParsePacketFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		pkt  []byte
+		want bool
+	}{
+		{"protocol", "tcp", tcp4Packet, true},
+		{"other protocol", "udp", tcp4Packet, false},
+		{"ip version", "ip6", udp6Packet, true},
+		{"proto number", "proto 1", icmp4Packet, true},
+		{"host", "host 1.1.1.1", tcp4Packet, true},
+		{"src host", "src host 1.1.1.1", tcp4Packet, false},
+		{"dst host", "dst host 1.1.1.1", tcp4Packet, true},
+		{"v6 host", "host 2606:4700:4700::1111", udp6Packet, true},
+		{"net", "src net 10.0.0.0/8", tcp4Packet, true},
+		{"unmasked net", "net 10.1.2.3/8", tcp4Packet, true},
+		{"v6 net", "dst net 2606:4700::/32", udp6Packet, true},
+		{"port", "port 443", tcp4Packet, true},
+		{"src port", "src port 443", tcp4Packet, false},
+		{"port without ports", "port 0", icmp4Packet, false},
+
+		// and binds tighter than or, not tighter than and
+		{"or of and", "tcp or udp and port 53", tcp4Packet, true},
+		{"and of or", "(tcp or udp) and port 53", tcp4Packet, false},
+		{"and before or", "udp and port 53 or icmp", icmp4Packet, true},
+		{"not before and", "not tcp and udp", udp4Packet, true},
+		{"not before and false", "not tcp and udp", tcp4Packet, false},
+		{"not of parentheses", "!(tcp || udp)", icmp4Packet, true},
+		{"double not", "not not tcp", tcp4Packet, true},
+		{"symbols", "!tcp&&port 53||icmp", udp4Packet, true},
+
+		// a missing operator means and
+		{"implicit and", "tcp port 443", tcp4Packet, true},
+		{"implicit and false", "udp port 443", tcp4Packet, false},
+		{"implicit and chain", "ip6 udp dst port 53", udp6Packet, true},
+		{"implicit and before or", "tcp port 53 or icmp", tcp4Packet, false},
+		{"implicit and with not", "ip not tcp", udp4Packet, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParsePacketFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParsePacketFilter(%q) failed: %v", tt.expr, err)
+			}
+			if got := filter(tt.pkt); got != tt.want {
+				t.Errorf("filter %q matched %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePacketFilterEmpty(t *testing.T) {
+	for _, expr := range []string{"", "  \t\n"} {
+		filter, err := ParsePacketFilter(expr)
+		if err != nil || filter != nil {
+			t.Errorf("ParsePacketFilter(%q) returned %v, %v; want no filter", expr, filter != nil, err)
+		}
+	}
+}
+
+func TestParsePacketFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"(tcp",
+		"tcp)",
+		"tcp and",
+		"or tcp",
+		"not",
+		"port",
+		"src",
+		"host example.com",
+		"net 10.0.0.0",
+		"port 65536",
+		"proto 256",
+		"proto tcp",
+		"tcp & udp",
+		"bogus",
+	} {
+		if _, err := ParsePacketFilter(expr); err == nil {
+			t.Errorf("ParsePacketFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParsePacketFilterInvalidPacket(t *testing.T) {
+	filter, err := ParsePacketFilter("not tcp")
+	if err != nil {
+		t.Fatalf("ParsePacketFilter failed: %v", err)
+	}
+	if filter([]byte{0x45, 0, 0}) {
+		t.Errorf("filter matched a truncated packet")
+	}
+}
+
+func TestParsePacketInfo(t *testing.T) {
+	v4Options := testIPv4Packet(protoUDP, "10.0.0.1", "8.8.8.8", 0, nil)
+	v4Options[0] = 0x46 // 4 bytes of options
+	v4Options = concat(v4Options, []byte{1, 1, 1, 0}, testPorts(1000, 53))
+
+	tests := []struct {
+		name     string
+		pkt      []byte
+		proto    uint8
+		hasPorts bool
+		srcPort  uint16
+		dstPort  uint16
+	}{
+		{"IPv4 TCP", tcp4Packet, protoTCP, true, 40000, 443},
+		{"IPv4 options", v4Options, protoUDP, true, 1000, 53},
+		{"IPv4 first fragment", testIPv4Packet(protoUDP, "10.0.0.1", "8.8.8.8", 0x2000, testPorts(1000, 53)), protoUDP, true, 1000, 53},
+		{"IPv4 later fragment", testIPv4Packet(protoUDP, "10.0.0.1", "8.8.8.8", 185, testPorts(1000, 53)), protoUDP, false, 0, 0},
+		{"IPv4 ICMP", icmp4Packet, protoICMP, false, 0, 0},
+		{"IPv4 short transport header", testIPv4Packet(protoTCP, "10.0.0.1", "1.1.1.1", 0, []byte{1, 2}), protoTCP, false, 0, 0},
+		{"IPv6 UDP", udp6Packet, protoUDP, true, 40002, 53},
+		{
+			"IPv6 extension headers",
+			testIPv6Packet(0, "fd00::1", "fd00::2", concat(testExtHeader(43, 0), testExtHeader(60, 1), testExtHeader(protoTCP, 0), testPorts(2000, 80))),
+			protoTCP, true, 2000, 80,
+		},
+		{
+			"IPv6 first fragment",
+			testIPv6Packet(44, "fd00::1", "fd00::2", concat(testFragmentHeader(protoUDP, 0, true), testPorts(3000, 443))),
+			protoUDP, true, 3000, 443,
+		},
+		{
+			"IPv6 later fragment",
+			testIPv6Packet(44, "fd00::1", "fd00::2", concat(testFragmentHeader(protoUDP, 181, false), testPorts(3000, 443))),
+			protoUDP, false, 0, 0,
+		},
+		{
+			"IPv6 fragment after extension header",
+			testIPv6Packet(0, "fd00::1", "fd00::2", concat(testExtHeader(44, 0), testFragmentHeader(protoTCP, 1, false), testPorts(3000, 443))),
+			protoTCP, false, 0, 0,
+		},
+		{
+			"IPv6 ICMPv6 after extension header",
+			testIPv6Packet(60, "fd00::1", "fd00::2", concat(testExtHeader(protoICMPv6, 0), []byte{128, 0, 0, 0})),
+			protoICMPv6, false, 0, 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := parsePacketInfo(tt.pkt)
+			if !ok {
+				t.Fatalf("parsePacketInfo failed")
+			}
+			if info.proto != tt.proto || info.hasPorts != tt.hasPorts || info.srcPort != tt.srcPort || info.dstPort != tt.dstPort {
+				t.Errorf("info is proto %d, ports %v %d > %d; want proto %d, ports %v %d > %d",
+					info.proto, info.hasPorts, info.srcPort, info.dstPort, tt.proto, tt.hasPorts, tt.srcPort, tt.dstPort)
+			}
+		})
+	}
+}
+
+func TestParsePacketInfoAddresses(t *testing.T) {
+	info, ok := parsePacketInfo(udp6Packet)
+	if !ok {
+		t.Fatalf("parsePacketInfo failed")
+	}
+	if info.version != 6 || info.src != netip.MustParseAddr("fd00::1") || info.dst != netip.MustParseAddr("2606:4700:4700::1111") {
+		t.Errorf("info is version %d, %v > %v", info.version, info.src, info.dst)
+	}
+
+	info, ok = parsePacketInfo(tcp4Packet)
+	if !ok {
+		t.Fatalf("parsePacketInfo failed")
+	}
+	if info.version != 4 || info.src != netip.MustParseAddr("10.0.0.1") || info.dst != netip.MustParseAddr("1.1.1.1") {
+		t.Errorf("info is version %d, %v > %v", info.version, info.src, info.dst)
+	}
+}
+
+func TestParsePacketInfoInvalid(t *testing.T) {
+	badIHL := testIPv4Packet(protoTCP, "10.0.0.1", "1.1.1.1", 0, nil)
+	badIHL[0] = 0x44
+	longIHL := testIPv4Packet(protoTCP, "10.0.0.1", "1.1.1.1", 0, nil)
+	longIHL[0] = 0x4f
+
+	tests := []struct {
+		name string
+		pkt  []byte
+	}{
+		{"empty", nil},
+		{"unknown version", concat([]byte{0x50}, make([]byte, 39))},
+		{"short IPv4", tcp4Packet[:19]},
+		{"IPv4 header length below 20", badIHL},
+		{"IPv4 header length beyond packet", longIHL},
+		{"short IPv6", udp6Packet[:39]},
+		{"truncated extension header", testIPv6Packet(0, "fd00::1", "fd00::2", make([]byte, 4))},
+		{"extension header beyond packet", testIPv6Packet(0, "fd00::1", "fd00::2", testExtHeader(protoTCP, 2)[:16])},
+		{"truncated fragment header", testIPv6Packet(44, "fd00::1", "fd00::2", make([]byte, 6))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if info, ok := parsePacketInfo(tt.pkt); ok {
+				t.Errorf("parsePacketInfo returned %+v, want failure", info)
+			}
+		})
+	}
+}
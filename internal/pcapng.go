@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pcapng block types and options, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngSectionHeader    = 0x0A0D0D0A
+	pcapngInterfaceDesc    = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1A2B3C4D
+	pcapngOptEnd           = 0
+	pcapngOptShbUserAppl   = 4
+	pcapngOptIfName        = 2
+	pcapngOptEpbFlags      = 2
+	pcapngFlagInbound      = 1
+	pcapngFlagOutbound     = 2
+	pcapngLinkTypeRaw      = 101 // LINKTYPE_RAW, the packet starts with the IPv4 or IPv6 header
+	pcapngDefaultSnapLen   = 65535
+	pcapngSectionHeaderLen = 28
+)
+
+// PcapngWriter writes IP packets into a pcapng file with a single LINKTYPE_RAW interface.
+// Once the file reaches the size limit, it is rotated: the current file is renamed to
+// name.1.pcapng, an older name.1.pcapng to name.2.pcapng and so on, and a new file is started.
+//
+// Every packet is written to the file right away, so the capture stays readable if the process is killed.
+// It is safe for concurrent use.
+type PcapngWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	snapLen  int
+	file     *os.File
+	size     int64
+	packets  uint64
+}
+
+// NewPcapngWriter creates the capture file and writes the pcapng header to it.
+//
+// Parameters:
+//   - path: string - The file to write to. An existing file is overwritten.
+//   - maxSize: int64 - The size in bytes after which the file is rotated, 0 for no limit.
+//   - maxFiles: int - The number of files to keep including the current one when rotating, at least 1.
+//     With 1 the file is started over once it is full.
+//   - snapLen: int - The maximum number of bytes stored per packet, 0 for the whole packet.
+//
+// Returns:
+//   - *PcapngWriter: The writer.
+//   - error: An error if the file can't be created.
+func NewPcapngWriter(path string, maxSize int64, maxFiles int, snapLen int) (*PcapngWriter, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("max size must not be negative")
+	}
+	if maxFiles < 1 {
+		return nil, fmt.Errorf("at least one capture file must be kept")
+	}
+	if snapLen < 0 {
+		return nil, fmt.Errorf("snap length must not be negative")
+	}
+	if snapLen == 0 || snapLen > pcapngDefaultSnapLen {
+		snapLen = pcapngDefaultSnapLen
+	}
+
+	w := &PcapngWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		snapLen:  snapLen,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WritePacket appends a packet to the capture, rotating the file first if it would exceed the size limit.
+//
+// Parameters:
+//   - ts: time.Time - When the packet was seen.
+//   - pkt: []byte - The IP packet, truncated to the snap length.
+//   - inbound: bool - Whether the packet came from the tunnel (true) or was sent into it (false).
+//
+// Returns:
+//   - error: An error if the packet can't be written.
+func (w *PcapngWriter) WritePacket(ts time.Time, pkt []byte, inbound bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("capture file is closed")
+	}
+
+	captured := pkt
+	if len(captured) > w.snapLen {
+		captured = captured[:w.snapLen]
+	}
+
+	padded := (len(captured) + 3) &^ 3
+	// block header, interface id, timestamp, lengths, data, epb_flags option, end of options, block length
+	blockLen := 28 + padded + 8 + 4 + 4
+	if w.maxSize > 0 && w.packets > 0 && w.size+int64(blockLen) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	flags := uint32(pcapngFlagOutbound)
+	if inbound {
+		flags = pcapngFlagInbound
+	}
+	micros := uint64(ts.UnixMicro())
+
+	block := make([]byte, blockLen)
+	binary.LittleEndian.PutUint32(block[0:], pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint32(block[8:], 0)
+	binary.LittleEndian.PutUint32(block[12:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(block[16:], uint32(micros))
+	binary.LittleEndian.PutUint32(block[20:], uint32(len(captured)))
+	binary.LittleEndian.PutUint32(block[24:], uint32(len(pkt)))
+	copy(block[28:], captured)
+	opts := block[28+padded:]
+	binary.LittleEndian.PutUint16(opts[0:], pcapngOptEpbFlags)
+	binary.LittleEndian.PutUint16(opts[2:], 4)
+	binary.LittleEndian.PutUint32(opts[4:], flags)
+	binary.LittleEndian.PutUint32(opts[8:], pcapngOptEnd)
+	binary.LittleEndian.PutUint32(block[blockLen-4:], uint32(blockLen))
+
+	if _, err := w.file.Write(block); err != nil {
+		return fmt.Errorf("failed to write packet: %v", err)
+	}
+	w.size += int64(blockLen)
+	w.packets++
+	return nil
+}
+
+// Close closes the current capture file.
+//
+// Returns:
+//   - error: An error if the file can't be closed.
+func (w *PcapngWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open creates the current capture file and writes the section header and interface description.
+func (w *PcapngWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %v", err)
+	}
+
+	header := append(pcapngSectionHeaderBlock(), pcapngInterfaceBlock(w.snapLen)...)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write capture header: %v", err)
+	}
+
+	w.file = file
+	w.size = int64(len(header))
+	w.packets = 0
+	return nil
+}
+
+// rotate shifts the existing capture files by one and starts a new one.
+func (w *PcapngWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close capture file: %v", err)
+	}
+	w.file = nil
+
+	if w.maxFiles > 1 {
+		os.Remove(rotatedCaptureName(w.path, w.maxFiles-1))
+		for i := w.maxFiles - 2; i >= 1; i-- {
+			os.Rename(rotatedCaptureName(w.path, i), rotatedCaptureName(w.path, i+1))
+		}
+		if err := os.Rename(w.path, rotatedCaptureName(w.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate capture file: %v", err)
+		}
+	}
+
+	return w.open()
+}
+
+// rotatedCaptureName returns the name of the i-th rotated capture file, e.g. capture.1.pcapng for capture.pcapng.
+func rotatedCaptureName(path string, i int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), i, ext)
+}
+
+// pcapngSectionHeaderBlock returns a little-endian section header block of unspecified length.
+func pcapngSectionHeaderBlock() []byte {
+	appl := pcapngOption(pcapngOptShbUserAppl, []byte("usque"))
+	blockLen := pcapngSectionHeaderLen + len(appl) + 4
+	block := make([]byte, blockLen)
+	binary.LittleEndian.PutUint32(block[0:], pcapngSectionHeader)
+	binary.LittleEndian.PutUint32(block[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint32(block[8:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(block[12:], 1) // major version
+	binary.LittleEndian.PutUint16(block[14:], 0) // minor version
+	binary.LittleEndian.PutUint64(block[16:], ^uint64(0))
+	copy(block[24:], appl)
+	binary.LittleEndian.PutUint32(block[24+len(appl):], pcapngOptEnd)
+	binary.LittleEndian.PutUint32(block[blockLen-4:], uint32(blockLen))
+	return block
+}
+
+// pcapngInterfaceBlock returns an interface description block for raw IP packets with microsecond timestamps.
+func pcapngInterfaceBlock(snapLen int) []byte {
+	name := pcapngOption(pcapngOptIfName, []byte("usque"))
+	blockLen := 16 + len(name) + 4 + 4
+	block := make([]byte, blockLen)
+	binary.LittleEndian.PutUint32(block[0:], pcapngInterfaceDesc)
+	binary.LittleEndian.PutUint32(block[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint16(block[8:], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(block[12:], uint32(snapLen))
+	copy(block[16:], name)
+	binary.LittleEndian.PutUint32(block[16+len(name):], pcapngOptEnd)
+	binary.LittleEndian.PutUint32(block[blockLen-4:], uint32(blockLen))
+	return block
+}
+
+// pcapngOption encodes an option padded to 32 bits.
+func pcapngOption(code uint16, value []byte) []byte {
+	opt := make([]byte, 4+(len(value)+3)&^3)
+	binary.LittleEndian.PutUint16(opt[0:], code)
+	binary.LittleEndian.PutUint16(opt[2:], uint16(len(value)))
+	copy(opt[4:], value)
+	return opt
+}
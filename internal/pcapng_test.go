@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pcapngTestBlock is a block read back from a capture file.
+type pcapngTestBlock struct {
+	typ  uint32
+	body []byte // between the leading and trailing block length
+}
+
+// readPcapngBlocks reads a capture file and checks that every block is padded to 32 bits
+// and that its leading and trailing lengths match.
+func readPcapngBlocks(t *testing.T, path string) []pcapngTestBlock {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read capture: %v", err)
+	}
+
+	var blocks []pcapngTestBlock
+	for off := 0; off < len(data); {
+		if len(data)-off < 12 {
+			t.Fatalf("%d trailing bytes at offset %d", len(data)-off, off)
+		}
+		typ := binary.LittleEndian.Uint32(data[off:])
+		blockLen := int(binary.LittleEndian.Uint32(data[off+4:]))
+		if blockLen%4 != 0 || blockLen < 12 || off+blockLen > len(data) {
+			t.Fatalf("block at offset %d has invalid length %d", off, blockLen)
+		}
+		if trailing := int(binary.LittleEndian.Uint32(data[off+blockLen-4:])); trailing != blockLen {
+			t.Fatalf("block at offset %d has length %d, trailing length %d", off, blockLen, trailing)
+		}
+		blocks = append(blocks, pcapngTestBlock{typ: typ, body: data[off+8 : off+blockLen-4]})
+		off += blockLen
+	}
+
+	if len(blocks) < 2 || blocks[0].typ != pcapngSectionHeader || blocks[1].typ != pcapngInterfaceDesc {
+		t.Fatalf("capture doesn't start with a section header and an interface description")
+	}
+	if magic := binary.LittleEndian.Uint32(blocks[0].body); magic != pcapngByteOrderMagic {
+		t.Errorf("byte order magic is %#x", magic)
+	}
+	return blocks
+}
+
+// pcapngTestPacket is an enhanced packet block decoded by parseTestPacket.
+type pcapngTestPacket struct {
+	micros       uint64
+	captured     []byte
+	originalLen  int
+	flags        uint32
+	paddingZeros bool
+}
+
+func parseTestPacket(t *testing.T, block pcapngTestBlock) pcapngTestPacket {
+	t.Helper()
+
+	if block.typ != pcapngEnhancedPacket {
+		t.Fatalf("block type is %#x, want an enhanced packet", block.typ)
+	}
+	body := block.body
+	capLen := int(binary.LittleEndian.Uint32(body[12:]))
+	padded := (capLen + 3) &^ 3
+	if len(body) != 20+padded+12 {
+		t.Fatalf("block body is %d bytes, want %d for %d captured bytes", len(body), 20+padded+12, capLen)
+	}
+	opts := body[20+padded:]
+	if code, length := binary.LittleEndian.Uint16(opts), binary.LittleEndian.Uint16(opts[2:]); code != pcapngOptEpbFlags || length != 4 {
+		t.Fatalf("option is %d of length %d, want epb_flags", code, length)
+	}
+	if end := binary.LittleEndian.Uint32(opts[8:]); end != pcapngOptEnd {
+		t.Fatalf("options aren't terminated")
+	}
+
+	return pcapngTestPacket{
+		micros:       uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:])),
+		captured:     body[20 : 20+capLen],
+		originalLen:  int(binary.LittleEndian.Uint32(body[16:])),
+		flags:        binary.LittleEndian.Uint32(opts[4:]),
+		paddingZeros: bytes.Count(body[20+capLen:20+padded], []byte{0}) == padded-capLen,
+	}
+}
+
+func TestPcapngWriterBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	w, err := NewPcapngWriter(path, 0, 1, 6)
+	if err != nil {
+		t.Fatalf("NewPcapngWriter failed: %v", err)
+	}
+
+	ts := time.UnixMicro(1700000000123456)
+	packets := [][]byte{{}, {1}, {1, 2, 3}, {1, 2, 3, 4}, {1, 2, 3, 4, 5}, {1, 2, 3, 4, 5, 6, 7, 8, 9}}
+	for i, pkt := range packets {
+		if err := w.WritePacket(ts, pkt, i%2 == 0); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.WritePacket(ts, []byte{1}, true); err == nil {
+		t.Errorf("WritePacket succeeded after Close")
+	}
+
+	blocks := readPcapngBlocks(t, path)
+	if snapLen := binary.LittleEndian.Uint32(blocks[1].body[4:]); snapLen != 6 {
+		t.Errorf("interface snap length is %d, want 6", snapLen)
+	}
+	if len(blocks) != 2+len(packets) {
+		t.Fatalf("capture has %d blocks, want %d", len(blocks), 2+len(packets))
+	}
+	for i, pkt := range packets {
+		got := parseTestPacket(t, blocks[2+i])
+		want := pkt
+		if len(want) > 6 {
+			want = want[:6]
+		}
+		if !bytes.Equal(got.captured, want) || got.originalLen != len(pkt) {
+			t.Errorf("packet %d is %v of %d bytes, want %v of %d bytes", i, got.captured, got.originalLen, want, len(pkt))
+		}
+		if !got.paddingZeros {
+			t.Errorf("packet %d isn't padded with zeros", i)
+		}
+		if got.micros != uint64(ts.UnixMicro()) {
+			t.Errorf("packet %d timestamp is %d, want %d", i, got.micros, ts.UnixMicro())
+		}
+		wantFlags := uint32(pcapngFlagOutbound)
+		if i%2 == 0 {
+			wantFlags = pcapngFlagInbound
+		}
+		if got.flags != wantFlags {
+			t.Errorf("packet %d flags are %d, want %d", i, got.flags, wantFlags)
+		}
+	}
+}
+
+func TestPcapngWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+
+	// every block of a 5 byte packet takes 52 bytes, so each file holds the header and 3 packets
+	header := int64(len(pcapngSectionHeaderBlock()) + len(pcapngInterfaceBlock(pcapngDefaultSnapLen)))
+	w, err := NewPcapngWriter(path, header+3*52, 3, 0)
+	if err != nil {
+		t.Fatalf("NewPcapngWriter failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.WritePacket(time.Now(), []byte{byte(i), 0, 0, 0, 0}, false); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// packets 0-2 were dropped with the oldest file
+	files := []struct {
+		path  string
+		first byte
+		count int
+	}{
+		{rotatedCaptureName(path, 2), 3, 3},
+		{rotatedCaptureName(path, 1), 6, 3},
+		{path, 9, 1},
+	}
+	for _, f := range files {
+		blocks := readPcapngBlocks(t, f.path)
+		if len(blocks)-2 != f.count {
+			t.Errorf("%s holds %d packets, want %d", filepath.Base(f.path), len(blocks)-2, f.count)
+			continue
+		}
+		for i, block := range blocks[2:] {
+			if pkt := parseTestPacket(t, block); pkt.captured[0] != f.first+byte(i) {
+				t.Errorf("%s packet %d is %d, want %d", filepath.Base(f.path), i, pkt.captured[0], f.first+byte(i))
+			}
+		}
+	}
+	if _, err := os.Stat(rotatedCaptureName(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("more than 3 capture files were kept")
+	}
+}
+
+func TestPcapngWriterRotationSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	// a packet larger than the limit still goes into a file of its own
+	w, err := NewPcapngWriter(path, 100, 1, 0)
+	if err != nil {
+		t.Fatalf("NewPcapngWriter failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.WritePacket(time.Now(), bytes.Repeat([]byte{byte(i)}, 200), true); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	blocks := readPcapngBlocks(t, path)
+	if len(blocks) != 3 {
+		t.Fatalf("capture has %d blocks, want the header and the last packet", len(blocks))
+	}
+	if pkt := parseTestPacket(t, blocks[2]); pkt.captured[0] != 2 || len(pkt.captured) != 200 {
+		t.Errorf("capture holds packet %d of %d bytes, want the last one", pkt.captured[0], len(pkt.captured))
+	}
+	if _, err := os.Stat(rotatedCaptureName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("a rotated file was kept with a single file")
+	}
+}
+
+func TestNewPcapngWriterErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	tests := []struct {
+		name     string
+		maxSize  int64
+		maxFiles int
+		snapLen  int
+	}{
+		{"negative size", -1, 1, 0},
+		{"no files", 0, 0, 0},
+		{"negative snap length", 0, 1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w, err := NewPcapngWriter(path, tt.maxSize, tt.maxFiles, tt.snapLen); err == nil {
+				w.Close()
+				t.Errorf("NewPcapngWriter succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestRotatedCaptureName(t *testing.T) {
+	tests := []struct {
+		path string
+		i    int
+		want string
+	}{
+		{"capture.pcapng", 1, "capture.1.pcapng"},
+		{"dir/capture.pcapng", 2, "dir/capture.2.pcapng"},
+		{"capture", 1, "capture.1"},
+	}
+	for _, tt := range tests {
+		if got := rotatedCaptureName(tt.path, tt.i); got != tt.want {
+			t.Errorf("rotatedCaptureName(%q, %d) is %q, want %q", tt.path, tt.i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ForwardRule is a single port forwarding rule of a rules file.
+type ForwardRule struct {
+	Name           string   `yaml:"name" json:"name"`                       // Optional name shown in errors
+	Direction      string   `yaml:"direction" json:"direction"`             // "local" (like -L) or "remote" (like -R), local if empty
+	Protocol       string   `yaml:"protocol" json:"protocol"`               // "tcp" or "udp", tcp if empty
+	Bind           string   `yaml:"bind" json:"bind"`                       // The address to listen on: [bind_address:]port or unix:/path/to/socket
+	Remote         string   `yaml:"remote" json:"remote"`                   // The address to connect to: host:port or unix:/path/to/socket
+	Allow          []string `yaml:"allow" json:"allow"`                     // IPs or CIDR prefixes allowed to connect, anyone if empty
+	MaxConnections int      `yaml:"max_connections" json:"max_connections"` // The maximum number of concurrent connections or UDP flows, unlimited if 0
+}
+
+// ForwardRules is the content of a port forwarding rules file.
+type ForwardRules struct {
+	Rules []ForwardRule `yaml:"rules" json:"rules"`
+}
+
+// LoadForwardRules reads a YAML or JSON rules file and converts its rules into port mappings.
+//
+// Parameters:
+//   - path: string - The rules file.
+//
+// Returns:
+//   - []PortMapping: The mappings to forward from the local machine into the tunnel (like -L).
+//   - []PortMapping: The mappings to forward from the tunnel to the local machine (like -R).
+//   - error: An error if the file can't be read or a rule is invalid.
+func LoadForwardRules(path string) ([]PortMapping, []PortMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read rules file: %v", err)
+	}
+
+	return ParseForwardRules(data)
+}
+
+// ParseForwardRules parses the content of a YAML or JSON rules file, see LoadForwardRules.
+//
+// Parameters:
+//   - data: []byte - The content of the rules file.
+//
+// Returns:
+//   - []PortMapping: The mappings to forward from the local machine into the tunnel (like -L).
+//   - []PortMapping: The mappings to forward from the tunnel to the local machine (like -R).
+//   - error: An error if the content can't be decoded or a rule is invalid.
+func ParseForwardRules(data []byte) ([]PortMapping, []PortMapping, error) {
+	// JSON is valid YAML, so a single decoder handles both formats
+	var rules ForwardRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode rules file: %v", err)
+	}
+
+	var local, remote []PortMapping
+	for i, rule := range rules.Rules {
+		pm, isRemote, err := rule.PortMapping()
+		if err != nil {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, nil, fmt.Errorf("invalid rule %s: %v", name, err)
+		}
+		if isRemote {
+			remote = append(remote, pm)
+		} else {
+			local = append(local, pm)
+		}
+	}
+
+	return local, remote, nil
+}
+
+// PortMapping converts the rule into a port mapping.
+//
+// Returns:
+//   - PortMapping: The port mapping of the rule.
+//   - bool: True if the rule forwards from the tunnel to the local machine (like -R).
+//   - error: An error if the rule is invalid.
+func (r ForwardRule) PortMapping() (PortMapping, bool, error) {
+	var isRemote bool
+	switch r.Direction {
+	case "", "local":
+	case "remote":
+		isRemote = true
+	default:
+		return PortMapping{}, false, fmt.Errorf("invalid direction %q (expected local or remote)", r.Direction)
+	}
+
+	protocol := r.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		return PortMapping{}, false, fmt.Errorf("invalid protocol %q (expected tcp or udp)", r.Protocol)
+	}
+	if r.Bind == "" || r.Remote == "" {
+		return PortMapping{}, false, fmt.Errorf("both bind and remote are required")
+	}
+	if r.MaxConnections < 0 {
+		return PortMapping{}, false, fmt.Errorf("invalid max_connections %d", r.MaxConnections)
+	}
+
+	// reuse the flag syntax, which covers every combination of addresses and sockets
+	pm, err := ParsePortMapping(protocol + "/" + r.Bind + ":" + r.Remote)
+	if err != nil {
+		return PortMapping{}, false, err
+	}
+	if isRemote && pm.BindSocket != "" {
+		return PortMapping{}, false, fmt.Errorf("can't listen on a unix socket inside the tunnel")
+	}
+	if !isRemote && pm.RemoteSocket != "" {
+		return PortMapping{}, false, fmt.Errorf("can't connect to a unix socket inside the tunnel")
+	}
+
+	for _, allow := range r.Allow {
+		prefix, err := parseSourcePrefix(allow)
+		if err != nil {
+			return PortMapping{}, false, err
+		}
+		pm.AllowedSources = append(pm.AllowedSources, prefix)
+	}
+	pm.MaxConnections = r.MaxConnections
+
+	return pm, isRemote, nil
+}
+
+// parseSourcePrefix parses an allowed source given either as an IP or a CIDR prefix.
+//
+// Parameters:
+//   - source: string - The IP or CIDR prefix.
+//
+// Returns:
+//   - netip.Prefix: The prefix, a single address prefix for IPs.
+//   - error: An error if the source is invalid.
+func parseSourcePrefix(source string) (netip.Prefix, error) {
+	if strings.Contains(source, "/") {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid allowed source %q: %v", source, err)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(source)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid allowed source %q: %v", source, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
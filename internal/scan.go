@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DefaultScanPorts are the UDP ports Cloudflare accepts MASQUE connections on.
+var DefaultScanPorts = []int{443, 500, 1701, 4500, 8443}
+
+// ExpandScanTargets turns scan targets into the list of endpoints to probe.
+//
+// A target is either a single address ("162.159.198.1", "2606:4700:103::1"), a CIDR prefix
+// ("162.159.198.0/24"), or any of those with a port ("162.159.198.1:4500", "[2606:4700:103::1]:443").
+// Targets without a port are probed on every port in ports.
+//
+// Parameters:
+//   - targets: []string - The targets to expand.
+//   - ports: []int - The ports to probe targets without a port on.
+//   - maxEndpoints: int - The maximum number of endpoints to return, to avoid scanning huge prefixes by accident.
+//
+// Returns:
+//   - []*net.UDPAddr: The endpoints, in the order of the targets.
+//   - error: An error if a target is invalid or the targets expand to more than maxEndpoints endpoints.
+func ExpandScanTargets(targets []string, ports []int, maxEndpoints int) ([]*net.UDPAddr, error) {
+	var endpoints []*net.UDPAddr
+	add := func(addr netip.Addr, port int) error {
+		if len(endpoints) >= maxEndpoints {
+			return fmt.Errorf("targets expand to more than %d endpoints", maxEndpoints)
+		}
+		endpoints = append(endpoints, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), uint16(port))))
+		return nil
+	}
+
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+
+		targetPorts := ports
+		host := target
+		if h, p, err := net.SplitHostPort(target); err == nil {
+			port, err := strconv.Atoi(p)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port in target %q", target)
+			}
+			host, targetPorts = h, []int{port}
+		}
+		host = strings.Trim(host, "[]")
+
+		if strings.Contains(host, "/") {
+			prefix, err := netip.ParsePrefix(host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid prefix %q: %v", host, err)
+			}
+			for addr := prefix.Masked().Addr(); prefix.Contains(addr); addr = addr.Next() {
+				for _, port := range targetPorts {
+					if err := add(addr, port); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %v", host, err)
+		}
+		for _, port := range targetPorts {
+			if err := add(addr, port); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return endpoints, nil
+}
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// maxUDPPayload is the largest payload a UDP datagram can carry.
+const maxUDPPayload = 65535
+
+// SocksUDPAssociate handles SOCKS5 UDP ASSOCIATE requests by relaying the datagrams of the client
+// through the tunnel. Use its Handle method with socks5.WithAssociateHandle.
+//
+// Every association gets its own relay socket on the address the client connected to, and one
+// socket per address family inside the tunnel, so any destination can answer (like a full cone NAT).
+// Fragmented SOCKS5 datagrams are dropped as allowed by RFC 1928. The association ends once the
+// control connection is closed or no datagram was relayed in either direction for IdleTimeout.
+type SocksUDPAssociate struct {
+	// TunNet is the network stack of the tunnel to relay the datagrams through.
+	TunNet *netstack.Net
+	// LocalAddresses are the addresses of the tunnel, datagrams are sent from the one matching the destination's family.
+	LocalAddresses []netip.Addr
+	// Resolver resolves destinations given as domain names.
+	Resolver socks5.NameResolver
+	// IdleTimeout ends associations without traffic, 2 minutes if 0.
+	IdleTimeout time.Duration
+}
+
+// Handle serves a single UDP ASSOCIATE request. It blocks until the association ends.
+//
+// Parameters:
+//   - ctx: context.Context - The context of the request.
+//   - writer: io.Writer - The control connection to send the reply to.
+//   - request: *socks5.Request - The UDP ASSOCIATE request.
+//
+// Returns:
+//   - error: An error if the association can't be set up.
+func (a *SocksUDPAssociate) Handle(ctx context.Context, writer io.Writer, request *socks5.Request) error {
+	local, ok := request.LocalAddr.(*net.TCPAddr)
+	if !ok {
+		socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return fmt.Errorf("unexpected local address %v", request.LocalAddr)
+	}
+	client, ok := request.RemoteAddr.(*net.TCPAddr)
+	if !ok {
+		socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return fmt.Errorf("unexpected client address %v", request.RemoteAddr)
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+	if err != nil {
+		socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return fmt.Errorf("failed to listen for UDP: %v", err)
+	}
+
+	if err := socks5.SendReply(writer, statute.RepSuccess, relay.LocalAddr()); err != nil {
+		relay.Close()
+		return fmt.Errorf("failed to send reply: %v", err)
+	}
+
+	idleTimeout := a.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 2 * time.Minute
+	}
+
+	assoc := &udpAssociation{
+		ctx:        ctx,
+		tunNet:     a.TunNet,
+		local:      a.LocalAddresses,
+		resolver:   a.Resolver,
+		relay:      relay,
+		clientIP:   client.IP,
+		clientPort: request.DestAddr.Port,
+		resolved:   make(map[string]net.IP),
+		done:       make(chan struct{}),
+	}
+	assoc.touch()
+
+	// the association lives as long as the control connection
+	go func() {
+		io.Copy(io.Discard, request.Reader)
+		assoc.close()
+	}()
+	go assoc.expire(idleTimeout)
+
+	assoc.relayFromClient()
+	return nil
+}
+
+// udpAssociation is a single UDP ASSOCIATE session.
+type udpAssociation struct {
+	ctx      context.Context
+	tunNet   *netstack.Net
+	local    []netip.Addr
+	resolver socks5.NameResolver
+	relay    *net.UDPConn
+
+	// clientIP and clientPort restrict who may use the relay, clientPort is 0 if not announced
+	clientIP   net.IP
+	clientPort int
+	// client is the address datagrams are returned to, learned from the first datagram
+	client atomic.Pointer[net.UDPAddr]
+
+	mu       sync.Mutex
+	conns    [2]net.PacketConn // IPv4 and IPv6 sockets inside the tunnel, created on demand
+	resolved map[string]net.IP
+
+	lastActive atomic.Int64
+	fragments  atomic.Bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// touch records that a datagram was relayed.
+func (u *udpAssociation) touch() {
+	u.lastActive.Store(time.Now().UnixNano())
+}
+
+// close ends the association and unblocks all its goroutines.
+func (u *udpAssociation) close() {
+	u.closeOnce.Do(func() {
+		close(u.done)
+		u.relay.Close()
+		u.mu.Lock()
+		for _, conn := range u.conns {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+		u.mu.Unlock()
+	})
+}
+
+// expire closes the association once it was idle for timeout.
+func (u *udpAssociation) expire(timeout time.Duration) {
+	interval := min(max(timeout/4, time.Second), timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, u.lastActive.Load())) >= timeout {
+				u.close()
+				return
+			}
+		}
+	}
+}
+
+// relayFromClient reads datagrams from the client and sends them to their destination until the association ends.
+func (u *udpAssociation) relayFromClient() {
+	defer u.close()
+
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, src, err := u.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !u.acceptClient(src) {
+			continue
+		}
+
+		datagram, err := statute.ParseDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+		if datagram.Frag != 0 {
+			if !u.fragments.Swap(true) {
+				log.Printf("Dropping fragmented SOCKS5 UDP datagrams from %s, fragmentation is not supported", src)
+			}
+			continue
+		}
+
+		dst, err := u.destination(datagram.DstAddr)
+		if err != nil {
+			log.Printf("Failed to relay UDP datagram to %s: %v", datagram.DstAddr.Address(), err)
+			continue
+		}
+		conn, err := u.conn(dst.IP.To4() == nil)
+		if err != nil {
+			log.Printf("Failed to relay UDP datagram to %s: %v", dst, err)
+			continue
+		}
+		if _, err := conn.WriteTo(datagram.Data, dst); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		u.touch()
+	}
+}
+
+// acceptClient checks that a datagram comes from the client that requested the association.
+// RFC 1928 requires dropping datagrams from any other address.
+func (u *udpAssociation) acceptClient(src *net.UDPAddr) bool {
+	if !src.IP.Equal(u.clientIP) || (u.clientPort != 0 && src.Port != u.clientPort) {
+		return false
+	}
+	if client := u.client.Load(); client != nil {
+		return client.Port == src.Port
+	}
+	u.client.Store(src)
+	return true
+}
+
+// destination resolves the destination of a datagram, caching domain names for the association.
+func (u *udpAssociation) destination(addr statute.AddrSpec) (*net.UDPAddr, error) {
+	if addr.FQDN == "" {
+		return &net.UDPAddr{IP: addr.IP, Port: addr.Port}, nil
+	}
+
+	u.mu.Lock()
+	ip, ok := u.resolved[addr.FQDN]
+	u.mu.Unlock()
+	if !ok {
+		var err error
+		_, ip, err = u.resolver.Resolve(u.ctx, addr.FQDN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", addr.FQDN, err)
+		}
+		u.mu.Lock()
+		u.resolved[addr.FQDN] = ip
+		u.mu.Unlock()
+	}
+
+	return &net.UDPAddr{IP: ip, Port: addr.Port}, nil
+}
+
+// conn returns the socket inside the tunnel for the given address family, creating it on first use.
+func (u *udpAssociation) conn(ipv6 bool) (net.PacketConn, error) {
+	family := 0
+	if ipv6 {
+		family = 1
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	select {
+	case <-u.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	if u.conns[family] != nil {
+		return u.conns[family], nil
+	}
+
+	// the stack doesn't treat the unspecified address as a wildcard, so bind to the tunnel address itself
+	var local netip.Addr
+	for _, addr := range u.local {
+		if addr.Is6() == ipv6 {
+			local = addr
+			break
+		}
+	}
+	if !local.IsValid() {
+		return nil, errors.New("tunnel has no address of this family")
+	}
+
+	conn, err := u.tunNet.ListenUDPAddrPort(netip.AddrPortFrom(local, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP socket in tunnel: %v", err)
+	}
+	u.conns[family] = conn
+	go u.relayToClient(conn)
+	return conn, nil
+}
+
+// relayToClient reads datagrams from a socket inside the tunnel and returns them to the client
+// with the SOCKS5 UDP header naming their source.
+func (u *udpAssociation) relayToClient(conn net.PacketConn) {
+	defer u.close()
+
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		src, ok := from.(*net.UDPAddr)
+		client := u.client.Load()
+		if !ok || client == nil {
+			continue
+		}
+
+		datagram := statute.Datagram{
+			DstAddr: statute.AddrSpec{IP: src.IP, Port: src.Port, AddrType: statute.ATYPIPv6},
+			Data:    buf[:n],
+		}
+		if src.IP.To4() != nil {
+			datagram.DstAddr.AddrType = statute.ATYPIPv4
+		}
+		if _, err := u.relay.WriteToUDP(datagram.Bytes(), client); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		u.touch()
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"math/big"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
@@ -20,10 +21,53 @@ import (
 
 // PortMapping represents a network port forwarding rule.
 type PortMapping struct {
+	Protocol    string // The forwarded protocol, "tcp" or "udp".
 	BindAddress string // The address to bind the local port.
 	LocalPort   int    // The local port number.
 	RemoteIP    string // The remote destination IP address.
 	RemotePort  int    // The remote destination port number.
+
+	BindSocket   string // The Unix socket to listen on instead of BindAddress and LocalPort.
+	RemoteSocket string // The Unix socket to connect to instead of RemoteIP and RemotePort.
+
+	AllowedSources []netip.Prefix // The client addresses allowed to use the mapping, any if empty.
+	MaxConnections int            // The maximum number of concurrent connections or UDP flows, unlimited if 0.
+}
+
+// AllowsSource checks whether a client may use the port mapping according to AllowedSources.
+// Clients without an IP address, like those of Unix sockets, are always allowed.
+//
+// Parameters:
+//   - addr: net.Addr - The address of the client.
+//
+// Returns:
+//   - bool: True if the client is allowed, false otherwise.
+func (pm PortMapping) AllowsSource(addr net.Addr) bool {
+	if len(pm.AllowedSources) == 0 {
+		return true
+	}
+
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return true
+	}
+	source, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	source = source.Unmap()
+
+	for _, prefix := range pm.AllowedSources {
+		if prefix.Contains(source) {
+			return true
+		}
+	}
+	return false
 }
 
 // GenerateRandomAndroidSerial generates a random 8-byte Android-like device identifier
@@ -205,7 +249,9 @@ func parsePortMapping(port string) (bindAddress string, localPort int, remoteHos
 
 // ParsePortMapping parses a port mapping string into a structured PortMapping.
 //
-// The expected format is: `[bind_address:]local_port:remote_host:remote_port`.
+// The expected format is: `[tcp/|udp/][bind_address:]local_port:remote_host:remote_port`.
+// Mappings without a protocol prefix forward TCP. TCP mappings may listen on a Unix socket with
+// `unix:/path/to/socket:remote_host:remote_port` or connect to one with `[bind_address:]local_port:unix:/path/to/socket`.
 //
 // Parameters:
 //   - port: string - The port mapping string.
@@ -214,12 +260,28 @@ func parsePortMapping(port string) (bindAddress string, localPort int, remoteHos
 //   - PortMapping: A structured representation of the parsed port mapping.
 //   - error:       An error if the parsing fails.
 func ParsePortMapping(port string) (PortMapping, error) {
+	protocol := "tcp"
+	for _, prefix := range []string{"tcp", "udp"} {
+		if rest, ok := strings.CutPrefix(port, prefix+"/"); ok {
+			protocol, port = prefix, rest
+			break
+		}
+	}
+
+	if path, ok := strings.CutPrefix(port, "unix:"); ok {
+		return parseUnixBindMapping(protocol, path)
+	}
+	if i := strings.Index(port, ":unix:"); i >= 0 {
+		return parseUnixRemoteMapping(protocol, port[:i], port[i+len(":unix:"):])
+	}
+
 	bindAddress, localPort, remoteHost, remotePort, err := parsePortMapping(port)
 	if err != nil {
 		return PortMapping{}, err
 	}
 
 	return PortMapping{
+		Protocol:    protocol,
 		BindAddress: bindAddress,
 		LocalPort:   localPort,
 		RemoteIP:    remoteHost,
@@ -227,6 +289,126 @@ func ParsePortMapping(port string) (PortMapping, error) {
 	}, nil
 }
 
+// parseUnixBindMapping parses a port mapping listening on a Unix socket.
+//
+// Parameters:
+//   - protocol: string - The forwarded protocol, only "tcp" is supported.
+//   - mapping: string - The mapping without the "unix:" prefix, in the format `/path/to/socket:remote_host:remote_port`.
+//
+// Returns:
+//   - PortMapping: A structured representation of the parsed port mapping.
+//   - error:       An error if the parsing fails.
+func parseUnixBindMapping(protocol, mapping string) (PortMapping, error) {
+	if protocol != "tcp" {
+		return PortMapping{}, errors.New("unix sockets are only supported for tcp")
+	}
+
+	// the socket path may contain colons, so the remote address is taken from the end
+	i := strings.LastIndex(mapping, ":")
+	if i < 0 {
+		return PortMapping{}, errors.New("invalid port mapping format (expected format: unix:/path/to/socket:remote_host:remote_port)")
+	}
+	hostEnd := i
+	if strings.HasSuffix(mapping[:i], "]") {
+		i = strings.LastIndex(mapping[:i], "[")
+	} else {
+		i = strings.LastIndex(mapping[:i], ":") + 1
+	}
+	if i <= 1 || mapping[i-1] != ':' {
+		return PortMapping{}, errors.New("invalid port mapping format (expected format: unix:/path/to/socket:remote_host:remote_port)")
+	}
+	path, remoteHost, portStr := mapping[:i-1], strings.Trim(mapping[i:hostEnd], "[]"), mapping[hostEnd+1:]
+
+	remotePort, err := strconv.Atoi(portStr)
+	if err != nil || remotePort <= 0 || remotePort > 65535 {
+		return PortMapping{}, errors.New("invalid remote port")
+	}
+	if net.ParseIP(remoteHost) == nil {
+		if !isValidHostname(remoteHost) {
+			return PortMapping{}, errors.New("invalid remote hostname/IP")
+		}
+		if remoteHost, err = resolveBindAddress(remoteHost); err != nil {
+			return PortMapping{}, errors.New("invalid remote address: " + err.Error())
+		}
+	}
+
+	return PortMapping{
+		Protocol:   protocol,
+		BindSocket: path,
+		RemoteIP:   remoteHost,
+		RemotePort: remotePort,
+	}, nil
+}
+
+// parseUnixRemoteMapping parses a port mapping connecting to a Unix socket.
+//
+// Parameters:
+//   - protocol: string - The forwarded protocol, only "tcp" is supported.
+//   - bind: string - The listening side, in the format `[bind_address:]local_port`.
+//   - path: string - The Unix socket to connect to.
+//
+// Returns:
+//   - PortMapping: A structured representation of the parsed port mapping.
+//   - error:       An error if the parsing fails.
+func parseUnixRemoteMapping(protocol, bind, path string) (PortMapping, error) {
+	if protocol != "tcp" {
+		return PortMapping{}, errors.New("unix sockets are only supported for tcp")
+	}
+	if path == "" {
+		return PortMapping{}, errors.New("invalid port mapping format (expected format: [bind_address:]local_port:unix:/path/to/socket)")
+	}
+
+	bindAddress, localPort, err := ParseDynamicForward(bind)
+	if err != nil {
+		return PortMapping{}, err
+	}
+
+	return PortMapping{
+		Protocol:     protocol,
+		BindAddress:  bindAddress,
+		LocalPort:    localPort,
+		RemoteSocket: path,
+	}, nil
+}
+
+// ParseDynamicForward parses the listen address of a dynamic (SOCKS) forward.
+//
+// The expected format is: `[bind_address:]port`, like the -D option of SSH. The bind address
+// defaults to localhost; an empty bind address or `*` listens on all interfaces.
+//
+// Parameters:
+//   - spec: string - The dynamic forward string.
+//
+// Returns:
+//   - string: The resolved bind address.
+//   - int:    The port to listen on.
+//   - error:  An error if the parsing fails.
+func ParseDynamicForward(spec string) (string, int, error) {
+	bindAddress, portStr := "localhost", spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		bindAddress, portStr = spec[:i], spec[i+1:]
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, errors.New("invalid port (expected format: [bind_address:]port)")
+	}
+
+	if strings.HasPrefix(bindAddress, "[") && strings.HasSuffix(bindAddress, "]") {
+		bindAddress = strings.Trim(bindAddress, "[]")
+	}
+	if bindAddress == "" || bindAddress == "*" {
+		bindAddress = "0.0.0.0"
+	}
+	if net.ParseIP(bindAddress) == nil {
+		if bindAddress, err = resolveBindAddress(bindAddress); err != nil {
+			return "", 0, errors.New("invalid bind address: " + err.Error())
+		}
+	}
+
+	return bindAddress, port, nil
+}
+
 // resolveBindAddress resolves a hostname or IP to its string representation.
 //
 // Parameters:
@@ -0,0 +1,24 @@
+package models
+
+// BoundDevice is a device bound to the same account, as listed by the account devices API.
+type BoundDevice struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Model     string `json:"model"`
+	Name      string `json:"name"`
+	Created   string `json:"created"`
+	Activated string `json:"activated"`
+	Active    bool   `json:"active"`
+	Role      string `json:"role"`
+}
+
+// BoundDeviceUpdate renames or (de)activates a device bound to the account.
+type BoundDeviceUpdate struct {
+	Name   string `json:"name,omitempty"`
+	Active *bool  `json:"active,omitempty"`
+}
+
+// LicenseUpdate binds the account to another license key, e.g. a WARP+ one.
+type LicenseUpdate struct {
+	License string `json:"license"`
+}
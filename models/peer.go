@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+)
+
+// PeerEndpoint is the parsed endpoint of a Peer.
+type PeerEndpoint struct {
+	V4    netip.Addr // IPv4 address of the endpoint, invalid if the peer has none
+	V6    netip.Addr // IPv6 address of the endpoint, invalid if the peer has none
+	Host  string     // Hostname of the endpoint as given by the API, if any
+	Ports []int      // Ports the endpoint accepts, if given, including the non-zero ports of the addresses
+}
+
+// ParseEndpoint extracts the addresses and ports of the peer's endpoint.
+// The API gives the addresses as ip:port and [ipv6]:port with port 0, plain addresses are accepted as well.
+//
+// Returns:
+//   - PeerEndpoint: The parsed endpoint.
+//   - error: An error if the peer has no address or an address or port is invalid.
+func (p *Peer) ParseEndpoint() (PeerEndpoint, error) {
+	endpoint := PeerEndpoint{Host: p.Endpoint.Host}
+
+	var (
+		addressPorts []int
+		port         int
+		err          error
+	)
+	if p.Endpoint.V4 != "" {
+		if endpoint.V4, port, err = parsePeerAddress(p.Endpoint.V4); err != nil {
+			return PeerEndpoint{}, fmt.Errorf("invalid IPv4 endpoint: %v", err)
+		}
+		if !endpoint.V4.Is4() {
+			return PeerEndpoint{}, fmt.Errorf("invalid IPv4 endpoint: %q is not an IPv4 address", p.Endpoint.V4)
+		}
+		addressPorts = append(addressPorts, port)
+	}
+	if p.Endpoint.V6 != "" {
+		if endpoint.V6, port, err = parsePeerAddress(p.Endpoint.V6); err != nil {
+			return PeerEndpoint{}, fmt.Errorf("invalid IPv6 endpoint: %v", err)
+		}
+		if !endpoint.V6.Is6() || endpoint.V6.Is4In6() {
+			return PeerEndpoint{}, fmt.Errorf("invalid IPv6 endpoint: %q is not an IPv6 address", p.Endpoint.V6)
+		}
+		addressPorts = append(addressPorts, port)
+	}
+	if !endpoint.V4.IsValid() && !endpoint.V6.IsValid() {
+		return PeerEndpoint{}, errors.New("the peer has no IPv4 or IPv6 endpoint")
+	}
+
+	for _, port := range p.Endpoint.Ports {
+		if port < 1 || port > 65535 {
+			return PeerEndpoint{}, fmt.Errorf("invalid endpoint port %d", port)
+		}
+		endpoint.Ports = append(endpoint.Ports, port)
+	}
+	// port 0 leaves the choice to the client
+	for _, port := range addressPorts {
+		if port != 0 && !slices.Contains(endpoint.Ports, port) {
+			endpoint.Ports = append(endpoint.Ports, port)
+		}
+	}
+
+	return endpoint, nil
+}
+
+// parsePeerAddress parses an address given as ip, ip:port or [ipv6]:port.
+func parsePeerAddress(value string) (netip.Addr, int, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.WithZone(""), 0, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(value)
+	if err != nil {
+		return netip.Addr{}, 0, fmt.Errorf("%q is not an address: %v", value, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return netip.Addr{}, 0, fmt.Errorf("%q has an invalid port", value)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, 0, fmt.Errorf("%q is not an address: %v", value, err)
+	}
+	return addr.WithZone(""), port, nil
+}
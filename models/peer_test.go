@@ -0,0 +1,88 @@
+package models
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		v4      string
+		v6      string
+		host    string
+		ports   []int
+		want    PeerEndpoint
+		wantErr bool
+	}{
+		{
+			name: "API format with port 0",
+			v4:   "162.159.198.1:0",
+			v6:   "[2606:4700:103::1]:0",
+			host: "engage.cloudflareclient.com",
+			want: PeerEndpoint{
+				V4:   netip.MustParseAddr("162.159.198.1"),
+				V6:   netip.MustParseAddr("2606:4700:103::1"),
+				Host: "engage.cloudflareclient.com",
+			},
+		},
+		{
+			name:  "ip:port",
+			v4:    "162.159.198.1:443",
+			ports: []int{443, 500},
+			want:  PeerEndpoint{V4: netip.MustParseAddr("162.159.198.1"), Ports: []int{443, 500}},
+		},
+		{
+			name:  "[v6]:port",
+			v6:    "[2606:4700:103::1]:8443",
+			ports: []int{443},
+			want:  PeerEndpoint{V6: netip.MustParseAddr("2606:4700:103::1"), Ports: []int{443, 8443}},
+		},
+		{
+			name: "missing port",
+			v4:   "162.159.198.1",
+			v6:   "2606:4700:103::1",
+			want: PeerEndpoint{V4: netip.MustParseAddr("162.159.198.1"), V6: netip.MustParseAddr("2606:4700:103::1")},
+		},
+		{
+			name: "zone dropped",
+			v6:   "fe80::1%eth0",
+			want: PeerEndpoint{V6: netip.MustParseAddr("fe80::1")},
+		},
+		{name: "empty", wantErr: true},
+		{name: "only host", host: "engage.cloudflareclient.com", wantErr: true},
+		{name: "garbage", v4: "not an address", wantErr: true},
+		{name: "hostname with port", v4: "example.com:443", wantErr: true},
+		{name: "invalid port", v4: "162.159.198.1:http", wantErr: true},
+		{name: "port out of range", v4: "162.159.198.1:65536", wantErr: true},
+		{name: "bracketed v6 without port", v6: "[2606:4700:103::1]", wantErr: true},
+		{name: "v6 as v4", v4: "2606:4700:103::1", wantErr: true},
+		{name: "v4 as v6", v6: "162.159.198.1", wantErr: true},
+		{name: "v4-mapped as v6", v6: "::ffff:162.159.198.1", wantErr: true},
+		{name: "invalid port list", v4: "162.159.198.1", ports: []int{443, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var peer Peer
+			peer.Endpoint.V4 = tt.v4
+			peer.Endpoint.V6 = tt.v6
+			peer.Endpoint.Host = tt.host
+			peer.Endpoint.Ports = tt.ports
+
+			got, err := peer.ParseEndpoint()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseEndpoint returned %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEndpoint failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEndpoint returned %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}